@@ -1,12 +1,22 @@
 package main
 
-import "github.com/dmitrijs2005/gophermart-loyalty-system/internal/app"
+import (
+	"log/slog"
+	"os"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/app"
+)
 
 func main() {
-	app := app.NewApp()
-	err := app.Run()
+	app, err := app.NewApp()
 	if err != nil {
-		panic(err)
+		slog.Error("Error reading config", "err", err.Error())
+		os.Exit(1)
+	}
+
+	if err := app.Run(); err != nil {
+		slog.Error("Stopped with error", "err", err.Error())
+		os.Exit(1)
 	}
 
 }
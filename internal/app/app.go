@@ -2,32 +2,38 @@ package app
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/notifier"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/server"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/task"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/tracing"
 )
 
 type App struct {
 	config *config.Config
 }
 
-func NewApp() *App {
+func NewApp() (*App, error) {
 
 	config, err := config.ParseConfig()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return &App{config: config}
+	return &App{config: config}, nil
 }
 
 func (app *App) initSignalHandler(cancelFunc context.CancelFunc) {
@@ -68,6 +74,7 @@ func (app *App) initRepository(ctx context.Context) (repository.Repository, erro
 	if ok {
 		err := dbRepo.RunMigrations(ctx)
 		if err != nil {
+			_ = dbRepo.Close()
 			return nil, err
 		}
 	}
@@ -76,18 +83,65 @@ func (app *App) initRepository(ctx context.Context) (repository.Repository, erro
 
 }
 
-func (app *App) startHTTPServer(ctx context.Context, cancelFunc context.CancelFunc,
-	wg *sync.WaitGroup, serviceProvider *service.ServiceProvider, logger *slog.Logger) {
+// loads token signing keys, falls back to the secret key if no key files are configured,
+// the default secret key is public, so tokens signed with it could be forged by anyone
+func (app *App) initKeys() (*auth.KeySet, error) {
+
+	if len(app.config.JWTKeyFiles) == 0 {
+		if app.config.SecretKey == "" || app.config.SecretKey == config.DefaultSecretKey {
+			return nil, common.ErrorDefaultSecretKey
+		}
+		return auth.NewHMACKeySet(app.config.SecretKey), nil
+	}
+
+	return auth.LoadKeySet(app.config.JWTKeyFiles)
+}
+
+// user notifications go to the file if it is configured, otherwise to the log
+func (app *App) initNotifier(logger *slog.Logger) notifier.Notifier {
+
+	if app.config.NotifierFile != "" {
+		return notifier.NewFileNotifier(app.config.NotifierFile)
+	}
+
+	return notifier.NewLogNotifier(logger)
+}
+
+// closes the connection pool once the server and the checker are stopped
+func (app *App) closeRepository(repo repository.Repository, logger *slog.Logger) error {
+
+	dbRepo, ok := repo.(repository.DBStorage)
+	if !ok {
+		return nil
+	}
+
+	if err := dbRepo.Close(); err != nil {
+		logger.Error("Error closing database", "err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// server failure stops the whole application, errors are sent to errs
+func (app *App) startHTTPServer(ctx context.Context, cancelFunc context.CancelFunc, wg *sync.WaitGroup,
+	errs chan<- error, serviceProvider *service.ServiceProvider, logger *slog.Logger) {
 
 	wg.Add(1)
 
 	go func() {
 		defer wg.Done()
+
 		server, err := server.NewHTTPServer(app.config, serviceProvider, logger)
 		if err != nil {
+			logger.Error("Error creating server", "err", err.Error())
+			errs <- err
 			cancelFunc()
+			return
 		}
+
 		if err := server.Run(ctx); err != nil {
+			errs <- err
 			cancelFunc()
 		}
 	}()
@@ -105,28 +159,90 @@ func (app *App) startCheckingTask(ctx context.Context, wg *sync.WaitGroup,
 	}()
 }
 
-func (app *App) Run() error {
+func (app *App) startPurgeTask(ctx context.Context, wg *sync.WaitGroup,
+	serviceProvider *service.ServiceProvider, logger *slog.Logger) {
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		task := task.NewIdempotencyPurgeTask(serviceProvider.IdempotencyService, logger)
+		task.Start(ctx)
+	}()
+}
+
+// Run starts the server and the accrual checker and blocks until they are stopped by a signal
+// or a failure, the returned error is nil only if everything was shut down cleanly
+func (app *App) Run() (err error) {
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
 
 	app.initSignalHandler(cancelFunc)
 
+	logger, err := logging.New(app.config.LogLevel, app.config.LogFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
 	repository, err := app.initRepository(ctx)
 	if err != nil {
 		return err
 	}
+	// the pool is closed last, when nothing uses it anymore
+	defer func() {
+		err = errors.Join(err, app.closeRepository(repository, logger))
+	}()
 
-	logger := logging.NewLogger()
+	shutdownTracing, err := tracing.Setup(ctx, app.config)
+	if err != nil {
+		return err
+	}
+	// spans are flushed even though the application context is cancelled by now
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("Error flushing traces", "err", err.Error())
+		}
+	}()
 
-	serviceProvider := service.NewServiceProvider(repository, app.config, logger)
+	keys, err := app.initKeys()
+	if err != nil {
+		return err
+	}
+
+	// unknown algorithm should stop the start, not every registration
+	if _, err := auth.NewPasswordHasher(app.config.PasswordHashAlgorithm); err != nil {
+		return err
+	}
+
+	policy, err := service.NewCredentialsPolicy(app.config)
+	if err != nil {
+		return err
+	}
+
+	serviceProvider := service.NewServiceProvider(repository, app.config, keys, policy, app.initNotifier(logger), metrics.New(), logger)
+
+	err = serviceProvider.AdminService.BootstrapAdmins(ctx, app.config.AdminLogins)
+	if err != nil {
+		return err
+	}
 
 	var wg sync.WaitGroup
+	errs := make(chan error, 1)
 
-	app.startHTTPServer(ctx, cancelFunc, &wg, serviceProvider, logger)
+	// on shutdown the server drains the in-flight requests while the checker finishes the orders being checked
+	app.startHTTPServer(ctx, cancelFunc, &wg, errs, serviceProvider, logger)
 	app.startCheckingTask(ctx, &wg, serviceProvider, logger)
+	app.startPurgeTask(ctx, &wg, serviceProvider, logger)
 
 	wg.Wait()
+	close(errs)
 
-	return nil
+	for e := range errs {
+		err = errors.Join(err, e)
+	}
+
+	return err
 
 }
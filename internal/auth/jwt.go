@@ -5,24 +5,49 @@ import (
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-// Claims — структура утверждений, которая включает стандартные утверждения и
-// одно пользовательское UserID
+// Claims — структура утверждений, пользователь передаётся в стандартном утверждении sub,
+// его роль — в утверждении role
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string
+	Role string `json:"role,omitempty"`
 }
 
-func GenerateToken(userID string, secretKey string, validityDuration *time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// TokenOptions are the claims tokens are issued with and checked against
+type TokenOptions struct {
+	Issuer   string
+	Audience string
+	Validity time.Duration
+	// allowed clock skew between the issuer and the verifier
+	Leeway time.Duration
+}
+
+// GenerateToken issues the access token, the role is fixed in the token until it expires
+func GenerateToken(userID string, role string, keys *KeySet, opts TokenOptions) (string, error) {
+	key := keys.SigningKey()
+	now := time.Now()
+
+	token := jwt.NewWithClaims(key.Method, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(*validityDuration)),
+			ID:        uuid.NewString(),
+			Subject:   userID,
+			Issuer:    opts.Issuer,
+			Audience:  jwt.ClaimStrings{opts.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(opts.Validity)),
 		},
-		UserID: userID,
+		Role: role,
 	})
 
-	tokenString, err := token.SignedString([]byte(secretKey))
+	// verifiers pick the key by id, so that the keys can be rotated
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	tokenString, err := token.SignedString(key.signKey)
 	if err != nil {
 		return "", err
 	}
@@ -30,19 +55,64 @@ func GenerateToken(userID string, secretKey string, validityDuration *time.Durat
 	return tokenString, nil
 }
 
-func GetUserIDFromToken(tokenString string, secretKey string) (string, error) {
+func GetUserIDFromToken(tokenString string, keys *KeySet, opts TokenOptions) (string, error) {
+	claims, err := ParseToken(tokenString, keys, opts)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// ParseToken checks the token signature and claims and returns the claims
+func ParseToken(tokenString string, keys *KeySet, opts TokenOptions) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
+	// time claims are checked below, as the parser knows nothing about leeway
+	parser := jwt.NewParser(jwt.WithValidMethods(keys.Algorithms()), jwt.WithoutClaimsValidation())
+
+	token, err := parser.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := keys.Lookup(kid)
+		if !ok {
+			return nil, common.ErrorInvalidToken
+		}
+		// the algorithm is defined by the key, not by the token
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, common.ErrorInvalidToken
+		}
+		return key.verifyKey, nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", common.ErrorInvalidToken
+		return nil, common.ErrorInvalidToken
+	}
+
+	if err := validateClaims(claims, opts, time.Now()); err != nil {
+		return nil, err
 	}
 
-	return claims.UserID, nil
+	return claims, nil
+}
+
+func validateClaims(claims *Claims, opts TokenOptions, now time.Time) error {
+	if !claims.VerifyExpiresAt(now.Add(-opts.Leeway), true) {
+		return common.ErrorTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(opts.Leeway), false) || !claims.VerifyIssuedAt(now.Add(opts.Leeway), false) {
+		return common.ErrorTokenNotValidYet
+	}
+	// empty issuer or audience disables the check
+	if opts.Issuer != "" && !claims.VerifyIssuer(opts.Issuer, true) {
+		return common.ErrorInvalidTokenIssuer
+	}
+	if opts.Audience != "" && !claims.VerifyAudience(opts.Audience, true) {
+		return common.ErrorInvalidTokenAudience
+	}
+	if claims.Subject == "" {
+		return common.ErrorNoUserID
+	}
+	return nil
 }
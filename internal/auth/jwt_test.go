@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateToken(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	opts := TokenOptions{Issuer: "issuer", Audience: "audience", Validity: time.Minute}
+
+	tokenString, err := GenerateToken("user1", "admin", keys, opts)
+	require.NoError(t, err)
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) { return []byte("secret"), nil })
+	require.NoError(t, err)
+
+	require.Equal(t, "user1", claims.Subject)
+	require.Equal(t, "issuer", claims.Issuer)
+	require.Equal(t, jwt.ClaimStrings{"audience"}, claims.Audience)
+	require.NotEmpty(t, claims.ID)
+	require.NotNil(t, claims.IssuedAt)
+	require.NotNil(t, claims.NotBefore)
+	require.Equal(t, claims.IssuedAt.Add(time.Minute), claims.ExpiresAt.Time)
+	require.Equal(t, "admin", claims.Role)
+
+	parsed, err := ParseToken(tokenString, keys, opts)
+	require.NoError(t, err)
+	require.Equal(t, "user1", parsed.Subject)
+	require.Equal(t, "admin", parsed.Role)
+}
+
+func TestGetUserIDFromToken(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	opts := TokenOptions{Issuer: "issuer", Audience: "audience", Leeway: 30 * time.Second}
+	now := time.Now()
+
+	sign := func(method jwt.SigningMethod, claims jwt.RegisteredClaims) string {
+		var key interface{} = []byte("secret")
+		if method == jwt.SigningMethodNone {
+			key = jwt.UnsafeAllowNoneSignatureType
+		}
+		token, err := jwt.NewWithClaims(method, Claims{RegisteredClaims: claims}).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+
+	valid := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Subject:   "user1",
+			Issuer:    "issuer",
+			Audience:  jwt.ClaimStrings{"audience"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		method  jwt.SigningMethod
+		modify  func(c *jwt.RegisteredClaims)
+		wantErr error
+	}{
+		{"Valid", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) {}, nil},
+		{"Expired within leeway", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+		}, nil},
+		{"Expired", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+		}, common.ErrorTokenExpired},
+		{"No expiration", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }, common.ErrorTokenExpired},
+		{"Issued in the future within leeway", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(10 * time.Second))
+			c.NotBefore = c.IssuedAt
+		}, nil},
+		{"Not valid yet", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute))
+		}, common.ErrorTokenNotValidYet},
+		{"Wrong issuer", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) { c.Issuer = "other" }, common.ErrorInvalidTokenIssuer},
+		{"Wrong audience", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"other"}
+		}, common.ErrorInvalidTokenAudience},
+		{"No subject", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) { c.Subject = "" }, common.ErrorNoUserID},
+		{"Unexpected method", jwt.SigningMethodHS512, func(c *jwt.RegisteredClaims) {}, common.ErrorInvalidToken},
+		{"None method", jwt.SigningMethodNone, func(c *jwt.RegisteredClaims) {}, common.ErrorInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(&claims)
+
+			userID, err := GetUserIDFromToken(sign(tt.method, claims), keys, opts)
+
+			switch {
+			case tt.wantErr == nil:
+				require.NoError(t, err)
+				require.Equal(t, "user1", userID)
+			case tt.wantErr == common.ErrorInvalidToken:
+				// rejected by the parser before the claims are checked
+				require.Error(t, err)
+			default:
+				require.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
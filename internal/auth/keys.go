@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const minRSAKeyBits = 2048
+
+// Key is a token signing key, keys loaded from public key files can only verify tokens
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	signKey interface{}
+	// key used to verify signatures, the public key or the HMAC secret
+	verifyKey interface{}
+}
+
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// KeySet holds the keys tokens are signed and verified with. The first key signs new tokens,
+// the rest only verify tokens signed before the rotation
+type KeySet struct {
+	keys []*Key
+	byID map[string]*Key
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("empty key set")
+	}
+	if !keys[0].CanSign() {
+		return nil, fmt.Errorf("signing key %q has no private part", keys[0].ID)
+	}
+
+	set := &KeySet{keys: keys, byID: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if _, ok := set.byID[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		set.byID[k.ID] = k
+	}
+
+	return set, nil
+}
+
+// NewHMACKeySet returns a key set of the single HS256 key, used when no key files are configured
+func NewHMACKeySet(secretKey string) *KeySet {
+	key := &Key{Method: jwt.SigningMethodHS256, signKey: []byte(secretKey), verifyKey: []byte(secretKey)}
+	return &KeySet{keys: []*Key{key}, byID: map[string]*Key{"": key}}
+}
+
+// LoadKeySet loads keys from PEM files, key id is the file name without extension.
+// The first file should contain a private key, the rest may contain public keys only
+func LoadKeySet(paths []string) (*KeySet, error) {
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		key, err := ParseKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys...)
+}
+
+// ParseKey parses PEM encoded RSA or Ed25519 key, private (PKCS#8 or PKCS#1) or public (PKIX)
+func ParseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id}
+
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.signKey = signer
+		parsed = signer.Public()
+	}
+
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key should be at least %d bits", minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.verifyKey = public
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.verifyKey = public
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	return key, nil
+}
+
+// SigningKey returns the key new tokens are signed with
+func (s *KeySet) SigningKey() *Key {
+	return s.keys[0]
+}
+
+// Lookup returns the key by id taken from the token header
+func (s *KeySet) Lookup(id string) (*Key, bool) {
+	k, ok := s.byID[id]
+	return k, ok
+}
+
+// Algorithms returns the signing algorithms of the keys, tokens signed otherwise are rejected
+func (s *KeySet) Algorithms() []string {
+	algs := make([]string, 0, len(s.keys))
+	for _, k := range s.keys {
+		algs = append(algs, k.Method.Alg())
+	}
+	return algs
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, HMAC secrets are never published
+func (s *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range s.keys {
+		jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+		switch public := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
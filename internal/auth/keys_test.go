@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	require.NoError(t, err)
+	edPath := writePEM(t, dir, "ed-2025.pem", "PRIVATE KEY", der)
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPath := writePEM(t, dir, "rsa-2024.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate))
+
+	der, err = x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	require.NoError(t, err)
+	rsaPublicPath := writePEM(t, dir, "rsa-2024.pub", "PUBLIC KEY", der)
+
+	smallRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	smallPath := writePEM(t, dir, "small.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(smallRSA))
+
+	opts := TokenOptions{Issuer: "issuer", Audience: "audience", Validity: time.Minute}
+
+	t.Run("Rotation", func(t *testing.T) {
+		oldKeys, err := LoadKeySet([]string{rsaPath})
+		require.NoError(t, err)
+		require.Equal(t, "RS256", oldKeys.SigningKey().Method.Alg())
+
+		oldToken, err := GenerateToken("user1", "", oldKeys, opts)
+		require.NoError(t, err)
+
+		// new key signs, the old one is kept to verify tokens issued before the rotation
+		newKeys, err := LoadKeySet([]string{edPath, rsaPublicPath})
+		require.NoError(t, err)
+		require.Equal(t, "EdDSA", newKeys.SigningKey().Method.Alg())
+
+		newToken, err := GenerateToken("user2", "", newKeys, opts)
+		require.NoError(t, err)
+
+		userID, err := GetUserIDFromToken(oldToken, newKeys, opts)
+		require.NoError(t, err)
+		require.Equal(t, "user1", userID)
+
+		userID, err = GetUserIDFromToken(newToken, newKeys, opts)
+		require.NoError(t, err)
+		require.Equal(t, "user2", userID)
+
+		// token signed with a key which is no longer in the set
+		_, err = GetUserIDFromToken(newToken, oldKeys, opts)
+		require.Error(t, err)
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		keys, err := LoadKeySet([]string{edPath, rsaPublicPath})
+		require.NoError(t, err)
+
+		jwks := keys.JWKS()
+		require.Len(t, jwks.Keys, 2)
+		require.Equal(t, "ed-2025", jwks.Keys[0].Kid)
+		require.Equal(t, "OKP", jwks.Keys[0].Kty)
+		require.Equal(t, "rsa-2024", jwks.Keys[1].Kid)
+		require.Equal(t, "RSA", jwks.Keys[1].Kty)
+		require.Equal(t, "AQAB", jwks.Keys[1].E)
+
+		require.Empty(t, NewHMACKeySet("secret").JWKS().Keys)
+	})
+
+	t.Run("Algorithm confusion", func(t *testing.T) {
+		keys, err := LoadKeySet([]string{rsaPath})
+		require.NoError(t, err)
+
+		// HS256 token "signed" with the public key must not be accepted
+		publicPEM, err := os.ReadFile(rsaPublicPath)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "user1", Issuer: "issuer", Audience: jwt.ClaimStrings{"audience"}}})
+		token.Header["kid"] = "rsa-2024"
+		forged, err := token.SignedString(publicPEM)
+		require.NoError(t, err)
+
+		_, err = GetUserIDFromToken(forged, keys, opts)
+		require.Error(t, err)
+	})
+
+	t.Run("Invalid key sets", func(t *testing.T) {
+		_, err := LoadKeySet([]string{rsaPublicPath})
+		require.Error(t, err, "signing key without private part")
+
+		_, err = LoadKeySet([]string{smallPath})
+		require.Error(t, err, "too small RSA key")
+
+		_, err = LoadKeySet([]string{rsaPath, rsaPublicPath})
+		require.Error(t, err, "duplicate key id")
+
+		_, err = LoadKeySet([]string{filepath.Join(dir, "missing.pem")})
+		require.Error(t, err)
+
+		_, err = LoadKeySet(nil)
+		require.Error(t, err)
+	})
+}
+
+func TestHMACKeySet(t *testing.T) {
+	opts := TokenOptions{Issuer: "issuer", Audience: "audience", Validity: time.Minute}
+
+	token, err := GenerateToken("user1", "", NewHMACKeySet("secret"), opts)
+	require.NoError(t, err)
+
+	userID, err := GetUserIDFromToken(token, NewHMACKeySet("secret"), opts)
+	require.NoError(t, err)
+	require.Equal(t, "user1", userID)
+
+	_, err = GetUserIDFromToken(token, NewHMACKeySet("other"), opts)
+	require.Error(t, err)
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+
+	// legacy hashes, verified only
+	passwordHashPBKDF2 = "pbkdf2-sha256"
+)
+
+// PasswordHasher hashes passwords into PHC strings ($algorithm$parameters$salt$hash),
+// so that the algorithm and its parameters can be changed without breaking stored hashes
+type PasswordHasher struct {
+	algorithm string
+
+	// argon2id parameters, RFC 9106 recommendation for memory constrained environments
+	memory  uint32 // KiB
+	time    uint32
+	threads uint8
+	saltLen int
+	keyLen  uint32
+
+	bcryptCost int
+}
+
+// NewPasswordHasher returns the hasher of the algorithm, argon2id if the algorithm is empty
+func NewPasswordHasher(algorithm string) (*PasswordHasher, error) {
+	switch algorithm {
+	case "":
+		algorithm = PasswordHashArgon2id
+	case PasswordHashArgon2id, PasswordHashBcrypt:
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", algorithm)
+	}
+
+	return &PasswordHasher{
+		algorithm:  algorithm,
+		memory:     64 * 1024,
+		time:       3,
+		threads:    4,
+		saltLen:    16,
+		keyLen:     32,
+		bcryptCost: 12,
+	}, nil
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm == PasswordHashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt, err := GenerateSalt(h.saltLen)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLen)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PasswordHashArgon2id, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against the encoded hash, needsRehash is set if the hash was produced
+// by another algorithm or with other parameters than the hasher uses
+func (h *PasswordHasher) Verify(password string, encoded string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$"+PasswordHashArgon2id+"$"):
+		return h.verifyArgon2id(password, encoded)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return h.verifyBcrypt(password, encoded)
+	case strings.HasPrefix(encoded, "$"+passwordHashPBKDF2+"$"):
+		ok, err := verifyPBKDF2(password, encoded)
+		return ok, true, err
+	default:
+		return false, false, common.ErrorUnsupportedPasswordHash
+	}
+}
+
+func (h *PasswordHasher) verifyArgon2id(password string, encoded string) (bool, bool, error) {
+	// $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, common.ErrorUnsupportedPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, common.ErrorUnsupportedPasswordHash
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, common.ErrorUnsupportedPasswordHash
+	}
+
+	salt, hash, err := decodeSaltAndHash(parts[4], parts[5])
+	if err != nil {
+		return false, false, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(hash)))
+	ok := subtle.ConstantTimeCompare(key, hash) == 1
+
+	needsRehash := h.algorithm != PasswordHashArgon2id || memory != h.memory || time != h.time ||
+		threads != h.threads || uint32(len(hash)) != h.keyLen
+
+	return ok, needsRehash, nil
+}
+
+func (h *PasswordHasher) verifyBcrypt(password string, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, h.algorithm != PasswordHashBcrypt || cost != h.bcryptCost, nil
+}
+
+// hashes of the previous versions, converted into the $pbkdf2-sha256$i=100000$salt$hash form by a migration
+func verifyPBKDF2(password string, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return false, common.ErrorUnsupportedPasswordHash
+	}
+
+	var iter int
+	if _, err := fmt.Sscanf(parts[2], "i=%d", &iter); err != nil || iter < 1 {
+		return false, common.ErrorUnsupportedPasswordHash
+	}
+
+	salt, hash, err := decodeSaltAndHash(parts[3], parts[4])
+	if err != nil {
+		return false, err
+	}
+
+	key := pbkdf2.Key([]byte(password), salt, iter, len(hash), sha256.New)
+	return subtle.ConstantTimeCompare(key, hash) == 1, nil
+}
+
+func decodeSaltAndHash(encodedSalt string, encodedHash string) ([]byte, []byte, error) {
+	salt, err := base64.RawStdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, common.ErrorUnsupportedPasswordHash
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(encodedHash)
+	if err != nil || len(hash) == 0 {
+		return nil, nil, common.ErrorUnsupportedPasswordHash
+	}
+	return salt, hash, nil
+}
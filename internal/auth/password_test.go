@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordHasher_Hash(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		prefix    string
+	}{
+		{"Default", "", "$argon2id$v=19$m=65536,t=3,p=4$"},
+		{"Argon2id", PasswordHashArgon2id, "$argon2id$v=19$m=65536,t=3,p=4$"},
+		{"Bcrypt", PasswordHashBcrypt, "$2a$12$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewPasswordHasher(tt.algorithm)
+			require.NoError(t, err)
+
+			hash, err := h.Hash("MySuperSecretPassword")
+			require.NoError(t, err)
+			require.Contains(t, hash, tt.prefix)
+
+			// salted, hashes of the same password differ
+			other, err := h.Hash("MySuperSecretPassword")
+			require.NoError(t, err)
+			require.NotEqual(t, hash, other)
+
+			ok, needsRehash, err := h.Verify("MySuperSecretPassword", hash)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.False(t, needsRehash)
+
+			ok, _, err = h.Verify("MyOtherSecretPassword", hash)
+			require.NoError(t, err)
+			require.False(t, ok)
+		})
+	}
+
+	_, err := NewPasswordHasher("md5")
+	require.Error(t, err)
+}
+
+func TestPasswordHasher_Verify(t *testing.T) {
+	h, err := NewPasswordHasher(PasswordHashArgon2id)
+	require.NoError(t, err)
+
+	bcryptHasher, err := NewPasswordHasher(PasswordHashBcrypt)
+	require.NoError(t, err)
+	bcryptHash, err := bcryptHasher.Hash("MySuperSecretPassword")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		password    string
+		encoded     string
+		ok          bool
+		needsRehash bool
+		wantErr     error
+	}{
+		// hashes of the previous versions, PBKDF2-SHA256 with 100000 iterations
+		{"PBKDF2 1", "MySuperSecretPassword", "$pbkdf2-sha256$i=100000$HHxqo4TGJzrcIBcv4Z1fAw$zHFUFI4QtHWwLIJ1jpYEzQ65fhSk0/Yp+yyAY/DkE2I", true, true, nil},
+		{"PBKDF2 2", "MyOtherSecretPassword", "$pbkdf2-sha256$i=100000$DLRmyu5WrvxTvOpvRG89CQ$ybgSrD3nnHaYnthQBosGa7aL5F9Uy14kqlwC/E4MY5E", true, true, nil},
+		{"PBKDF2 wrong password", "MyOtherSecretPassword", "$pbkdf2-sha256$i=100000$HHxqo4TGJzrcIBcv4Z1fAw$zHFUFI4QtHWwLIJ1jpYEzQ65fhSk0/Yp+yyAY/DkE2I", false, true, nil},
+		{"Argon2id other parameters", "password", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$/2vPlt5y1QZ1xIx1NbZOifuXR1vSC3gVjb5wR2WzC8A", false, true, nil},
+		{"Bcrypt", "MySuperSecretPassword", bcryptHash, true, true, nil},
+		{"Plaintext", "password", "password", false, false, common.ErrorUnsupportedPasswordHash},
+		{"Malformed", "password", "$argon2id$v=19$m=1024$c2FsdA$aGFzaA", false, false, common.ErrorUnsupportedPasswordHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := h.Verify(tt.password, tt.encoded)
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.needsRehash, needsRehash)
+		})
+	}
+}
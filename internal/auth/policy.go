@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+)
+
+const (
+	// longer passwords are truncated by bcrypt
+	maxPasswordBytes = 72
+)
+
+var loginCharset = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+// CredentialsPolicy is the set of rules logins and passwords are checked against on registration,
+// zero value only requires both to be non-empty
+type CredentialsPolicy struct {
+	LoginMinLength     int
+	LoginMaxLength     int
+	PasswordMinLength  int
+	PasswordMinClasses int // of lower case letters, upper case letters, digits and other characters
+	denylist           map[string]struct{}
+}
+
+// LoadDenylist loads common or breached passwords, one per line, the check is case-insensitive
+func (p *CredentialsPolicy) LoadDenylist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p.denylist = make(map[string]struct{})
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			p.denylist[strings.ToLower(password)] = struct{}{}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// failFunc records a broken rule of the field
+type failFunc func(field string, rule string, format string, args ...interface{})
+
+// Validate returns every rule the login and the password break, nil if there are none
+func (p *CredentialsPolicy) Validate(login string, password string) error {
+	return check(func(fail failFunc) {
+		p.validateLogin(login, fail)
+		p.validatePassword(login, password, fail)
+	})
+}
+
+// ValidatePassword returns every rule the new password of a registered login breaks, nil if there are none,
+// the login itself is not checked, since it could have been registered under other rules
+func (p *CredentialsPolicy) ValidatePassword(login string, password string) error {
+	return check(func(fail failFunc) {
+		p.validatePassword(login, password, fail)
+	})
+}
+
+func check(validate func(fail failFunc)) error {
+	var errs []common.FieldError
+
+	validate(func(field string, rule string, format string, args ...interface{}) {
+		errs = append(errs, common.FieldError{Field: field, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	})
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &common.ValidationError{Errors: errs}
+}
+
+func (p *CredentialsPolicy) validateLogin(login string, fail failFunc) {
+	loginLength := utf8.RuneCountInString(login)
+	switch {
+	case login == "":
+		fail("login", "required", "login is required")
+	case loginLength < p.LoginMinLength:
+		fail("login", "min_length", "login should be at least %d characters long", p.LoginMinLength)
+	case p.LoginMaxLength > 0 && loginLength > p.LoginMaxLength:
+		fail("login", "max_length", "login should be at most %d characters long", p.LoginMaxLength)
+	}
+	if login != "" && !loginCharset.MatchString(login) {
+		fail("login", "charset", "login may contain only latin letters, digits and . _ @ -")
+	}
+}
+
+func (p *CredentialsPolicy) validatePassword(login string, password string, fail failFunc) {
+	if password == "" {
+		fail("password", "required", "password is required")
+		return
+	}
+
+	if utf8.RuneCountInString(password) < p.PasswordMinLength {
+		fail("password", "min_length", "password should be at least %d characters long", p.PasswordMinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		fail("password", "max_length", "password should be at most %d bytes long", maxPasswordBytes)
+	}
+	if classes := characterClasses(password); classes < p.PasswordMinClasses {
+		fail("password", "char_classes", "password should contain at least %d of: lower case letters, upper case letters, digits, other characters",
+			p.PasswordMinClasses)
+	}
+	if _, ok := p.denylist[strings.ToLower(password)]; ok {
+		fail("password", "denylist", "password is too common")
+	}
+	if login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		fail("password", "contains_login", "password should not contain the login")
+	}
+}
+
+func characterClasses(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsPolicy_Validate(t *testing.T) {
+	denylist := filepath.Join(t.TempDir(), "denylist.txt")
+	err := os.WriteFile(denylist, []byte("qwerty123\n\n  Password-1  \n"), 0o600)
+	require.NoError(t, err)
+
+	policy := &CredentialsPolicy{LoginMinLength: 3, LoginMaxLength: 16, PasswordMinLength: 8, PasswordMinClasses: 3}
+	require.NoError(t, policy.LoadDenylist(denylist))
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		// failed rules in the order they are reported
+		want []string
+	}{
+		{"OK", "user.name@mail", "Secret-pass1", nil},
+		{"Empty", "", "", []string{"login:required", "password:required"}},
+		{"Short login", "us", "Secret-pass1", []string{"login:min_length"}},
+		{"Long login", "user_with_a_very_long_login", "Secret-pass1", []string{"login:max_length"}},
+		{"Login charset", "user name!", "Secret-pass1", []string{"login:charset"}},
+		{"Short password", "user", "Sec-1", []string{"password:min_length"}},
+		{"Long password", "user", "Secret-pass1" + string(make([]byte, 70)), []string{"password:max_length"}},
+		{"Character classes", "user", "secretpassword", []string{"password:char_classes"}},
+		{"Denylist", "user", "PASSWORD-1", []string{"password:denylist"}},
+		{"Contains login", "User", "my-user-Pass1", []string{"password:contains_login"}},
+		{"Every rule", "u!", "qwerty123", []string{"login:min_length", "login:charset", "password:char_classes", "password:denylist"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.login, tt.password)
+			if tt.want == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			var validationErr *common.ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			require.ErrorIs(t, err, common.ErrorValidation)
+
+			var got []string
+			for _, fe := range validationErr.Errors {
+				got = append(got, fe.Field+":"+fe.Rule)
+				require.NotEmpty(t, fe.Message)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCredentialsPolicy_ValidatePassword(t *testing.T) {
+	policy := &CredentialsPolicy{LoginMinLength: 5, LoginMaxLength: 16, PasswordMinLength: 8, PasswordMinClasses: 3}
+
+	// logins registered under older rules are not checked again
+	require.NoError(t, policy.ValidatePassword("us", "Secret-pass1"))
+	require.NoError(t, policy.ValidatePassword("user name!", "Secret-pass1"))
+
+	require.ErrorIs(t, policy.ValidatePassword("us", ""), common.ErrorInvalidPasswordFormat)
+	require.ErrorIs(t, policy.ValidatePassword("us", "Sec-1"), common.ErrorInvalidPasswordFormat)
+	require.ErrorIs(t, policy.ValidatePassword("User", "my-user-Pass1"), common.ErrorInvalidPasswordFormat)
+	require.NotErrorIs(t, policy.ValidatePassword("us", "Sec-1"), common.ErrorInvalidLoginFormat)
+}
+
+func TestCredentialsPolicy_ZeroValue(t *testing.T) {
+	policy := &CredentialsPolicy{}
+
+	require.NoError(t, policy.Validate("login", "password"))
+	require.ErrorIs(t, policy.Validate("", "password"), common.ErrorInvalidLoginFormat)
+	require.ErrorIs(t, policy.Validate("login", ""), common.ErrorInvalidPasswordFormat)
+
+	require.Error(t, policy.LoadDenylist(filepath.Join(t.TempDir(), "missing.txt")))
+}
@@ -8,6 +8,7 @@ var (
 	ErrorNotFound      = errors.New("not found")
 	ErrorAlreadyExists = errors.New("already exists")
 	ErrorValidation    = errors.New("validation error")
+	ErrorInvalidCursor = errors.New("invalid cursor")
 
 	// auth-specific errors
 	ErrorInvalidAuthheaderFormat = errors.New("invalid auth header format")
@@ -17,17 +18,51 @@ var (
 	ErrorInvalidLoginFormat      = errors.New("invalid login format")
 	ErrorInvalidPasswordFormat   = errors.New("invalid password format")
 	ErrorInvalidLoginPassword    = errors.New("invalid login/password")
+	ErrorRefreshTokenReused      = errors.New("refresh token reused")
+	ErrorTokenExpired            = errors.New("token expired")
+	ErrorTokenNotValidYet        = errors.New("token not valid yet")
+	ErrorInvalidTokenIssuer      = errors.New("invalid token issuer")
+	ErrorInvalidTokenAudience    = errors.New("invalid token audience")
+	ErrorUnsupportedPasswordHash = errors.New("unsupported password hash")
+	ErrorTooManyLoginAttempts    = errors.New("too many login attempts")
+	ErrorInvalidResetToken       = errors.New("invalid or expired password reset token")
+	ErrorForbidden               = errors.New("forbidden")
+	ErrorUnknownRole             = errors.New("unknown role")
+	ErrorDefaultSecretKey        = errors.New("jwt key files or a non-default secret key should be configured")
 
 	// order-specific errors
 	ErrorNoOrderNumberSpecified   = errors.New("no order number specified")
 	ErrorInvalidOrderNumberFormat = errors.New("invalid order number format")
 	ErrorOrderDoesNotExist        = errors.New("order does not exist")
 	ErrorOrderAlreadyExists       = errors.New("order already exists")
+	ErrorOrderLeaseLost           = errors.New("order is leased by another instance")
 
 	// balance-specific errors
-	ErrorInsufficientBalance = errors.New("insufficient balance")
+	ErrorInsufficientBalance     = errors.New("insufficient balance")
+	ErrorWithdrawalAlreadyExists = errors.New("withdrawal for this order already exists")
+	ErrorUnknownAdjustmentReason = errors.New("unknown adjustment reason")
+	ErrorZeroAdjustment          = errors.New("adjustment amount should not be zero")
 
-	// in-memory repository specific errors
+	// idempotency errors
+	ErrorIdempotencyKeyMismatch   = errors.New("idempotency key used with another request")
+	ErrorIdempotencyKeyInProgress = errors.New("request with this idempotency key is in progress")
+
+	// accrual system specific errors
+	ErrorTooManyRequests         = errors.New("too many requests")
+	ErrorUnexpectedStatusCode    = errors.New("unexpected status code")
+	ErrorUnexpectedAccrualStatus = errors.New("unexpected accrual status")
+
+	// health errors
+	ErrorMigrationsPending = errors.New("database migrations are pending")
+	ErrorShuttingDown      = errors.New("shutting down")
+
+	// logging errors
+	ErrorUnknownLogFormat = errors.New("unknown log format")
+
+	// tracing errors
+	ErrorUnknownTraceExporter = errors.New("unknown trace exporter")
+
+	// transaction errors
 	ErrorAlreadyInTranscation = errors.New("already in transaction")
 	ErrorNotInTranscation     = errors.New("not in transaction")
 )
@@ -0,0 +1,18 @@
+package common
+
+import "time"
+
+// RetryAfterError is returned when the request is refused until the given time has passed,
+// it matches the wrapped error
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
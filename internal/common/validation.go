@@ -0,0 +1,44 @@
+package common
+
+import (
+	"strings"
+)
+
+// FieldError is a single failed validation rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every failed rule, so that the client can fix all of them at once.
+// It matches ErrorValidation and the format error of each failed field
+type ValidationError struct {
+	Errors []FieldError
+}
+
+// fields and the errors they are reported with
+var fieldErrors = map[string]error{
+	"login":    ErrorInvalidLoginFormat,
+	"password": ErrorInvalidPasswordFormat,
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		messages = append(messages, fe.Field+": "+fe.Message)
+	}
+	return ErrorValidation.Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	if target == ErrorValidation {
+		return true
+	}
+	for _, fe := range e.Errors {
+		if err, ok := fieldErrors[fe.Field]; ok && err == target {
+			return true
+		}
+	}
+	return false
+}
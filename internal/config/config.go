@@ -1,13 +1,58 @@
 package config
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// DefaultSecretKey is the -k flag default, tokens must not be signed with it
+const DefaultSecretKey = "secretKey"
 
 type Config struct {
-	RunAddress            string
-	DatabaseURI           string
-	AccrualSystemAddress  string
-	SecretKey             string
-	TokenValidityDuration time.Duration
+	RunAddress                   string
+	DatabaseURI                  string
+	AccrualSystemAddress         string
+	SecretKey                    string
+	TokenValidityDuration        time.Duration
+	AccrualWorkers               int
+	AccrualMaxOrderAge           time.Duration
+	AccrualRateLimit             float64 // accrual system requests per second shared by all workers, 0 disables the limit
+	RefreshTokenValidityDuration time.Duration
+	JWTKeyFiles                  []string // PEM files of the token signing keys, the first one signs
+	JWTIssuer                    string
+	JWTAudience                  string
+	JWTLeeway                    time.Duration // allowed clock skew when checking token time claims
+	PasswordHashAlgorithm        string        // argon2id or bcrypt
+	PasswordMinLength            int
+	PasswordMinClasses           int    // of lower case letters, upper case letters, digits and other characters
+	PasswordDenylistFile         string // common passwords, one per line
+	LoginMinLength               int
+	LoginMaxLength               int
+	LoginMaxFailures             int // failed logins in a row before the login is locked, 0 disables the lockout
+	LoginMaxFailuresPerIP        int
+	LoginLockoutDuration         time.Duration
+	PasswordResetValidity        time.Duration
+	IdempotencyKeyTTL            time.Duration // stored responses to requests with the Idempotency-Key header are kept this long
+	NotifierFile                 string        // user notifications are only recorded in the log without tokens if empty
+	AdminLogins                  []string      // registered users promoted to admins on start while there are no admins
+	TraceExporter                string        // none, stdout or otlp
+	TraceEndpoint                string        // OTLP/HTTP collector URL, the OTEL_EXPORTER_OTLP_* variables are used if empty
+	MetricsAddress               string        // separate listener of the Prometheus metrics, not served if empty
+	LogLevel                     string        // debug, info, warn or error
+	LogFormat                    string        // json or text
+	ShutdownDelay                time.Duration // readiness fails for this long before the server stops accepting connections
+	ShutdownTimeout              time.Duration // in-flight requests and order checks are given this long to finish
+}
+
+// splits comma-separated list skipping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func ParseConfig() (*Config, error) {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -19,6 +20,162 @@ func parseEnv(config *Config) {
 		config.SecretKey = envVar
 	}
 
+	if envVar, ok := os.LookupEnv("JWT_KEYS"); ok && envVar != "" {
+		config.JWTKeyFiles = splitList(envVar)
+	}
+
+	if envVar, ok := os.LookupEnv("JWT_ISSUER"); ok && envVar != "" {
+		config.JWTIssuer = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("JWT_AUDIENCE"); ok && envVar != "" {
+		config.JWTAudience = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("JWT_LEEWAY"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.JWTLeeway = duration
+	}
+
+	if envVar, ok := os.LookupEnv("PASSWORD_HASH_ALGORITHM"); ok && envVar != "" {
+		config.PasswordHashAlgorithm = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("PASSWORD_DENYLIST"); ok && envVar != "" {
+		config.PasswordDenylistFile = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("PASSWORD_MIN_LENGTH"); ok && envVar != "" {
+
+		n, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.PasswordMinLength = n
+	}
+
+	if envVar, ok := os.LookupEnv("PASSWORD_MIN_CLASSES"); ok && envVar != "" {
+
+		n, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.PasswordMinClasses = n
+	}
+
+	if envVar, ok := os.LookupEnv("LOGIN_MIN_LENGTH"); ok && envVar != "" {
+
+		n, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.LoginMinLength = n
+	}
+
+	if envVar, ok := os.LookupEnv("LOGIN_MAX_LENGTH"); ok && envVar != "" {
+
+		n, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.LoginMaxLength = n
+	}
+
+	if envVar, ok := os.LookupEnv("LOGIN_MAX_FAILURES"); ok && envVar != "" {
+
+		n, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.LoginMaxFailures = n
+	}
+
+	if envVar, ok := os.LookupEnv("LOGIN_MAX_FAILURES_PER_IP"); ok && envVar != "" {
+
+		n, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.LoginMaxFailuresPerIP = n
+	}
+
+	if envVar, ok := os.LookupEnv("LOGIN_LOCKOUT"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.LoginLockoutDuration = duration
+	}
+
+	if envVar, ok := os.LookupEnv("PASSWORD_RESET_VALIDITY"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.PasswordResetValidity = duration
+	}
+
+	if envVar, ok := os.LookupEnv("IDEMPOTENCY_KEY_TTL"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.IdempotencyKeyTTL = duration
+	}
+
+	if envVar, ok := os.LookupEnv("NOTIFIER_FILE"); ok && envVar != "" {
+		config.NotifierFile = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("ADMIN_LOGINS"); ok && envVar != "" {
+		config.AdminLogins = splitList(envVar)
+	}
+
+	if envVar, ok := os.LookupEnv("TRACE_EXPORTER"); ok && envVar != "" {
+		config.TraceExporter = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("TRACE_ENDPOINT"); ok && envVar != "" {
+		config.TraceEndpoint = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("METRICS_ADDRESS"); ok && envVar != "" {
+		config.MetricsAddress = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("LOG_LEVEL"); ok && envVar != "" {
+		config.LogLevel = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("LOG_FORMAT"); ok && envVar != "" {
+		config.LogFormat = envVar
+	}
+
+	if envVar, ok := os.LookupEnv("SHUTDOWN_DELAY"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.ShutdownDelay = duration
+	}
+
+	if envVar, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.ShutdownTimeout = duration
+	}
+
 	if envVar, ok := os.LookupEnv("TOKEN_VALIDITY"); ok && envVar != "" {
 
 		duration, err := time.ParseDuration(envVar)
@@ -28,4 +185,40 @@ func parseEnv(config *Config) {
 		config.TokenValidityDuration = duration
 	}
 
+	if envVar, ok := os.LookupEnv("REFRESH_TOKEN_VALIDITY"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.RefreshTokenValidityDuration = duration
+	}
+
+	if envVar, ok := os.LookupEnv("ACCRUAL_WORKERS"); ok && envVar != "" {
+
+		workers, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.AccrualWorkers = workers
+	}
+
+	if envVar, ok := os.LookupEnv("ACCRUAL_MAX_ORDER_AGE"); ok && envVar != "" {
+
+		duration, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		config.AccrualMaxOrderAge = duration
+	}
+
+	if envVar, ok := os.LookupEnv("ACCRUAL_RATE_LIMIT"); ok && envVar != "" {
+
+		limit, err := strconv.ParseFloat(envVar, 64)
+		if err != nil {
+			panic(err)
+		}
+		config.AccrualRateLimit = limit
+	}
+
 }
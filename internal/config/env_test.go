@@ -12,15 +12,77 @@ func TestParseEnv(t *testing.T) {
 
 	// Test cases
 	tests := []struct {
-		name                 string
-		runAddress           string
-		databaseURI          string
-		accrualSystemAddress string
-		secretKey            string
-		tokenValidity        string
-		expected             *Config
+		name                  string
+		runAddress            string
+		databaseURI           string
+		accrualSystemAddress  string
+		secretKey             string
+		tokenValidity         string
+		refreshTokenValidity  string
+		jwtKeys               string
+		jwtIssuer             string
+		jwtAudience           string
+		jwtLeeway             string
+		passwordHash          string
+		passwordMinLength     string
+		passwordMinClasses    string
+		passwordDenylist      string
+		loginMinLength        string
+		loginMaxLength        string
+		loginMaxFailures      string
+		loginMaxFailuresPerIP string
+		loginLockout          string
+		passwordResetValidity string
+		idempotencyKeyTTL     string
+		notifierFile          string
+		adminLogins           string
+		traceExporter         string
+		traceEndpoint         string
+		metricsAddress        string
+		logLevel              string
+		logFormat             string
+		shutdownDelay         string
+		shutdownTimeout       string
+		accrualWorkers        string
+		accrualMaxOrderAge    string
+		accrualRateLimit      string
+		expected              *Config
 	}{
-		{"Test1", ":8080", "uri", ":9001", "secretkey", "1m", &Config{":8080", "uri", ":9001", "secretkey", 1 * time.Minute}},
+		{"Test1", ":8080", "uri", ":9001", "secretkey", "1m", "48h", "keys/new.pem,keys/old.pem", "issuer", "audience", "10s", "bcrypt", "10", "3", "denylist.txt", "4", "32", "3", "20", "5m", "30m", "12h", "notifications.jsonl", "admin,support", "otlp", "http://collector:4318", ":9091", "debug", "text", "5s", "20s", "8", "24h", "2.5", &Config{
+			RunAddress:                   ":8080",
+			DatabaseURI:                  "uri",
+			AccrualSystemAddress:         ":9001",
+			SecretKey:                    "secretkey",
+			TokenValidityDuration:        1 * time.Minute,
+			AccrualWorkers:               8,
+			AccrualMaxOrderAge:           24 * time.Hour,
+			AccrualRateLimit:             2.5,
+			RefreshTokenValidityDuration: 48 * time.Hour,
+			JWTKeyFiles:                  []string{"keys/new.pem", "keys/old.pem"},
+			JWTIssuer:                    "issuer",
+			JWTAudience:                  "audience",
+			JWTLeeway:                    10 * time.Second,
+			PasswordHashAlgorithm:        "bcrypt",
+			PasswordMinLength:            10,
+			PasswordMinClasses:           3,
+			PasswordDenylistFile:         "denylist.txt",
+			LoginMinLength:               4,
+			LoginMaxLength:               32,
+			LoginMaxFailures:             3,
+			LoginMaxFailuresPerIP:        20,
+			LoginLockoutDuration:         5 * time.Minute,
+			PasswordResetValidity:        30 * time.Minute,
+			IdempotencyKeyTTL:            12 * time.Hour,
+			NotifierFile:                 "notifications.jsonl",
+			AdminLogins:                  []string{"admin", "support"},
+			TraceExporter:                "otlp",
+			TraceEndpoint:                "http://collector:4318",
+			MetricsAddress:               ":9091",
+			LogLevel:                     "debug",
+			LogFormat:                    "text",
+			ShutdownDelay:                5 * time.Second,
+			ShutdownTimeout:              20 * time.Second,
+		}},
 	}
 
 	for _, tt := range tests {
@@ -32,6 +94,34 @@ func TestParseEnv(t *testing.T) {
 			oldAccrualSystemAddress := os.Getenv("ACCRUAL_SYSTEM_ADDRESS")
 			oldSecretKey := os.Getenv("SECRET_KEY")
 			oldTokenValidity := os.Getenv("TOKEN_VALIDITY")
+			oldRefreshTokenValidity := os.Getenv("REFRESH_TOKEN_VALIDITY")
+			oldJWTKeys := os.Getenv("JWT_KEYS")
+			oldJWTIssuer := os.Getenv("JWT_ISSUER")
+			oldJWTAudience := os.Getenv("JWT_AUDIENCE")
+			oldJWTLeeway := os.Getenv("JWT_LEEWAY")
+			oldPasswordHash := os.Getenv("PASSWORD_HASH_ALGORITHM")
+			oldPasswordMinLength := os.Getenv("PASSWORD_MIN_LENGTH")
+			oldPasswordMinClasses := os.Getenv("PASSWORD_MIN_CLASSES")
+			oldPasswordDenylist := os.Getenv("PASSWORD_DENYLIST")
+			oldLoginMinLength := os.Getenv("LOGIN_MIN_LENGTH")
+			oldLoginMaxLength := os.Getenv("LOGIN_MAX_LENGTH")
+			oldLoginMaxFailures := os.Getenv("LOGIN_MAX_FAILURES")
+			oldLoginMaxFailuresPerIP := os.Getenv("LOGIN_MAX_FAILURES_PER_IP")
+			oldLoginLockout := os.Getenv("LOGIN_LOCKOUT")
+			oldPasswordResetValidity := os.Getenv("PASSWORD_RESET_VALIDITY")
+			oldIdempotencyKeyTTL := os.Getenv("IDEMPOTENCY_KEY_TTL")
+			oldNotifierFile := os.Getenv("NOTIFIER_FILE")
+			oldAdminLogins := os.Getenv("ADMIN_LOGINS")
+			oldTraceExporter := os.Getenv("TRACE_EXPORTER")
+			oldTraceEndpoint := os.Getenv("TRACE_ENDPOINT")
+			oldMetricsAddress := os.Getenv("METRICS_ADDRESS")
+			oldLogLevel := os.Getenv("LOG_LEVEL")
+			oldLogFormat := os.Getenv("LOG_FORMAT")
+			oldShutdownDelay := os.Getenv("SHUTDOWN_DELAY")
+			oldShutdownTimeout := os.Getenv("SHUTDOWN_TIMEOUT")
+			oldAccrualWorkers := os.Getenv("ACCRUAL_WORKERS")
+			oldAccrualMaxOrderAge := os.Getenv("ACCRUAL_MAX_ORDER_AGE")
+			oldAccrualRateLimit := os.Getenv("ACCRUAL_RATE_LIMIT")
 
 			if err := os.Setenv("RUN_ADDRESS", tt.runAddress); err != nil {
 				panic(err)
@@ -53,6 +143,118 @@ func TestParseEnv(t *testing.T) {
 				panic(err)
 			}
 
+			if err := os.Setenv("REFRESH_TOKEN_VALIDITY", tt.refreshTokenValidity); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("JWT_KEYS", tt.jwtKeys); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("JWT_ISSUER", tt.jwtIssuer); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("JWT_AUDIENCE", tt.jwtAudience); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("JWT_LEEWAY", tt.jwtLeeway); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("PASSWORD_HASH_ALGORITHM", tt.passwordHash); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("PASSWORD_MIN_LENGTH", tt.passwordMinLength); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("PASSWORD_MIN_CLASSES", tt.passwordMinClasses); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("PASSWORD_DENYLIST", tt.passwordDenylist); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("LOGIN_MIN_LENGTH", tt.loginMinLength); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("LOGIN_MAX_LENGTH", tt.loginMaxLength); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("LOGIN_MAX_FAILURES", tt.loginMaxFailures); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("LOGIN_MAX_FAILURES_PER_IP", tt.loginMaxFailuresPerIP); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("LOGIN_LOCKOUT", tt.loginLockout); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("PASSWORD_RESET_VALIDITY", tt.passwordResetValidity); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("IDEMPOTENCY_KEY_TTL", tt.idempotencyKeyTTL); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("NOTIFIER_FILE", tt.notifierFile); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("ADMIN_LOGINS", tt.adminLogins); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("TRACE_EXPORTER", tt.traceExporter); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("TRACE_ENDPOINT", tt.traceEndpoint); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("METRICS_ADDRESS", tt.metricsAddress); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("LOG_LEVEL", tt.logLevel); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("LOG_FORMAT", tt.logFormat); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("SHUTDOWN_DELAY", tt.shutdownDelay); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("SHUTDOWN_TIMEOUT", tt.shutdownTimeout); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("ACCRUAL_WORKERS", tt.accrualWorkers); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("ACCRUAL_MAX_ORDER_AGE", tt.accrualMaxOrderAge); err != nil {
+				panic(err)
+			}
+
+			if err := os.Setenv("ACCRUAL_RATE_LIMIT", tt.accrualRateLimit); err != nil {
+				panic(err)
+			}
+
 			config := &Config{}
 			parseEnv(config)
 
@@ -71,6 +273,90 @@ func TestParseEnv(t *testing.T) {
 			if err := os.Setenv("TOKEN_VALIDITY", oldTokenValidity); err != nil {
 				panic(err)
 			}
+			if err := os.Setenv("REFRESH_TOKEN_VALIDITY", oldRefreshTokenValidity); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("JWT_KEYS", oldJWTKeys); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("JWT_ISSUER", oldJWTIssuer); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("JWT_AUDIENCE", oldJWTAudience); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("JWT_LEEWAY", oldJWTLeeway); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("PASSWORD_HASH_ALGORITHM", oldPasswordHash); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("PASSWORD_MIN_LENGTH", oldPasswordMinLength); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("PASSWORD_MIN_CLASSES", oldPasswordMinClasses); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("PASSWORD_DENYLIST", oldPasswordDenylist); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("LOGIN_MIN_LENGTH", oldLoginMinLength); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("LOGIN_MAX_LENGTH", oldLoginMaxLength); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("LOGIN_MAX_FAILURES", oldLoginMaxFailures); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("LOGIN_MAX_FAILURES_PER_IP", oldLoginMaxFailuresPerIP); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("LOGIN_LOCKOUT", oldLoginLockout); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("PASSWORD_RESET_VALIDITY", oldPasswordResetValidity); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("IDEMPOTENCY_KEY_TTL", oldIdempotencyKeyTTL); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("NOTIFIER_FILE", oldNotifierFile); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("ADMIN_LOGINS", oldAdminLogins); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("TRACE_EXPORTER", oldTraceExporter); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("TRACE_ENDPOINT", oldTraceEndpoint); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("METRICS_ADDRESS", oldMetricsAddress); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("LOG_LEVEL", oldLogLevel); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("LOG_FORMAT", oldLogFormat); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("SHUTDOWN_DELAY", oldShutdownDelay); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("SHUTDOWN_TIMEOUT", oldShutdownTimeout); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("ACCRUAL_WORKERS", oldAccrualWorkers); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("ACCRUAL_MAX_ORDER_AGE", oldAccrualMaxOrderAge); err != nil {
+				panic(err)
+			}
+			if err := os.Setenv("ACCRUAL_RATE_LIMIT", oldAccrualRateLimit); err != nil {
+				panic(err)
+			}
 
 			if diff := cmp.Diff(config, tt.expected); diff != "" {
 				t.Errorf("Structs mismatch (-config +expected):\n%s", diff)
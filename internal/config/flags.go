@@ -8,10 +8,44 @@ import (
 func parseFlags(config *Config) {
 
 	flag.StringVar(&config.RunAddress, "a", ":8080", "address and port to run server")
-	flag.StringVar(&config.SecretKey, "k", "secretKey", "jwt token signing key")
+	flag.StringVar(&config.SecretKey, "k", DefaultSecretKey, "jwt token signing key, has to be changed unless jwt key files are configured")
 	flag.DurationVar(&config.TokenValidityDuration, "v", 5*time.Minute, "jwt token validity duration time interval")
+	flag.DurationVar(&config.RefreshTokenValidityDuration, "refresh-token-validity", 30*24*time.Hour, "refresh token validity duration time interval")
+	flag.Func("jwt-keys", "comma-separated jwt signing key PEM files, the first one signs (secret key is used if empty)", func(s string) error {
+		config.JWTKeyFiles = splitList(s)
+		return nil
+	})
+	flag.StringVar(&config.JWTIssuer, "jwt-issuer", "gophermart", "jwt token issuer (iss claim)")
+	flag.StringVar(&config.JWTAudience, "jwt-audience", "gophermart", "jwt token audience (aud claim)")
+	flag.DurationVar(&config.JWTLeeway, "jwt-leeway", 30*time.Second, "allowed clock skew when validating jwt token time claims")
+	flag.StringVar(&config.PasswordHashAlgorithm, "password-hash-algorithm", "argon2id", "password hash algorithm (argon2id or bcrypt)")
+	flag.IntVar(&config.PasswordMinLength, "password-min-length", 8, "min password length")
+	flag.IntVar(&config.PasswordMinClasses, "password-min-classes", 2, "min number of character classes (lower, upper, digits, other) in password")
+	flag.StringVar(&config.PasswordDenylistFile, "password-denylist", "", "file of common passwords which are not allowed, one per line")
+	flag.IntVar(&config.LoginMinLength, "login-min-length", 3, "min login length")
+	flag.IntVar(&config.LoginMaxLength, "login-max-length", 64, "max login length")
+	flag.IntVar(&config.LoginMaxFailures, "login-max-failures", 5, "failed logins in a row before the login is locked (0 to disable)")
+	flag.IntVar(&config.LoginMaxFailuresPerIP, "login-max-failures-per-ip", 50, "failed logins from a client address before it is locked (0 to disable)")
+	flag.DurationVar(&config.LoginLockoutDuration, "login-lockout", 15*time.Minute, "login lockout duration")
+	flag.DurationVar(&config.PasswordResetValidity, "password-reset-validity", time.Hour, "password reset token validity duration time interval")
+	flag.DurationVar(&config.IdempotencyKeyTTL, "idempotency-key-ttl", 24*time.Hour, "time responses to requests with the Idempotency-Key header are kept for retries")
+	flag.StringVar(&config.NotifierFile, "notifier-file", "", "file user notifications are appended to (only recorded in the log without tokens if empty)")
+	flag.Func("admin-logins", "comma-separated logins of registered users promoted to admins on start while there are no admins", func(s string) error {
+		config.AdminLogins = splitList(s)
+		return nil
+	})
+	flag.StringVar(&config.TraceExporter, "trace-exporter", "none", "trace exporter: none, stdout or otlp")
+	flag.DurationVar(&config.ShutdownDelay, "shutdown-delay", 0, "time readiness is reported failing before the server stops accepting connections")
+	flag.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "time in-flight requests and order checks are given to finish on shutdown")
+	flag.StringVar(&config.LogLevel, "log-level", "info", "log level: debug, info, warn or error")
+	flag.StringVar(&config.LogFormat, "log-format", "json", "log format: json or text")
+	flag.StringVar(&config.TraceEndpoint, "trace-endpoint", "", "OTLP/HTTP collector URL traces are sent to (OTEL_EXPORTER_OTLP_* variables are used if empty)")
+	flag.StringVar(&config.MetricsAddress, "metrics-address", "", "address and port to serve Prometheus metrics on, separately from the API (not served if empty)")
 	flag.StringVar(&config.DatabaseURI, "d", "", "database URI")
 	flag.StringVar(&config.AccrualSystemAddress, "r", "", "accrual system address")
+	flag.IntVar(&config.AccrualWorkers, "accrual-workers", 4, "number of concurrent accrual system workers")
+	flag.DurationVar(&config.AccrualMaxOrderAge, "accrual-max-order-age", 7*24*time.Hour, "max order age after which it is no longer checked in accrual system (0 to disable)")
+	flag.Float64Var(&config.AccrualRateLimit, "accrual-rate-limit", 0, "max accrual system requests per second across all workers (0 to disable)")
 	flag.Parse()
 
 }
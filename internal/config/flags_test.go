@@ -18,8 +18,47 @@ func TestParseFlags(t *testing.T) {
 		expected *Config
 		wantErr  bool
 	}{
-		{"Test1 iP:port", []string{"cmd", "-a=:8080", "-d", "uri", "-r", ":9001", "-k", "secretkey", "-v", "1m"},
-			&Config{":8080", "uri", ":9001", "secretkey", 1 * time.Minute}, false},
+		{"Test1 iP:port", []string{"cmd", "-a=:8080", "-d", "uri", "-r", ":9001", "-k", "secretkey", "-v", "1m", "-refresh-token-validity", "48h", "-jwt-keys", "keys/new.pem, keys/old.pem", "-jwt-issuer", "issuer", "-jwt-audience", "audience", "-jwt-leeway", "10s", "-password-hash-algorithm", "bcrypt", "-password-min-length", "10", "-password-min-classes", "3", "-password-denylist", "denylist.txt",
+			"-login-min-length", "4", "-login-max-length", "32",
+			"-login-max-failures", "3", "-login-max-failures-per-ip", "20", "-login-lockout", "5m",
+			"-password-reset-validity", "30m", "-idempotency-key-ttl", "12h", "-notifier-file", "notifications.jsonl", "-admin-logins", "admin, support",
+			"-trace-exporter", "otlp", "-trace-endpoint", "http://collector:4318", "-metrics-address", ":9091",
+			"-log-level", "debug", "-log-format", "text", "-shutdown-delay", "5s", "-shutdown-timeout", "20s", "-accrual-workers", "8", "-accrual-max-order-age", "24h", "-accrual-rate-limit", "2.5"},
+			&Config{
+				RunAddress:                   ":8080",
+				DatabaseURI:                  "uri",
+				AccrualSystemAddress:         ":9001",
+				SecretKey:                    "secretkey",
+				TokenValidityDuration:        1 * time.Minute,
+				AccrualWorkers:               8,
+				AccrualMaxOrderAge:           24 * time.Hour,
+				AccrualRateLimit:             2.5,
+				RefreshTokenValidityDuration: 48 * time.Hour,
+				JWTKeyFiles:                  []string{"keys/new.pem", "keys/old.pem"},
+				JWTIssuer:                    "issuer",
+				JWTAudience:                  "audience",
+				JWTLeeway:                    10 * time.Second,
+				PasswordHashAlgorithm:        "bcrypt",
+				PasswordMinLength:            10,
+				PasswordMinClasses:           3,
+				PasswordDenylistFile:         "denylist.txt",
+				LoginMinLength:               4,
+				LoginMaxLength:               32,
+				LoginMaxFailures:             3,
+				LoginMaxFailuresPerIP:        20,
+				LoginLockoutDuration:         5 * time.Minute,
+				PasswordResetValidity:        30 * time.Minute,
+				IdempotencyKeyTTL:            12 * time.Hour,
+				NotifierFile:                 "notifications.jsonl",
+				AdminLogins:                  []string{"admin", "support"},
+				TraceExporter:                "otlp",
+				TraceEndpoint:                "http://collector:4318",
+				MetricsAddress:               ":9091",
+				LogLevel:                     "debug",
+				LogFormat:                    "text",
+				ShutdownDelay:                5 * time.Second,
+				ShutdownTimeout:              20 * time.Second,
+			}, false},
 	}
 
 	for _, tt := range tests {
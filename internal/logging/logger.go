@@ -1,11 +1,81 @@
 package logging
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
 )
 
+// values of these attributes are never written, whoever logs them
+var secretKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"secret":        true,
+	"authorization": true,
+}
+
+const redacted = "[REDACTED]"
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
 func NewLogger() *slog.Logger {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{ReplaceAttr: redact}))
 	return logger
 }
+
+// New returns the logger writing to stdout with the given level (debug, info, warn or error)
+// and format (json or text)
+func New(level string, format string) (*slog.Logger, error) {
+	return newLogger(os.Stdout, level, format)
+}
+
+func newLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: l, ReplaceAttr: redact}
+
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", common.ErrorUnknownLogFormat, format)
+	}
+}
+
+type loggerKey struct{}
+
+// WithLogger returns the context carrying the logger, e.g. the one of the request
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context or the fallback if there is none
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
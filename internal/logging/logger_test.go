@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := newLogger(&buf, "warn", "text")
+	require.NoError(t, err)
+
+	logger.Info("skipped")
+	logger.Warn("written", "login", "user1", "password", "p@ssw0rd", "Token", "abc")
+
+	out := buf.String()
+	require.NotContains(t, out, "skipped")
+	require.Contains(t, out, "login=user1")
+	require.NotContains(t, out, "p@ssw0rd")
+	require.NotContains(t, out, "abc")
+	require.Contains(t, out, "password="+redacted)
+
+	_, err = newLogger(&buf, "info", "xml")
+	require.ErrorIs(t, err, common.ErrorUnknownLogFormat)
+
+	_, err = newLogger(&buf, "verbose", "json")
+	require.Error(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	fallback := slog.Default()
+	logger := slog.Default().With("request_id", "1")
+
+	require.Same(t, fallback, FromContext(context.Background(), fallback))
+	require.Same(t, logger, FromContext(WithLogger(context.Background(), logger), fallback))
+}
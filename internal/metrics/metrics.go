@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+// outcomes of the accrual system requests
+const (
+	AccrualOutcomeOK            = "ok"
+	AccrualOutcomeNotRegistered = "not_registered"
+	AccrualOutcomeThrottled     = "throttled"
+	AccrualOutcomeError         = "error"
+)
+
+// Metrics are the application metrics exposed in the Prometheus format.
+// All methods may be called on nil, so that the metrics are optional for the callers
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequestDuration    *prometheus.HistogramVec
+	accrualRequestDuration *prometheus.HistogramVec
+	pendingOrders          prometheus.Gauge
+	pollCycleDuration      prometheus.Histogram
+	registrations          prometheus.Counter
+	orders                 *prometheus.CounterVec
+	withdrawals            prometheus.Counter
+	pointsWithdrawn        prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		accrualRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "accrual_request_duration_seconds",
+			Help:      "Accrual system request latency by outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		pendingOrders: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pending_orders",
+			Help:      "Orders due to be checked in the accrual system at the start of the last poll cycle.",
+		}),
+		pollCycleDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "accrual_poll_cycle_duration_seconds",
+			Help:      "Duration of the accrual system poll cycles.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+		}),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Registered users.",
+		}),
+		orders: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "orders_total",
+			Help:      "Uploaded order numbers by result.",
+		}, []string{"result"}),
+		withdrawals: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "withdrawals_total",
+			Help:      "Withdrawals made.",
+		}),
+		pointsWithdrawn: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "points_withdrawn_total",
+			Help:      "Loyalty points withdrawn.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequestDuration,
+		m.accrualRequestDuration,
+		m.pendingOrders,
+		m.pollCycleDuration,
+		m.registrations,
+		m.orders,
+		m.withdrawals,
+		m.pointsWithdrawn,
+	)
+
+	return m
+}
+
+// Handler serves the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Registry is the registry the metrics are kept in
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// ObserveHTTPRequest records the request, route is the pattern of the matched route
+// rather than the path, so that the number of series stays bounded
+func (m *Metrics) ObserveHTTPRequest(method string, route string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveAccrualRequest(outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.accrualRequestDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+func (m *Metrics) SetPendingOrders(n int) {
+	if m == nil {
+		return
+	}
+	m.pendingOrders.Set(float64(n))
+}
+
+func (m *Metrics) ObservePollCycle(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.pollCycleDuration.Observe(d.Seconds())
+}
+
+func (m *Metrics) IncRegistrations() {
+	if m == nil {
+		return
+	}
+	m.registrations.Inc()
+}
+
+func (m *Metrics) IncOrders(result string) {
+	if m == nil {
+		return
+	}
+	m.orders.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) ObserveWithdrawal(amount models.Money) {
+	if m == nil {
+		return
+	}
+	m.withdrawals.Inc()
+	m.pointsWithdrawn.Add(amount.Points())
+}
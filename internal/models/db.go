@@ -2,13 +2,18 @@ package models
 
 import "time"
 
+type Role string
+
+const (
+	RoleUser  Role = `user`  //пользователь системы лояльности;
+	RoleAdmin Role = `admin` //сотрудник поддержки, доступен /api/admin.
+)
+
 type User struct {
-	ID             string
-	Login          string
-	Password       string
-	Salt           string
-	AccruedTotal   float32
-	WithdrawnTotal float32
+	ID       string
+	Login    string
+	Password string // PHC encoded password hash
+	Role     Role
 }
 
 type OrderStatus string
@@ -21,12 +26,14 @@ const (
 )
 
 type Order struct {
-	ID         string
-	Number     string
-	UserID     string
-	Status     OrderStatus
-	Accrual    float32
-	UploadedAt time.Time
+	ID           string
+	Number       string
+	UserID       string
+	Status       OrderStatus
+	Accrual      Money
+	UploadedAt   time.Time
+	NextCheckAt  time.Time // next time the order is due to be checked in the accrual system
+	AttemptCount int       // number of checks made so far without final status
 }
 
 type Withdrawal struct {
@@ -34,5 +41,143 @@ type Withdrawal struct {
 	UserID     string
 	UploadedAt time.Time
 	Order      string
-	Amount     float32
+	Amount     Money
+	ReversedAt time.Time // zero unless the withdrawal is reversed
+	ReversedBy string    // admin who reversed the withdrawal
+}
+
+type LedgerEntryType string
+
+const (
+	LedgerEntryAccrual    LedgerEntryType = `ACCRUAL`    //начисление за обработанный заказ;
+	LedgerEntryWithdrawal LedgerEntryType = `WITHDRAWAL` //списание в счёт оплаты заказа;
+	LedgerEntryReversal   LedgerEntryType = `REVERSAL`   //отмена списания;
+	LedgerEntryAdjustment LedgerEntryType = `ADJUSTMENT` //ручная корректировка баланса.
+)
+
+// LedgerEntry is an append-only record of a single balance change
+type LedgerEntry struct {
+	ID           string
+	UserID       string
+	Type         LedgerEntryType
+	Amount       Money  // credit is positive, debit is negative
+	Balance      Money  // running balance after the entry
+	OrderID      string // source of the accrual
+	WithdrawalID string // source of the withdrawal or its reversal
+	AdjustmentID string // source of the manual adjustment
+	CreatedAt    time.Time
+}
+
+// LedgerAccount is a system account the user balance changes are balanced against,
+// so that every change is recorded twice and all the entries of the ledger sum up to zero
+type LedgerAccount string
+
+const (
+	LedgerAccountAccruals    LedgerAccount = `ACCRUALS`    //баллы, начисленные за заказы;
+	LedgerAccountWithdrawals LedgerAccount = `WITHDRAWALS` //баллы, списанные в счёт оплаты заказов, за вычетом отменённых списаний;
+	LedgerAccountAdjustments LedgerAccount = `ADJUSTMENTS` //ручные корректировки баланса.
+)
+
+// Account returns the system account balancing the entries of the type
+func (t LedgerEntryType) Account() LedgerAccount {
+	switch t {
+	case LedgerEntryAccrual:
+		return LedgerAccountAccruals
+	case LedgerEntryWithdrawal, LedgerEntryReversal:
+		return LedgerAccountWithdrawals
+	default:
+		return LedgerAccountAdjustments
+	}
+}
+
+// SystemLedgerEntry is the balancing counterpart of a user ledger entry
+type SystemLedgerEntry struct {
+	ID        string
+	Account   LedgerAccount
+	EntryID   string // the user ledger entry being balanced
+	Amount    Money  // opposite of the user ledger entry amount
+	CreatedAt time.Time
+}
+
+type AdjustmentReason string
+
+const (
+	AdjustmentReasonGoodwill      AdjustmentReason = `GOODWILL`       //начисление в качестве компенсации;
+	AdjustmentReasonFraudReversal AdjustmentReason = `FRAUD_REVERSAL` //отмена мошеннического начисления;
+	AdjustmentReasonCorrection    AdjustmentReason = `CORRECTION`     //исправление ошибки начисления или списания.
+)
+
+// Adjustment is a manual balance change made by the support team
+type Adjustment struct {
+	ID         string
+	UserID     string
+	Amount     Money // credit is positive, debit is negative
+	Reason     AdjustmentReason
+	Comment    string
+	OperatorID string // admin who made the adjustment
+	CreatedAt  time.Time
+}
+
+type Balance struct {
+	Current   Money
+	Withdrawn Money
+}
+
+// IdempotencyKey keeps the response to a request made with the Idempotency-Key header,
+// so that retries get the original result instead of repeating the request
+type IdempotencyKey struct {
+	UserID      string
+	Key         string
+	RequestHash string              // hash of the method, path and body of the original request
+	StatusCode  int                 // zero while the original request is in flight
+	Headers     map[string][]string // response headers set by the handler
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// RefreshToken is a long-lived opaque token exchanged for a new access token,
+// only its hash is stored. Every exchange rotates the token within the same family
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	FamilyID  string // tokens issued by rotation of the same login share the family
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    time.Time // zero until the token is exchanged
+	RevokedAt time.Time // zero until the family is revoked
+}
+
+// PasswordResetToken is a single-use token the password can be set with without knowing the current one
+type PasswordResetToken struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    time.Time // zero until the token is used or invalidated
+}
+
+// LoginThrottle counts failed logins of a login or of a client address
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+}
+
+type LoginAttemptResult string
+
+const (
+	LoginAttemptSuccess   LoginAttemptResult = "SUCCESS"   // пользователь аутентифицирован;
+	LoginAttemptFailure   LoginAttemptResult = "FAILURE"   // неверная пара логин/пароль;
+	LoginAttemptThrottled LoginAttemptResult = "THROTTLED" // попытка отклонена без проверки пароля.
+)
+
+// LoginAttempt is the audit record of a login attempt
+type LoginAttempt struct {
+	ID        string
+	Login     string
+	IP        string
+	Result    LoginAttemptResult
+	CreatedAt time.Time
 }
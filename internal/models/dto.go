@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 )
 
 type LoginDTO struct {
@@ -16,13 +18,13 @@ type RegisterUserDTO struct {
 type OrderDTO struct {
 	Number     string      `json:"number"`
 	Status     OrderStatus `json:"status"`
-	Accrual    float32     `json:"accrual"`
+	Accrual    Money       `json:"accrual,omitempty"`
 	UploadedAt time.Time   `json:"uploaded_at"`
 }
 
 type BalanceDTO struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
 }
 
 type AccrualStatus string
@@ -37,16 +39,86 @@ const (
 type AccrualStatusDTO struct {
 	Order   string        `json:"order"`
 	Status  AccrualStatus `json:"status"`
-	Accrual float32       `json:"accrual"`
+	Accrual Money         `json:"accrual"`
 }
 
 type WithdrawalRequestDTO struct {
-	Order string  `json:"order" validate:"required"`
-	Sum   float32 `json:"sum" validate:"required"`
+	Order string `json:"order" validate:"required"`
+	Sum   Money  `json:"sum" validate:"required,gt=0"`
 }
 
 type WithdrawalDTO struct {
-	Order       string    `json:"order"`
-	Sum         float32   `json:"sum"`
-	ProcessedAt time.Time `json:"processed_at"`
+	Order       string     `json:"order"`
+	Sum         Money      `json:"sum"`
+	ProcessedAt time.Time  `json:"processed_at"`
+	ReversedAt  *time.Time `json:"reversed_at,omitempty"` // the points are returned to the balance
+}
+
+type TokensDTO struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // access token lifetime in seconds
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ValidationErrorDTO lists every failed validation rule
+type ValidationErrorDTO struct {
+	Error  string              `json:"error"`
+	Errors []common.FieldError `json:"errors"`
+}
+
+type RefreshTokenRequestDTO struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type ChangePasswordRequestDTO struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequestDTO struct {
+	Login string `json:"login" validate:"required"`
+}
+
+type PasswordResetDTO struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password"`
+}
+
+// AdminUserDTO is the user as the support team sees them
+type AdminUserDTO struct {
+	ID      string     `json:"id"`
+	Login   string     `json:"login"`
+	Role    Role       `json:"role"`
+	Balance BalanceDTO `json:"balance"`
+}
+
+type UserRoleRequestDTO struct {
+	Role Role `json:"role" validate:"required"`
+}
+
+type AdjustmentRequestDTO struct {
+	Amount  Money            `json:"amount" validate:"required"`
+	Reason  AdjustmentReason `json:"reason" validate:"required"`
+	Comment string           `json:"comment"`
+}
+
+type AdjustmentDTO struct {
+	ID         string           `json:"id"`
+	Amount     Money            `json:"amount"`
+	Reason     AdjustmentReason `json:"reason"`
+	Comment    string           `json:"comment,omitempty"`
+	OperatorID string           `json:"operator_id"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+// HealthDTO is the readiness report, checks hold "ok" or the error of each dependency
+type HealthDTO struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
 }
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/base64"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/google/uuid"
+)
+
+// Cursor points to the last item of a page, the next page starts right after it
+type Cursor struct {
+	UploadedAt time.Time
+	ID         string
+}
+
+// ListFilter selects a page of user history ordered by (uploaded_at, id)
+type ListFilter struct {
+	From      time.Time // inclusive, zero means no lower bound
+	To        time.Time // exclusive, zero means no upper bound
+	Ascending bool      // oldest first, newest first by default
+	After     *Cursor   // nil means from the beginning
+	Limit     int       // zero means no limit
+}
+
+type OrderListFilter struct {
+	ListFilter
+	Statuses []OrderStatus // empty means any status
+}
+
+// compares the item position with the cursor in the ascending order
+func (c Cursor) compare(uploadedAt time.Time, id string) int {
+	if n := uploadedAt.Compare(c.UploadedAt); n != 0 {
+		return n
+	}
+	return strings.Compare(id, c.ID)
+}
+
+// Matches reports whether the item passes the date range and goes after the cursor
+func (f ListFilter) Matches(uploadedAt time.Time, id string) bool {
+	if !f.From.IsZero() && uploadedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !uploadedAt.Before(f.To) {
+		return false
+	}
+	if f.After == nil {
+		return true
+	}
+	if f.Ascending {
+		return f.After.compare(uploadedAt, id) > 0
+	}
+	return f.After.compare(uploadedAt, id) < 0
+}
+
+// Less reports whether the first item goes before the second one in the list
+func (f ListFilter) Less(uploadedAt1 time.Time, id1 string, uploadedAt2 time.Time, id2 string) bool {
+	n := Cursor{uploadedAt2, id2}.compare(uploadedAt1, id1)
+	if f.Ascending {
+		return n < 0
+	}
+	return n > 0
+}
+
+func (f OrderListFilter) MatchesOrder(o Order) bool {
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, o.Status) {
+		return false
+	}
+	return f.Matches(o.UploadedAt, o.ID)
+}
+
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.UploadedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+func ParseCursor(value string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, common.ErrorInvalidCursor
+	}
+
+	uploadedAt, id, ok := strings.Cut(string(b), "|")
+	if !ok {
+		return nil, common.ErrorInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, uploadedAt)
+	if err != nil {
+		return nil, common.ErrorInvalidCursor
+	}
+
+	// ids are compared with uuid columns, anything else would fail the query
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return nil, common.ErrorInvalidCursor
+	}
+
+	return &Cursor{UploadedAt: t, ID: parsed.String()}, nil
+}
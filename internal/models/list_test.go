@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCursor(t *testing.T) {
+	c := Cursor{UploadedAt: time.Date(2025, 4, 1, 12, 0, 0, 123456000, time.UTC), ID: "0b9e5c3e-7f5a-4b8e-9f7e-0e4a6f1c2d3b"}
+
+	got, err := ParseCursor(c.String())
+	require.NoError(t, err)
+	require.True(t, got.UploadedAt.Equal(c.UploadedAt))
+	require.Equal(t, c.ID, got.ID)
+
+	notUUID := Cursor{UploadedAt: c.UploadedAt, ID: "not-a-uuid"}
+
+	for _, value := range []string{"", "!!!", "bm8tc2VwYXJhdG9y", "bm90LWEtZGF0ZXxpZA", notUUID.String()} {
+		_, err := ParseCursor(value)
+		require.ErrorIs(t, err, common.ErrorInvalidCursor, value)
+	}
+}
+
+func TestListFilter_Matches(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	cursor := &Cursor{UploadedAt: now, ID: "b"}
+
+	tests := []struct {
+		name       string
+		filter     ListFilter
+		uploadedAt time.Time
+		id         string
+		want       bool
+	}{
+		{"No filter", ListFilter{}, now, "a", true},
+		{"Before from", ListFilter{From: now}, now.Add(-time.Second), "a", false},
+		{"At from", ListFilter{From: now}, now, "a", true},
+		{"At to", ListFilter{To: now}, now, "a", false},
+		{"Newer than cursor", ListFilter{After: cursor}, now.Add(time.Second), "a", false},
+		{"Older than cursor", ListFilter{After: cursor}, now.Add(-time.Second), "z", true},
+		{"Same time lower id", ListFilter{After: cursor}, now, "a", true},
+		{"Cursor itself", ListFilter{After: cursor}, now, "b", false},
+		{"Ascending same time higher id", ListFilter{After: cursor, Ascending: true}, now, "c", true},
+		{"Ascending cursor itself", ListFilter{After: cursor, Ascending: true}, now, "b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.filter.Matches(tt.uploadedAt, tt.id))
+		})
+	}
+}
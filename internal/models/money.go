@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of loyalty points (1 point = 1 rouble) kept in minor units,
+// i.e. hundredths of a point, same scale as NUMERIC(15, 2) columns.
+// All arithmetic is done on integers, so amounts never drift.
+// Values with more than two decimal places are rounded half away from zero.
+type Money int64
+
+// number of minor units in one point
+const moneyScale = 100
+
+// NewMoney returns amount of whole points
+func NewMoney(points int64) Money {
+	return Money(points * moneyScale)
+}
+
+// ParseMoney parses decimal number, e.g. "500", "500.5", "-0.01" or "5e2"
+func ParseMoney(s string) (Money, error) {
+
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("invalid money amount: %q", s)
+	}
+
+	r.Mul(r, big.NewRat(moneyScale, 1))
+
+	// rounding half away from zero
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if m.Mul(m, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("money amount out of range: %q", s)
+	}
+
+	return Money(q.Int64()), nil
+}
+
+// Points returns the amount in points, it is not exact and should only be used for reporting
+func (m Money) Points() float64 {
+	return float64(m) / moneyScale
+}
+
+// String formats amount as decimal number without trailing zeros, e.g. "500.5"
+func (m Money) String() string {
+
+	sign := ""
+	v := uint64(m)
+	if m < 0 {
+		sign = "-"
+		v = uint64(-m)
+	}
+
+	units, cents := v/moneyScale, v%moneyScale
+	if cents == 0 {
+		return sign + strconv.FormatUint(units, 10)
+	}
+
+	frac := strings.TrimRight(fmt.Sprintf("%02d", cents), "0")
+	return sign + strconv.FormatUint(units, 10) + "." + frac
+}
+
+// MarshalJSON encodes amount as JSON number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes amount from JSON number without going through float
+func (m *Money) UnmarshalJSON(data []byte) error {
+
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		return fmt.Errorf("money amount should be a number: %s", s)
+	}
+
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+
+	*m = v
+	return nil
+}
+
+// Scan reads amount from NUMERIC column
+func (m *Money) Scan(src any) error {
+
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = NewMoney(v)
+	case string:
+		return m.scanString(v)
+	case []byte:
+		return m.scanString(string(v))
+	case float64:
+		return m.scanString(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Value writes amount into NUMERIC column
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Money
+		wantErr bool
+	}{
+		{"Integer", "500", 50000, false},
+		{"One decimal", "500.5", 50050, false},
+		{"Two decimals", "0.01", 1, false},
+		{"Negative", "-42.10", -4210, false},
+		{"Exponent", "5e2", 50000, false},
+		{"Round half up", "0.005", 1, false},
+		{"Round down", "0.0049", 0, false},
+		{"Round negative half away from zero", "-0.005", -1, false},
+		{"Numeric column", "751.00", 75100, false},
+		{"Garbage", "abc", 0, true},
+		{"Empty", "", 0, true},
+		{"Out of range", "1e30", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMoney() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney() = %v, want %v", int64(got), int64(tt.want))
+			}
+		})
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Money
+		want string
+	}{
+		{"Zero", 0, "0"},
+		{"Integer", 50000, "500"},
+		{"One decimal", 50050, "500.5"},
+		{"Two decimals", 1, "0.01"},
+		{"Negative", -4210, "-42.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.String(); got != tt.want {
+				t.Errorf("Money.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+
+	var dto WithdrawalRequestDTO
+	err := json.Unmarshal([]byte(`{"order":"2377225624","sum":751.1}`), &dto)
+	require.NoError(t, err)
+	require.Equal(t, Money(75110), dto.Sum)
+
+	err = json.Unmarshal([]byte(`{"order":"2377225624","sum":"751"}`), &dto)
+	require.Error(t, err)
+
+	data, err := json.Marshal(BalanceDTO{Current: 50050, Withdrawn: NewMoney(42)})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"current":500.5,"withdrawn":42}`, string(data))
+}
+
+func TestMoney_Scan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     any
+		want    Money
+		wantErr bool
+	}{
+		{"String", "500.50", 50050, false},
+		{"Bytes", []byte("0.10"), 10, false},
+		{"Int", int64(3), 300, false},
+		{"Float", float64(0.1), 10, false},
+		{"Nil", nil, 0, false},
+		{"Unsupported", true, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := got.Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Money.Scan() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Money.Scan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_NoDrift(t *testing.T) {
+
+	// 0.1 can't be represented exactly in binary floating point
+	step, err := ParseMoney("0.1")
+	require.NoError(t, err)
+
+	var total Money
+	for i := 0; i < 100_000; i++ {
+		total += step
+	}
+	require.Equal(t, NewMoney(10_000), total)
+
+	for i := 0; i < 100_000; i++ {
+		total -= step
+	}
+	require.Equal(t, Money(0), total)
+}
@@ -0,0 +1,67 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers messages to users. Users are only known by their logins,
+// an implementation sending e-mails or SMS is expected to resolve the address itself
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, login string, token string, expiresAt time.Time) error
+}
+
+// LogNotifier only records in the log that a message was sent, tokens are never logged,
+// so the file notifier should be used to receive them locally
+type LogNotifier struct {
+	logger *slog.Logger
+}
+
+func NewLogNotifier(logger *slog.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) SendPasswordReset(ctx context.Context, login string, token string, expiresAt time.Time) error {
+	n.logger.InfoContext(ctx, "Password reset requested", "login", login, "expires_at", expiresAt)
+	return nil
+}
+
+// message written by FileNotifier, one JSON object per line
+type fileMessage struct {
+	Type      string    `json:"type"`
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FileNotifier appends the messages to a file, meant for local use and tests
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) SendPasswordReset(ctx context.Context, login string, token string, expiresAt time.Time) error {
+	return n.write(fileMessage{Type: "password_reset", Login: login, Token: token, ExpiresAt: expiresAt, CreatedAt: time.Now()})
+}
+
+func (n *FileNotifier) write(m fileMessage) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(m)
+}
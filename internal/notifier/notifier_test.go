@@ -0,0 +1,43 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	n := NewFileNotifier(path)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	require.NoError(t, n.SendPasswordReset(context.Background(), "user1", "token1", expiresAt))
+	require.NoError(t, n.SendPasswordReset(context.Background(), "user2", "token2", expiresAt))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var messages []fileMessage
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m fileMessage
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		messages = append(messages, m)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, messages, 2)
+	require.Equal(t, "password_reset", messages[0].Type)
+	require.Equal(t, "user1", messages[0].Login)
+	require.Equal(t, "token1", messages[0].Token)
+	require.True(t, messages[0].ExpiresAt.Equal(expiresAt))
+	require.Equal(t, "user2", messages[1].Login)
+}
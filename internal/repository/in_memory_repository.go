@@ -2,8 +2,11 @@ package repository
 
 import (
 	"context"
+	"maps"
+	"slices"
 	"sort"
-	"sync"
+	"strings"
+	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
@@ -11,84 +14,139 @@ import (
 )
 
 type InMemoryRepository struct {
-	mu sync.RWMutex
-
-	inTransaction bool
+	// held by a transaction for its whole lifetime and by every call made outside
+	// of a transaction for the duration of the call, so transactions are serialised
+	// and nobody ever sees uncommitted changes of another transaction
+	lock chan struct{}
 
 	users       map[string]models.User
 	orders      map[string]models.Order
 	withdrawals map[string]models.Withdrawal
-
-	userSnapshot       map[string]models.User
-	orderSnapshot      map[string]models.Order
-	withdrawalSnapshot map[string]models.Withdrawal
+	leases      map[string]orderLease
+	ledger      []models.LedgerEntry
+	system      []models.SystemLedgerEntry
+	idempotency map[idempotencyKeyID]models.IdempotencyKey
+	refresh     map[string]models.RefreshToken
+	throttles   map[string]models.LoginThrottle
+	attempts    []models.LoginAttempt
+	resets      map[string]models.PasswordResetToken
+	adjustments []models.Adjustment
 }
 
 func NewInMemoryRepository() (*InMemoryRepository, error) {
 	return &InMemoryRepository{
+		lock:        make(chan struct{}, 1),
 		users:       map[string]models.User{},
 		orders:      map[string]models.Order{},
 		withdrawals: map[string]models.Withdrawal{},
+		leases:      map[string]orderLease{},
+		idempotency: map[idempotencyKeyID]models.IdempotencyKey{},
+		refresh:     map[string]models.RefreshToken{},
+		throttles:   map[string]models.LoginThrottle{},
+		resets:      map[string]models.PasswordResetToken{},
 	}, nil
 }
 
-func (r *InMemoryRepository) UnitOfWork() UnitOfWork {
-	return &InMemoryUnitOfWork{repository: r}
+// order lease, equivalent of leased_by/leased_until columns
+type orderLease struct {
+	owner string
+	until time.Time
 }
 
-func (r *InMemoryRepository) BeginTransaction() error {
-
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if r.inTransaction {
-		return common.ErrorAlreadyInTranscation
-	}
-
-	// creating copies
-	r.userSnapshot = r.users
-	r.orderSnapshot = r.orders
-	r.withdrawalSnapshot = r.withdrawals
-
-	r.inTransaction = true
-
-	return nil
+// idempotency keys are unique per user
+type idempotencyKeyID struct {
+	userID string
+	key    string
 }
 
-func (r *InMemoryRepository) Commit() error {
+// inMemorySnapshot keeps copies of the data taken when a transaction begins, used for rollback
+type inMemorySnapshot struct {
+	users       map[string]models.User
+	orders      map[string]models.Order
+	withdrawals map[string]models.Withdrawal
+	leases      map[string]orderLease
+	ledger      []models.LedgerEntry
+	system      []models.SystemLedgerEntry
+	idempotency map[idempotencyKeyID]models.IdempotencyKey
+	refresh     map[string]models.RefreshToken
+	throttles   map[string]models.LoginThrottle
+	attempts    []models.LoginAttempt
+	resets      map[string]models.PasswordResetToken
+	adjustments []models.Adjustment
+}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *InMemoryRepository) UnitOfWork() UnitOfWork {
+	return &InMemoryUnitOfWork{repository: r}
+}
 
-	if !r.inTransaction {
-		return common.ErrorNotInTranscation
+// waits for the repository lock, cancellable through the context
+func (r *InMemoryRepository) lockRepository(ctx context.Context) error {
+	select {
+	case r.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	r.inTransaction = false
-	return nil
+func (r *InMemoryRepository) unlockRepository() {
+	<-r.lock
 }
 
-func (r *InMemoryRepository) Rollback() error {
+// acquire makes sure the caller holds the repository lock: calls made within
+// a transaction already hold it, other calls take it until release is called
+func (r *InMemoryRepository) acquire(ctx context.Context) (release func(), err error) {
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	if tx, ok := ctx.Value(inMemoryTxKey{}).(*InMemoryTx); ok && tx.repository == r {
+		if tx.isFinished() {
+			return nil, common.ErrorNotInTranscation
+		}
+		return func() {}, nil
+	}
 
-	if !r.inTransaction {
-		return common.ErrorNotInTranscation
+	if err := r.lockRepository(ctx); err != nil {
+		return nil, err
 	}
+	return r.unlockRepository, nil
+}
 
-	r.users = r.userSnapshot
-	r.orders = r.orderSnapshot
-	r.withdrawals = r.withdrawalSnapshot
+func (r *InMemoryRepository) snapshot() *inMemorySnapshot {
+	return &inMemorySnapshot{
+		users:       maps.Clone(r.users),
+		orders:      maps.Clone(r.orders),
+		withdrawals: maps.Clone(r.withdrawals),
+		leases:      maps.Clone(r.leases),
+		ledger:      slices.Clone(r.ledger),
+		system:      slices.Clone(r.system),
+		idempotency: maps.Clone(r.idempotency),
+		refresh:     maps.Clone(r.refresh),
+		throttles:   maps.Clone(r.throttles),
+		attempts:    slices.Clone(r.attempts),
+		resets:      maps.Clone(r.resets),
+		adjustments: slices.Clone(r.adjustments),
+	}
+}
 
-	r.inTransaction = false
-	return nil
+func (r *InMemoryRepository) restore(s *inMemorySnapshot) {
+	r.users = s.users
+	r.orders = s.orders
+	r.withdrawals = s.withdrawals
+	r.leases = s.leases
+	r.ledger = s.ledger
+	r.system = s.system
+	r.idempotency = s.idempotency
+	r.refresh = s.refresh
+	r.throttles = s.throttles
+	r.attempts = s.attempts
+	r.resets = s.resets
+	r.adjustments = s.adjustments
 }
 
 func (r *InMemoryRepository) findUserIDByLogin(_ context.Context, login string) string {
 
 	users := common.FilterMap[models.User](r.users, func(x models.User) bool {
-		return x.Login == login
+		// logins are unique regardless of the case
+		return strings.EqualFold(x.Login, login)
 	})
 
 	if len(users) == 0 {
@@ -99,6 +157,13 @@ func (r *InMemoryRepository) findUserIDByLogin(_ context.Context, login string)
 }
 
 func (r *InMemoryRepository) FindUserByLogin(ctx context.Context, login string) (models.User, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer release()
+
 	id := r.findUserIDByLogin(ctx, login)
 	if id == "" {
 		return models.User{}, common.ErrorNotFound
@@ -107,17 +172,28 @@ func (r *InMemoryRepository) FindUserByLogin(ctx context.Context, login string)
 }
 
 func (r *InMemoryRepository) AddUser(ctx context.Context, user *models.User) (models.User, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer release()
+
 	id := r.findUserIDByLogin(ctx, user.Login)
 	if id != "" {
 		return r.users[id], common.ErrorLoginAlreadyExists
 	}
 
-	id, err := r.newUUID()
+	id, err = r.newUUID()
 	if err != nil {
 		return models.User{}, err
 	}
 
 	user.ID = id
+	// users are regular users unless stated otherwise
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
 	r.users[user.ID] = *user
 
 	return *user, nil
@@ -133,7 +209,13 @@ func (r *InMemoryRepository) newUUID() (string, error) {
 	return id.String(), nil
 }
 
-func (r *InMemoryRepository) FindOrderByNumber(_ context.Context, number string) (models.Order, error) {
+func (r *InMemoryRepository) FindOrderByNumber(ctx context.Context, number string) (models.Order, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.Order{}, err
+	}
+	defer release()
 
 	orders := common.FilterMap[models.Order](r.orders, func(x models.Order) bool {
 		return x.Number == number
@@ -148,6 +230,12 @@ func (r *InMemoryRepository) FindOrderByNumber(_ context.Context, number string)
 
 func (r *InMemoryRepository) AddOrder(ctx context.Context, order *models.Order) (models.Order, error) {
 
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.Order{}, err
+	}
+	defer release()
+
 	id, err := r.newUUID()
 	if err != nil {
 		return models.Order{}, err
@@ -159,30 +247,96 @@ func (r *InMemoryRepository) AddOrder(ctx context.Context, order *models.Order)
 	return *order, nil
 }
 
-func (r *InMemoryRepository) GetOrdersByUserID(ctx context.Context, userID string) ([]models.Order, error) {
+func (r *InMemoryRepository) GetOrdersByUserID(ctx context.Context, userID string, filter models.OrderListFilter) ([]models.Order, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	orders := common.FilterMap[models.Order](r.orders, func(x models.Order) bool {
-		return x.UserID == userID
+		return x.UserID == userID && filter.MatchesOrder(x)
 	})
 
 	sort.Slice(orders, func(i, j int) bool {
-		return orders[i].UploadedAt.After(orders[j].UploadedAt)
+		return filter.Less(orders[i].UploadedAt, orders[i].ID, orders[j].UploadedAt, orders[j].ID)
 	})
 
-	return orders, nil
+	return limit(orders, filter.Limit), nil
+}
+
+// orders due for a check by dueAt, the caller holds the lock
+func (r *InMemoryRepository) unprocessedOrders(dueAt time.Time) []models.Order {
+	return common.FilterMap[models.Order](r.orders, func(x models.Order) bool {
+		return (x.Status == models.OrderStatusNew || x.Status == models.OrderStatusProcessing) && !x.NextCheckAt.After(dueAt)
+	})
 }
 
-func (r *InMemoryRepository) GetUnprocessedOrders(ctx context.Context) ([]models.Order, error) {
+func (r *InMemoryRepository) CountUnprocessedOrders(ctx context.Context, dueAt time.Time) (int, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	return len(r.unprocessedOrders(dueAt)), nil
+}
+
+func (r *InMemoryRepository) LeaseUnprocessedOrders(ctx context.Context, owner string, now time.Time,
+	leaseDuration time.Duration, limit int) ([]models.Order, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	orders := common.FilterMap[models.Order](r.orders, func(x models.Order) bool {
-		return x.Status == models.OrderStatusNew || x.Status == models.OrderStatusProcessing
+		lease, leased := r.leases[x.ID]
+		return (x.Status == models.OrderStatusNew || x.Status == models.OrderStatusProcessing) &&
+			!x.NextCheckAt.After(now) && (!leased || lease.until.Before(now))
+	})
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].NextCheckAt.Before(orders[j].NextCheckAt)
 	})
 
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+
+	for _, o := range orders {
+		r.leases[o.ID] = orderLease{owner: owner, until: now.Add(leaseDuration)}
+	}
+
 	return orders, nil
 }
 
-func (r *InMemoryRepository) UpdateOrderAccrualStatus(ctx context.Context, orderID string,
-	status models.OrderStatus, accrual float32) error {
+func (r *InMemoryRepository) ReleaseOrderLease(ctx context.Context, orderID string, owner string) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if lease, leased := r.leases[orderID]; leased && lease.owner == owner {
+		delete(r.leases, orderID)
+	}
+
+	return nil
+}
+
+func (r *InMemoryRepository) ScheduleOrderCheck(ctx context.Context, orderID string, owner string,
+	nextCheckAt time.Time, attemptCount int) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	o, exist := r.orders[orderID]
 
@@ -190,8 +344,12 @@ func (r *InMemoryRepository) UpdateOrderAccrualStatus(ctx context.Context, order
 		return common.ErrorNotFound
 	}
 
-	o.Status = status
-	o.Accrual = accrual
+	if lease, leased := r.leases[orderID]; !leased || lease.owner != owner {
+		return common.ErrorOrderLeaseLost
+	}
+
+	o.NextCheckAt = nextCheckAt
+	o.AttemptCount = attemptCount
 
 	r.orders[orderID] = o
 
@@ -199,67 +357,126 @@ func (r *InMemoryRepository) UpdateOrderAccrualStatus(ctx context.Context, order
 
 }
 
-func (r *InMemoryRepository) UpdateUserAccruedTotal(ctx context.Context, userID string, amount float32) error {
+func (r *InMemoryRepository) UpdateOrderAccrualStatus(ctx context.Context, orderID string, owner string,
+	status models.OrderStatus, accrual models.Money) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	user, exist := r.users[userID]
+	o, exist := r.orders[orderID]
 
 	if !exist {
 		return common.ErrorNotFound
 	}
 
-	user.AccruedTotal = amount
+	if lease, leased := r.leases[orderID]; !leased || lease.owner != owner {
+		return common.ErrorOrderLeaseLost
+	}
 
-	r.users[userID] = user
+	o.Status = status
+	o.Accrual = accrual
+
+	r.orders[orderID] = o
 
 	return nil
 
 }
 
-func (r *InMemoryRepository) GetWithdrawalsTotalAmountByUserID(ctx context.Context, userID string) (float32, error) {
-	withdrawals := common.FilterMap[models.Withdrawal](r.withdrawals, func(x models.Withdrawal) bool {
-		return x.UserID == userID
-	})
+func (r *InMemoryRepository) FindUserByID(ctx context.Context, userID string) (models.User, error) {
 
-	var res float32
-	for _, w := range withdrawals {
-		res += w.Amount
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.User{}, err
 	}
+	defer release()
 
-	return res, nil
+	user, exists := r.users[userID]
+	if !exists {
+		return models.User{}, common.ErrorNotFound
+	} else {
+		return user, nil
+	}
 
 }
 
-func (r *InMemoryRepository) UpdateUserWithdrawnTotal(ctx context.Context, userID string, amount float32) error {
+func (r *InMemoryRepository) FindUserByIDForUpdate(ctx context.Context, userID string) (models.User, error) {
 
-	user, exist := r.users[userID]
+	// transactions are serialised, so holding the repository lock is as good as holding the row lock
+	return r.FindUserByID(ctx, userID)
+}
 
-	if !exist {
-		return common.ErrorNotFound
+func (r *InMemoryRepository) UpdateUserPassword(ctx context.Context, userID string, password string) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
 	}
+	defer release()
 
-	user.WithdrawnTotal = amount
+	user, exists := r.users[userID]
+	if !exists {
+		return common.ErrorNotFound
+	}
 
+	user.Password = password
 	r.users[userID] = user
 
 	return nil
-
 }
 
-func (r *InMemoryRepository) FindUserByID(ctx context.Context, userID string) (models.User, error) {
+func (r *InMemoryRepository) UpdateUserRole(ctx context.Context, userID string, role models.Role) error {
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	user, exists := r.users[userID]
 	if !exists {
-		return models.User{}, common.ErrorNotFound
-	} else {
-		return user, nil
+		return common.ErrorNotFound
+	}
+
+	user.Role = role
+	r.users[userID] = user
+
+	return nil
+}
+
+func (r *InMemoryRepository) HasUsersWithRole(ctx context.Context, role models.Role) (bool, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return false, err
 	}
+	defer release()
 
+	for _, user := range r.users {
+		if user.Role == role {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 func (r *InMemoryRepository) AddWithdrawal(ctx context.Context, item *models.Withdrawal) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, w := range r.withdrawals {
+		if w.Order == item.Order {
+			return common.ErrorAlreadyExists
+		}
+	}
+
 	id, err := r.newUUID()
 	if err != nil {
 		return err
@@ -271,36 +488,536 @@ func (r *InMemoryRepository) AddWithdrawal(ctx context.Context, item *models.Wit
 	return nil
 }
 
-func (r *InMemoryRepository) GetWithdrawalsByUserID(ctx context.Context, userID string) ([]models.Withdrawal, error) {
+func (r *InMemoryRepository) MarkWithdrawalReversed(ctx context.Context, id string, reversedAt time.Time, reversedBy string) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	w, exists := r.withdrawals[id]
+	if !exists || !w.ReversedAt.IsZero() {
+		return common.ErrorNotFound
+	}
+
+	w.ReversedAt = reversedAt
+	w.ReversedBy = reversedBy
+	r.withdrawals[id] = w
+
+	return nil
+}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *InMemoryRepository) FindWithdrawalByOrder(ctx context.Context, order string) (models.Withdrawal, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.Withdrawal{}, err
+	}
+	defer release()
+
+	for _, w := range r.withdrawals {
+		if w.Order == order {
+			return w, nil
+		}
+	}
+
+	return models.Withdrawal{}, common.ErrorNotFound
+}
+
+func (r *InMemoryRepository) GetWithdrawalsByUserID(ctx context.Context, userID string, filter models.ListFilter) ([]models.Withdrawal, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	withdrawals := common.FilterMap[models.Withdrawal](r.withdrawals, func(x models.Withdrawal) bool {
-		return x.UserID == userID
+		return x.UserID == userID && filter.Matches(x.UploadedAt, x.ID)
 	})
 
 	sort.Slice(withdrawals, func(i, j int) bool {
-		return withdrawals[i].UploadedAt.After(withdrawals[j].UploadedAt)
+		return filter.Less(withdrawals[i].UploadedAt, withdrawals[i].ID, withdrawals[j].UploadedAt, withdrawals[j].ID)
 	})
 
-	return withdrawals, nil
+	return limit(withdrawals, filter.Limit), nil
+}
+
+// returns first n items, all of them if n is zero
+func limit[T any](items []T, n int) []T {
+	if n > 0 && len(items) > n {
+		return items[:n]
+	}
+	return items
 }
 
-func (r *InMemoryRepository) GetAccrualsTotalAmountByUserID(ctx context.Context, userID string) (float32, error) {
+// returns the running balance after the latest ledger entry of the user
+func (r *InMemoryRepository) currentBalance(userID string) models.Money {
+	for i := len(r.ledger) - 1; i >= 0; i-- {
+		if r.ledger[i].UserID == userID {
+			return r.ledger[i].Balance
+		}
+	}
+	return 0
+}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *InMemoryRepository) AddLedgerEntry(ctx context.Context, entry *models.LedgerEntry) error {
 
-	orders := common.FilterMap[models.Order](r.orders, func(x models.Order) bool {
-		return x.UserID == userID && x.Status == models.OrderStatusProcessed
-	})
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// every order is credited, every withdrawal is debited and reversed and every adjustment is applied only once
+	for _, e := range r.ledger {
+		if e.Type == entry.Type && ((entry.OrderID != "" && e.OrderID == entry.OrderID) ||
+			(entry.WithdrawalID != "" && e.WithdrawalID == entry.WithdrawalID) ||
+			(entry.AdjustmentID != "" && e.AdjustmentID == entry.AdjustmentID)) {
+			return common.ErrorAlreadyExists
+		}
+	}
+
+	id, err := r.newUUID()
+	if err != nil {
+		return err
+	}
+
+	systemID, err := r.newUUID()
+	if err != nil {
+		return err
+	}
+
+	entry.ID = id
+	entry.Balance = r.currentBalance(entry.UserID) + entry.Amount
+	entry.CreatedAt = time.Now()
+	r.ledger = append(r.ledger, *entry)
+	r.system = append(r.system, models.SystemLedgerEntry{ID: systemID, Account: entry.Type.Account(), EntryID: entry.ID,
+		Amount: -entry.Amount, CreatedAt: entry.CreatedAt})
+
+	return nil
+}
+
+func (r *InMemoryRepository) GetLedgerEntriesByUserID(ctx context.Context, userID string) ([]models.LedgerEntry, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var entries []models.LedgerEntry
+	for _, e := range r.ledger {
+		if e.UserID == userID {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (r *InMemoryRepository) GetLedgerAccountBalance(ctx context.Context, account models.LedgerAccount) (models.Money, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var balance models.Money
+	for _, e := range r.system {
+		if e.Account == account {
+			balance += e.Amount
+		}
+	}
+
+	return balance, nil
+}
 
-	var res float32
-	for _, w := range orders {
-		res += w.Accrual
+func (r *InMemoryRepository) GetUserBalance(ctx context.Context, userID string) (models.Balance, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	defer release()
+
+	balance := models.Balance{Current: r.currentBalance(userID)}
+	for _, e := range r.ledger {
+		if e.UserID == userID && e.WithdrawalID != "" {
+			balance.Withdrawn -= e.Amount
+		}
+	}
+
+	return balance, nil
+}
+
+func (r *InMemoryRepository) AddAdjustment(ctx context.Context, adjustment *models.Adjustment) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	id, err := r.newUUID()
+	if err != nil {
+		return err
+	}
+
+	adjustment.ID = id
+	adjustment.CreatedAt = time.Now()
+	r.adjustments = append(r.adjustments, *adjustment)
+
+	return nil
+}
+
+func (r *InMemoryRepository) GetAdjustmentsByUserID(ctx context.Context, userID string) ([]models.Adjustment, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var adjustments []models.Adjustment
+	for i := len(r.adjustments) - 1; i >= 0; i-- {
+		if r.adjustments[i].UserID == userID {
+			adjustments = append(adjustments, r.adjustments[i])
+		}
+	}
+
+	return adjustments, nil
+}
+
+func (r *InMemoryRepository) AddIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	id := idempotencyKeyID{key.UserID, key.Key}
+	if _, ok := r.idempotency[id]; ok {
+		return common.ErrorAlreadyExists
+	}
+
+	key.CreatedAt = time.Now()
+	r.idempotency[id] = *key
+
+	return nil
+}
+
+func (r *InMemoryRepository) FindIdempotencyKey(ctx context.Context, userID string, key string) (models.IdempotencyKey, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.IdempotencyKey{}, err
+	}
+	defer release()
+
+	k, ok := r.idempotency[idempotencyKeyID{userID, key}]
+	if !ok {
+		return models.IdempotencyKey{}, common.ErrorNotFound
+	}
+
+	return k, nil
+}
+
+func (r *InMemoryRepository) CompleteIdempotencyKey(ctx context.Context, userID string, key string, statusCode int, headers map[string][]string, body []byte) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
 	}
+	defer release()
 
-	return res, nil
+	id := idempotencyKeyID{userID, key}
+	k, ok := r.idempotency[id]
+	if !ok {
+		return common.ErrorNotFound
+	}
+
+	k.StatusCode = statusCode
+	k.Headers = cloneHeaders(headers)
+	k.Body = slices.Clone(body)
+	r.idempotency[id] = k
+
+	return nil
+}
+
+func (r *InMemoryRepository) DeleteIdempotencyKey(ctx context.Context, userID string, key string) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	delete(r.idempotency, idempotencyKeyID{userID, key})
+
+	return nil
+}
+
+func (r *InMemoryRepository) DeleteIdempotencyKeysBefore(ctx context.Context, createdBefore time.Time) (int, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	n := 0
+	for id, k := range r.idempotency {
+		if k.CreatedAt.Before(createdBefore) {
+			delete(r.idempotency, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// stored headers must not share the slices with the caller
+func cloneHeaders(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	clone := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		clone[name] = slices.Clone(values)
+	}
+	return clone
+}
 
+func (r *InMemoryRepository) AddRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, t := range r.refresh {
+		if t.TokenHash == token.TokenHash {
+			return common.ErrorAlreadyExists
+		}
+	}
+
+	id, err := r.newUUID()
+	if err != nil {
+		return err
+	}
+
+	token.ID = id
+	token.CreatedAt = time.Now()
+	r.refresh[id] = *token
+
+	return nil
+}
+
+func (r *InMemoryRepository) FindRefreshTokenByHash(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+	defer release()
+
+	for _, t := range r.refresh {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+
+	return models.RefreshToken{}, common.ErrorNotFound
+}
+
+func (r *InMemoryRepository) MarkRefreshTokenUsed(ctx context.Context, id string, usedAt time.Time) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	t, ok := r.refresh[id]
+	if !ok {
+		return common.ErrorNotFound
+	}
+
+	t.UsedAt = usedAt
+	r.refresh[id] = t
+
+	return nil
+}
+
+func (r *InMemoryRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for id, t := range r.refresh {
+		if t.FamilyID == familyID && t.RevokedAt.IsZero() {
+			t.RevokedAt = revokedAt
+			r.refresh[id] = t
+		}
+	}
+
+	return nil
+}
+
+func (r *InMemoryRepository) RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for id, t := range r.refresh {
+		if t.UserID == userID && t.RevokedAt.IsZero() {
+			t.RevokedAt = revokedAt
+			r.refresh[id] = t
+		}
+	}
+
+	return nil
+}
+
+func (r *InMemoryRepository) AddPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, t := range r.resets {
+		if t.TokenHash == token.TokenHash {
+			return common.ErrorAlreadyExists
+		}
+	}
+
+	id, err := r.newUUID()
+	if err != nil {
+		return err
+	}
+
+	token.ID = id
+	token.CreatedAt = time.Now()
+	r.resets[id] = *token
+
+	return nil
+}
+
+func (r *InMemoryRepository) FindPasswordResetTokenByHash(ctx context.Context, tokenHash string) (models.PasswordResetToken, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.PasswordResetToken{}, err
+	}
+	defer release()
+
+	for _, t := range r.resets {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+
+	return models.PasswordResetToken{}, common.ErrorNotFound
+}
+
+func (r *InMemoryRepository) InvalidateUserPasswordResetTokens(ctx context.Context, userID string, usedAt time.Time) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for id, t := range r.resets {
+		if t.UserID == userID && t.UsedAt.IsZero() {
+			t.UsedAt = usedAt
+			r.resets[id] = t
+		}
+	}
+
+	return nil
+}
+
+func (r *InMemoryRepository) FindLoginThrottle(ctx context.Context, key string) (models.LoginThrottle, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.LoginThrottle{}, err
+	}
+	defer release()
+
+	t, ok := r.throttles[key]
+	if !ok {
+		return models.LoginThrottle{}, common.ErrorNotFound
+	}
+
+	return t, nil
+}
+
+func (r *InMemoryRepository) RecordLoginFailure(ctx context.Context, key string, at time.Time, resetBefore time.Time) (models.LoginThrottle, error) {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return models.LoginThrottle{}, err
+	}
+	defer release()
+
+	t, ok := r.throttles[key]
+	if !ok || !t.LastFailureAt.After(resetBefore) {
+		t = models.LoginThrottle{Key: key}
+	}
+
+	t.Failures++
+	t.LastFailureAt = at
+	r.throttles[key] = t
+
+	return t, nil
+}
+
+func (r *InMemoryRepository) DeleteLoginThrottle(ctx context.Context, key string) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	delete(r.throttles, key)
+
+	return nil
+}
+
+func (r *InMemoryRepository) AddLoginAttempt(ctx context.Context, attempt *models.LoginAttempt) error {
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	id, err := r.newUUID()
+	if err != nil {
+		return err
+	}
+
+	attempt.ID = id
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+	r.attempts = append(r.attempts, *attempt)
+
+	return nil
 }
@@ -1,6 +1,14 @@
 package repository
 
-import "context"
+import (
+	"context"
+	"sync"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+)
+
+// context key for the transaction started by InMemoryUnitOfWork
+type inMemoryTxKey struct{}
 
 type InMemoryUnitOfWork struct {
 	repository *InMemoryRepository
@@ -10,21 +18,62 @@ func NewInMemoryUnitOfWork(r *InMemoryRepository) *InMemoryUnitOfWork {
 	return &InMemoryUnitOfWork{repository: r}
 }
 
+// InMemoryTx holds the repository lock from Begin till Commit or Rollback,
+// so concurrent transactions wait for each other
 type InMemoryTx struct {
 	repository *InMemoryRepository
+	snapshot   *inMemorySnapshot
+
+	mu       sync.Mutex
+	finished bool
 }
 
-func (u *InMemoryUnitOfWork) Begin(ctx context.Context) (UnitOfWorkTx, error) {
-	u.repository.BeginTransaction()
-	return &InMemoryTx{repository: u.repository}, nil
+// Begin waits until other transactions are finished and returns the context carrying
+// the new transaction, repository calls made with this context are part of it
+func (u *InMemoryUnitOfWork) Begin(ctx context.Context) (context.Context, UnitOfWorkTx, error) {
+
+	if tx, ok := ctx.Value(inMemoryTxKey{}).(*InMemoryTx); ok && tx.repository == u.repository {
+		return ctx, nil, common.ErrorAlreadyInTranscation
+	}
+
+	if err := u.repository.lockRepository(ctx); err != nil {
+		return ctx, nil, err
+	}
+
+	tx := &InMemoryTx{repository: u.repository, snapshot: u.repository.snapshot()}
+	return context.WithValue(ctx, inMemoryTxKey{}, tx), tx, nil
 }
 
 func (t *InMemoryTx) Commit() error {
-	t.repository.Commit()
-	return nil
+	return t.finish(false)
 }
 
 func (t *InMemoryTx) Rollback() error {
-	t.repository.Rollback()
+	return t.finish(true)
+}
+
+func (t *InMemoryTx) finish(rollback bool) error {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.finished {
+		return common.ErrorNotInTranscation
+	}
+
+	if rollback {
+		t.repository.restore(t.snapshot)
+	}
+
+	t.snapshot = nil
+	t.finished = true
+	t.repository.unlockRepository()
+
 	return nil
 }
+
+func (t *InMemoryTx) isFinished() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.finished
+}
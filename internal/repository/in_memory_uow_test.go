@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryUnitOfWork_Rollback(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewInMemoryRepository()
+	require.NoError(t, err)
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "user1", Password: "password1"})
+	require.NoError(t, err)
+
+	txCtx, tx, err := repo.UnitOfWork().Begin(ctx)
+	require.NoError(t, err)
+
+	_, err = repo.AddUser(txCtx, &models.User{Login: "user2", Password: "password2"})
+	require.NoError(t, err)
+
+	err = repo.AddLedgerEntry(txCtx, &models.LedgerEntry{UserID: user.ID, Type: models.LedgerEntryAdjustment, Amount: models.NewMoney(10)})
+	require.NoError(t, err)
+
+	// changes are visible within the transaction
+	b, err := repo.GetUserBalance(txCtx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.NewMoney(10), b.Current)
+
+	require.NoError(t, tx.Rollback())
+
+	_, err = repo.FindUserByLogin(ctx, "user2")
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	b, err = repo.GetUserBalance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Money(0), b.Current)
+
+	// transaction can be finished only once
+	require.ErrorIs(t, tx.Commit(), common.ErrorNotInTranscation)
+	require.ErrorIs(t, tx.Rollback(), common.ErrorNotInTranscation)
+
+	// and can't be used after it is finished
+	_, err = repo.FindUserByID(txCtx, user.ID)
+	require.ErrorIs(t, err, common.ErrorNotInTranscation)
+}
+
+func TestInMemoryUnitOfWork_Commit(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewInMemoryRepository()
+	require.NoError(t, err)
+
+	txCtx, tx, err := repo.UnitOfWork().Begin(ctx)
+	require.NoError(t, err)
+
+	_, err = repo.AddUser(txCtx, &models.User{Login: "user1", Password: "password1"})
+	require.NoError(t, err)
+
+	require.NoError(t, tx.Commit())
+
+	_, err = repo.FindUserByLogin(ctx, "user1")
+	require.NoError(t, err)
+}
+
+func TestInMemoryUnitOfWork_Isolation(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := NewInMemoryRepository()
+	require.NoError(t, err)
+
+	txCtx, tx, err := repo.UnitOfWork().Begin(ctx)
+	require.NoError(t, err)
+
+	// nested transactions are not supported
+	_, _, err = repo.UnitOfWork().Begin(txCtx)
+	require.ErrorIs(t, err, common.ErrorAlreadyInTranscation)
+
+	_, err = repo.AddUser(txCtx, &models.User{Login: "user1", Password: "password1"})
+	require.NoError(t, err)
+
+	// reads outside of the transaction wait until it is finished
+	// and never see uncommitted data
+	read := make(chan error, 1)
+	go func() {
+		_, err := repo.FindUserByLogin(ctx, "user1")
+		read <- err
+	}()
+
+	// as well as other transactions
+	began := make(chan error, 1)
+	go func() {
+		_, tx, err := repo.UnitOfWork().Begin(ctx)
+		if err == nil {
+			err = tx.Commit()
+		}
+		began <- err
+	}()
+
+	select {
+	case <-read:
+		t.Fatal("read outside of the transaction was not blocked")
+	case <-began:
+		t.Fatal("concurrent transaction was not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// waiting for the lock can be cancelled
+	cancelledCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, _, err = repo.UnitOfWork().Begin(cancelledCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, tx.Rollback())
+
+	require.ErrorIs(t, <-read, common.ErrorNotFound)
+	require.NoError(t, <-began)
+}
@@ -2,12 +2,18 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 )
 
 type DBStorage interface {
 	RunMigrations(ctx context.Context) error
+	Ping(ctx context.Context) error
+	// returns common.ErrorMigrationsPending if the database is behind the embedded migrations
+	CheckMigrations(ctx context.Context) error
+	// closes the connection pool, should be called once nothing uses the repository
+	Close() error
 }
 
 type Repository interface {
@@ -19,20 +25,75 @@ type Repository interface {
 	AddUser(ctx context.Context, user *models.User) (models.User, error)
 	FindUserByLogin(ctx context.Context, login string) (models.User, error)
 	FindUserByID(ctx context.Context, userID string) (models.User, error)
+	// locks the user row until the end of the transaction carried by ctx
+	FindUserByIDForUpdate(ctx context.Context, userID string) (models.User, error)
+	UpdateUserPassword(ctx context.Context, userID string, password string) error
+	UpdateUserRole(ctx context.Context, userID string, role models.Role) error
+	HasUsersWithRole(ctx context.Context, role models.Role) (bool, error)
 
 	// order and balance related
 	AddOrder(ctx context.Context, order *models.Order) (models.Order, error)
 	FindOrderByNumber(ctx context.Context, number string) (models.Order, error)
 
-	GetUnprocessedOrders(ctx context.Context) ([]models.Order, error)
-	UpdateOrderAccrualStatus(ctx context.Context, id string, status models.OrderStatus, accrual float32) error
-	UpdateUserAccruedTotal(ctx context.Context, userID string, amount float32) error
-	UpdateUserWithdrawnTotal(ctx context.Context, userID string, amount float32) error
-	GetOrdersByUserID(ctx context.Context, userID string) ([]models.Order, error)
+	CountUnprocessedOrders(ctx context.Context, dueAt time.Time) (int, error)
+	// order updates are rejected with common.ErrorOrderLeaseLost unless the order is leased by the owner
+	ScheduleOrderCheck(ctx context.Context, id string, owner string, nextCheckAt time.Time, attemptCount int) error
+	// leases up to limit due orders to the owner, orders leased by someone else are skipped until the lease expires
+	LeaseUnprocessedOrders(ctx context.Context, owner string, now time.Time, leaseDuration time.Duration, limit int) ([]models.Order, error)
+	ReleaseOrderLease(ctx context.Context, id string, owner string) error
+	UpdateOrderAccrualStatus(ctx context.Context, id string, owner string, status models.OrderStatus, accrual models.Money) error
+	GetOrdersByUserID(ctx context.Context, userID string, filter models.OrderListFilter) ([]models.Order, error)
+	// returns common.ErrorAlreadyExists if there is a withdrawal for the same order
 	AddWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error
-	GetWithdrawalsByUserID(ctx context.Context, userID string) ([]models.Withdrawal, error)
-	GetWithdrawalsTotalAmountByUserID(ctx context.Context, userID string) (float32, error)
-	GetAccrualsTotalAmountByUserID(ctx context.Context, userID string) (float32, error)
+	FindWithdrawalByOrder(ctx context.Context, order string) (models.Withdrawal, error)
+	GetWithdrawalsByUserID(ctx context.Context, userID string, filter models.ListFilter) ([]models.Withdrawal, error)
+	// returns common.ErrorNotFound if there is no such withdrawal or it is already reversed
+	MarkWithdrawalReversed(ctx context.Context, id string, reversedAt time.Time, reversedBy string) error
+
+	// ledger related
+	// appends the entry along with its balancing entry in the system account
+	// and calculates the running balance, the user should be locked by the caller
+	AddLedgerEntry(ctx context.Context, entry *models.LedgerEntry) error
+	GetLedgerEntriesByUserID(ctx context.Context, userID string) ([]models.LedgerEntry, error)
+	// returns the sum of the system account entries
+	GetLedgerAccountBalance(ctx context.Context, account models.LedgerAccount) (models.Money, error)
+	GetUserBalance(ctx context.Context, userID string) (models.Balance, error)
+
+	// adjustment related
+	AddAdjustment(ctx context.Context, adjustment *models.Adjustment) error
+	// returns adjustments of the user newest first
+	GetAdjustmentsByUserID(ctx context.Context, userID string) ([]models.Adjustment, error)
+
+	// idempotency related
+	// returns common.ErrorAlreadyExists if the user has already used the key
+	AddIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error
+	FindIdempotencyKey(ctx context.Context, userID string, key string) (models.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, userID string, key string, statusCode int, headers map[string][]string, body []byte) error
+	DeleteIdempotencyKey(ctx context.Context, userID string, key string) error
+	// deletes keys created before createdBefore, returns the number of deleted keys
+	DeleteIdempotencyKeysBefore(ctx context.Context, createdBefore time.Time) (int, error)
+
+	// refresh token related
+	AddRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	FindRefreshTokenByHash(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id string, usedAt time.Time) error
+	// revokes all tokens of the family which are not revoked yet
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	// revokes all tokens of the user which are not revoked yet
+	RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error
+
+	// password reset related
+	AddPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error
+	FindPasswordResetTokenByHash(ctx context.Context, tokenHash string) (models.PasswordResetToken, error)
+	// marks all unused tokens of the user used
+	InvalidateUserPasswordResetTokens(ctx context.Context, userID string, usedAt time.Time) error
+
+	// login throttling related
+	FindLoginThrottle(ctx context.Context, key string) (models.LoginThrottle, error)
+	// atomically increments the failure counter, failures made before resetBefore are forgotten
+	RecordLoginFailure(ctx context.Context, key string, at time.Time, resetBefore time.Time) (models.LoginThrottle, error)
+	DeleteLoginThrottle(ctx context.Context, key string) error
+	AddLoginAttempt(ctx context.Context, attempt *models.LoginAttempt) error
 }
 
 type UnitOfWorkTx interface {
@@ -41,5 +102,6 @@ type UnitOfWorkTx interface {
 }
 
 type UnitOfWork interface {
-	Begin(ctx context.Context) (UnitOfWorkTx, error)
+	// starts a transaction, repository calls made with the returned context are part of it
+	Begin(ctx context.Context) (context.Context, UnitOfWorkTx, error)
 }
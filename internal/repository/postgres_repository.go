@@ -3,26 +3,59 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
+	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/migrations"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
 type PostgresRepository struct {
 	db *sql.DB
+
+	latestMigration int64 // version of the latest embedded migration
 }
 
 func NewPostgresRepository(ctx context.Context, dsn string) (*PostgresRepository, error) {
+	latest, err := latestMigrationVersion(migrations.Migrations)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	return &PostgresRepository{db}, nil
+	return &PostgresRepository{db: db, latestMigration: latest}, nil
+}
+
+// latestMigrationVersion returns the highest version of the migrations in fsys,
+// the version is the numeric prefix of the file name
+func latestMigrationVersion(fsys fs.FS) (int64, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest int64
+	for _, name := range names {
+		version, err := goose.NumericComponent(name)
+		if err != nil {
+			return 0, err
+		}
+		latest = max(latest, version)
+	}
+
+	return latest, nil
 }
 
 func (r *PostgresRepository) RunMigrations(ctx context.Context) error {
@@ -37,19 +70,53 @@ func (r *PostgresRepository) RunMigrations(ctx context.Context) error {
 
 }
 
+func (r *PostgresRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *PostgresRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// CheckMigrations compares the applied version with the latest embedded one, the version table
+// is only read, so that the probe never changes the database
+func (r *PostgresRepository) CheckMigrations(ctx context.Context) error {
+
+	// the latest record of a version tells if it is applied or rolled back
+	s := fmt.Sprintf(`select coalesce(max(version_id), 0) from (
+			select distinct on (version_id) version_id, is_applied from %s order by version_id, id desc
+		) versions where is_applied`, goose.TableName())
+
+	var current int64
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.db.QueryRowContext(ctx, s).Scan(&current)
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if current < r.latestMigration {
+		return fmt.Errorf("%w: version %d, latest %d", common.ErrorMigrationsPending, current, r.latestMigration)
+	}
+
+	return nil
+}
+
 func (r *PostgresRepository) UnitOfWork() UnitOfWork {
 	return &PgUnitOfWork{r.db}
 }
 
 func (r *PostgresRepository) FindUserByLogin(ctx context.Context, login string) (models.User, error) {
 
-	s := "select id, login, password, salt from users where login=$1"
+	s := "select id, login, password, role from users where lower(login)=lower($1)"
 
 	var user models.User
 
 	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
-		r := r.db.QueryRowContext(ctx, s, login)
-		err := r.Scan(&user.ID, &user.Login, &user.Password, &user.Salt)
+		r := r.conn(ctx).QueryRowContext(ctx, s, login)
+		err := r.Scan(&user.ID, &user.Login, &user.Password, &user.Role)
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -66,16 +133,74 @@ func (r *PostgresRepository) FindUserByLogin(ctx context.Context, login string)
 
 func (r *PostgresRepository) AddUser(ctx context.Context, user *models.User) (models.User, error) {
 
-	s := "insert into users (login, password, salt) values ($1, $2, $3) RETURNING id"
+	s := "insert into users (login, password, role) values ($1, $2, $3) RETURNING id"
+
+	// users are regular users unless stated otherwise
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
 
 	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
-		err := r.db.QueryRowContext(ctx, s, user.Login, user.Password, user.Salt).Scan(&user.ID)
+		err := r.conn(ctx).QueryRowContext(ctx, s, user.Login, user.Password, user.Role).Scan(&user.ID)
+		if isUniqueViolation(err) {
+			return nil, common.ErrorLoginAlreadyExists
+		}
 		return nil, err
 	})
 
 	return *user, err
 }
 
+func (r *PostgresRepository) UpdateUserPassword(ctx context.Context, userID string, password string) error {
+
+	s := "update users set password = $1 where id = $2"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, password, userID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return nil, common.ErrorNotFound
+		}
+		return res, nil
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) UpdateUserRole(ctx context.Context, userID string, role models.Role) error {
+
+	s := "update users set role = $1 where id = $2"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, role, userID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return nil, common.ErrorNotFound
+		}
+		return res, nil
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) HasUsersWithRole(ctx context.Context, role models.Role) (bool, error) {
+
+	s := "select exists (select 1 from users where role = $1)"
+
+	var exists bool
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, role).Scan(&exists)
+		return nil, err
+	})
+
+	return exists, err
+}
+
 func (r *PostgresRepository) FindOrderByNumber(ctx context.Context, number string) (models.Order, error) {
 
 	var order models.Order
@@ -83,7 +208,7 @@ func (r *PostgresRepository) FindOrderByNumber(ctx context.Context, number strin
 	s := "select id, user_id, number, uploaded_at, accrual from orders where number = $1 order by uploaded_at desc"
 
 	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
-		r := r.db.QueryRowContext(ctx, s, number)
+		r := r.conn(ctx).QueryRowContext(ctx, s, number)
 		err := r.Scan(&order.ID, &order.UserID, &order.Number, &order.UploadedAt, &order.Accrual)
 
 		if err != nil {
@@ -103,7 +228,7 @@ func (r *PostgresRepository) AddOrder(ctx context.Context, order *models.Order)
 	s := "insert into orders (user_id, number, status) values ($1, $2, $3) RETURNING id"
 
 	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
-		err := r.db.QueryRowContext(ctx, s, order.UserID, order.Number, order.Status).Scan(&order.ID)
+		err := r.conn(ctx).QueryRowContext(ctx, s, order.UserID, order.Number, order.Status).Scan(&order.ID)
 		return nil, err
 	})
 
@@ -111,12 +236,57 @@ func (r *PostgresRepository) AddOrder(ctx context.Context, order *models.Order)
 
 }
 
-func (r *PostgresRepository) GetOrdersByUserID(ctx context.Context, userID string) ([]models.Order, error) {
+// appends the filter conditions, ordering and limit to the query selecting user history
+func listQuery(s string, args []any, filter models.ListFilter) (string, []any) {
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !filter.From.IsZero() {
+		s += " and uploaded_at >= " + arg(filter.From)
+	}
+	if !filter.To.IsZero() {
+		s += " and uploaded_at < " + arg(filter.To)
+	}
+
+	direction, op := "desc", "<"
+	if filter.Ascending {
+		direction, op = "asc", ">"
+	}
+
+	if filter.After != nil {
+		s += fmt.Sprintf(" and (uploaded_at, id) %s (%s, %s::uuid)", op, arg(filter.After.UploadedAt), arg(filter.After.ID))
+	}
+
+	s += fmt.Sprintf(" order by uploaded_at %s, id %s", direction, direction)
+
+	if filter.Limit > 0 {
+		s += " limit " + arg(filter.Limit)
+	}
+
+	return s, args
+}
+
+func (r *PostgresRepository) GetOrdersByUserID(ctx context.Context, userID string, filter models.OrderListFilter) ([]models.Order, error) {
 
-	s := "select id, user_id, number, uploaded_at, accrual, status from orders where user_id = $1 order by uploaded_at desc"
+	s := "select id, user_id, number, uploaded_at, accrual, status, next_check_at, attempt_count from orders where user_id = $1"
+	args := []any{userID}
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = string(status)
+		}
+		args = append(args, statuses)
+		s += fmt.Sprintf(" and status = any($%d)", len(args))
+	}
+
+	s, args = listQuery(s, args, filter.ListFilter)
 
 	rows, err := common.RetryWithResult(ctx, func() (*sql.Rows, error) {
-		rows, err := r.db.QueryContext(ctx, s, userID)
+		rows, err := r.conn(ctx).QueryContext(ctx, s, args...)
 		return rows, err
 	})
 
@@ -133,7 +303,8 @@ func (r *PostgresRepository) getOrdersFromRows(rows *sql.Rows) ([]models.Order,
 	defer rows.Close()
 	for rows.Next() {
 		var order = models.Order{}
-		err := rows.Scan(&order.ID, &order.UserID, &order.Number, &order.UploadedAt, &order.Accrual, &order.Status)
+		err := rows.Scan(&order.ID, &order.UserID, &order.Number, &order.UploadedAt, &order.Accrual, &order.Status,
+			&order.NextCheckAt, &order.AttemptCount)
 		if err != nil {
 			return nil, err
 		}
@@ -147,12 +318,37 @@ func (r *PostgresRepository) getOrdersFromRows(rows *sql.Rows) ([]models.Order,
 	return orders, nil
 }
 
-func (r *PostgresRepository) GetUnprocessedOrders(ctx context.Context) ([]models.Order, error) {
+func (r *PostgresRepository) CountUnprocessedOrders(ctx context.Context, dueAt time.Time) (int, error) {
+
+	s := `select count(*) from orders where status in ($1,  $2) and next_check_at <= $3`
+
+	var count int
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, models.OrderStatusNew, models.OrderStatusProcessing, dueAt).Scan(&count)
+		return nil, err
+	})
+
+	return count, err
+}
+
+func (r *PostgresRepository) LeaseUnprocessedOrders(ctx context.Context, owner string, now time.Time,
+	leaseDuration time.Duration, limit int) ([]models.Order, error) {
 
-	s := "select id, user_id, number, uploaded_at, accrual, status from orders where status in ($1,  $2)"
+	// rows locked by a concurrent lease are skipped rather than waited for
+	s := `update orders set leased_by = $1, leased_until = $2
+		where id in (
+			select id from orders
+			where status in ($3, $4) and next_check_at <= $5 and (leased_until is null or leased_until < $5)
+			order by next_check_at
+			limit $6
+			for update skip locked
+		)
+		returning id, user_id, number, uploaded_at, accrual, status, next_check_at, attempt_count`
 
 	rows, err := common.RetryWithResult(ctx, func() (*sql.Rows, error) {
-		rows, err := r.db.QueryContext(ctx, s, models.OrderStatusNew, models.OrderStatusProcessing)
+		rows, err := r.conn(ctx).QueryContext(ctx, s, owner, now.Add(leaseDuration),
+			models.OrderStatusNew, models.OrderStatusProcessing, now, limit)
 		return rows, err
 	})
 
@@ -164,12 +360,12 @@ func (r *PostgresRepository) GetUnprocessedOrders(ctx context.Context) ([]models
 
 }
 
-func (r *PostgresRepository) UpdateOrderAccrualStatus(ctx context.Context, orderID string, status models.OrderStatus, accrual float32) error {
+func (r *PostgresRepository) ReleaseOrderLease(ctx context.Context, orderID string, owner string) error {
 
-	s := "update orders set status = $1, accrual = $2 where id = $3"
+	s := "update orders set leased_by = null, leased_until = null where id = $1 and leased_by = $2"
 
 	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
-		res, err := r.db.ExecContext(ctx, s, status, accrual, orderID)
+		res, err := r.conn(ctx).ExecContext(ctx, s, orderID, owner)
 		return res, err
 	})
 
@@ -177,41 +373,99 @@ func (r *PostgresRepository) UpdateOrderAccrualStatus(ctx context.Context, order
 
 }
 
-func (r *PostgresRepository) UpdateUserAccruedTotal(ctx context.Context, userID string, amount float32) error {
+func (r *PostgresRepository) ScheduleOrderCheck(ctx context.Context, orderID string, owner string,
+	nextCheckAt time.Time, attemptCount int) error {
 
-	s := "update users set accrued_total = $1 where id = $2"
+	s := "update orders set next_check_at = $1, attempt_count = $2 where id = $3 and leased_by = $4"
 
-	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
-		res, err := r.db.ExecContext(ctx, s, amount, userID)
+	res, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, nextCheckAt, attemptCount, orderID, owner)
 		return res, err
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	return r.checkOrderLease(ctx, res, orderID)
 
 }
 
-func (r *PostgresRepository) UpdateUserWithdrawnTotal(ctx context.Context, userID string, amount float32) error {
+func (r *PostgresRepository) UpdateOrderAccrualStatus(ctx context.Context, orderID string, owner string,
+	status models.OrderStatus, accrual models.Money) error {
 
-	s := "update users set withdrawn_total = $1 where id = $2"
+	s := "update orders set status = $1, accrual = $2 where id = $3 and leased_by = $4"
 
-	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
-		res, err := r.db.ExecContext(ctx, s, amount, userID)
+	res, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, status, accrual, orderID, owner)
 		return res, err
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	return r.checkOrderLease(ctx, res, orderID)
 
 }
 
+// tells a missing order from the one leased by another owner when the update did not change anything
+func (r *PostgresRepository) checkOrderLease(ctx context.Context, res sql.Result, orderID string) error {
+
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var exists bool
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, "select exists(select 1 from orders where id = $1)", orderID).Scan(&exists)
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return common.ErrorNotFound
+	}
+	return common.ErrorOrderLeaseLost
+}
+
 func (r *PostgresRepository) FindUserByID(ctx context.Context, userID string) (models.User, error) {
-	s := "select id, login, password, accrued_total, withdrawn_total from users where id=$1"
+	s := "select id, login, password, role from users where id=$1"
 
 	var user models.User
 
 	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
-		r := r.db.QueryRowContext(ctx, s, userID)
-		err := r.Scan(&user.ID, &user.Login, &user.Password, &user.AccruedTotal, &user.WithdrawnTotal)
-		return r, err
+		r := r.conn(ctx).QueryRowContext(ctx, s, userID)
+		err := r.Scan(&user.ID, &user.Login, &user.Password, &user.Role)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, common.ErrorNotFound
+			}
+			return nil, err
+		}
+		return r, nil
+	})
+
+	return user, err
+
+}
+
+func (r *PostgresRepository) FindUserByIDForUpdate(ctx context.Context, userID string) (models.User, error) {
+	s := "select id, login, password, role from users where id=$1 for update"
+
+	var user models.User
+
+	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
+		r := r.conn(ctx).QueryRowContext(ctx, s, userID)
+		err := r.Scan(&user.ID, &user.Login, &user.Password, &user.Role)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, common.ErrorNotFound
+			}
+			return nil, err
+		}
+		return r, nil
 	})
 
 	return user, err
@@ -220,74 +474,88 @@ func (r *PostgresRepository) FindUserByID(ctx context.Context, userID string) (m
 
 func (r *PostgresRepository) AddWithdrawal(ctx context.Context, item *models.Withdrawal) error {
 
-	s := "insert into withdrawals (user_id, \"order\", amount) values ($1, $2, $3)"
+	s := "insert into withdrawals (user_id, \"order\", amount) values ($1, $2, $3) RETURNING id"
 
-	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
-		res, err := r.db.ExecContext(ctx, s, item.UserID, item.Order, item.Amount)
-		return res, err
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, item.UserID, item.Order, item.Amount).Scan(&item.ID)
+		if isUniqueViolation(err) {
+			return nil, common.ErrorAlreadyExists
+		}
+		return nil, err
 	})
 
 	return err
 
 }
 
-func (r *PostgresRepository) GetWithdrawalsTotalAmountByUserID(ctx context.Context, userID string) (float32, error) {
-	s := "select coalesce(sum(amount), 0) from withdrawals where user_id = $1"
+func (r *PostgresRepository) MarkWithdrawalReversed(ctx context.Context, id string, reversedAt time.Time, reversedBy string) error {
 
-	var res float32
+	s := "update withdrawals set reversed_at = $1, reversed_by = $2 where id = $3 and reversed_at is null"
 
-	_, err := common.RetryWithResult(ctx, func() (any, error) {
-		err := r.db.QueryRowContext(ctx, s, userID).Scan(&res)
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, reversedAt, nullableUUID(reversedBy), id)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil, common.ErrorNotFound
-			}
 			return nil, err
 		}
-		return nil, err
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return nil, common.ErrorNotFound
+		}
+		return res, nil
 	})
 
-	return res, err
-
+	return err
 }
 
-func (r *PostgresRepository) GetAccrualsTotalAmountByUserID(ctx context.Context, userID string) (float32, error) {
-	s := "select coalesce(sum(accrual),0) from orders where user_id = $1 and status = $2"
+func (r *PostgresRepository) FindWithdrawalByOrder(ctx context.Context, order string) (models.Withdrawal, error) {
 
-	var res float32
+	s := `select id, user_id, "order", uploaded_at, amount, reversed_at, coalesce(reversed_by::text, '')
+		from withdrawals where "order" = $1 and duplicate_of is null`
 
-	_, err := common.RetryWithResult(ctx, func() (any, error) {
-		err := r.db.QueryRowContext(ctx, s, userID, models.OrderStatusProcessed).Scan(&res)
+	var withdrawal models.Withdrawal
+
+	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
+		var reversedAt sql.NullTime
+		r := r.conn(ctx).QueryRowContext(ctx, s, order)
+		err := r.Scan(&withdrawal.ID, &withdrawal.UserID, &withdrawal.Order, &withdrawal.UploadedAt, &withdrawal.Amount,
+			&reversedAt, &withdrawal.ReversedBy)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return nil, common.ErrorNotFound
 			}
 			return nil, err
 		}
-		return nil, err
+		withdrawal.ReversedAt = reversedAt.Time
+		return r, nil
 	})
 
-	return res, err
+	return withdrawal, err
 }
 
-func (r *PostgresRepository) GetWithdrawalsByUserID(ctx context.Context, userID string) ([]models.Withdrawal, error) {
+func (r *PostgresRepository) GetWithdrawalsByUserID(ctx context.Context, userID string, filter models.ListFilter) ([]models.Withdrawal, error) {
 
-	s := "select id, user_id, \"order\", uploaded_at, amount from withdrawals where user_id = $1 order by uploaded_at desc"
+	s, args := listQuery(`select id, user_id, "order", uploaded_at, amount, reversed_at, coalesce(reversed_by::text, '')
+		from withdrawals where user_id = $1`, []any{userID}, filter)
 
 	rows, err := common.RetryWithResult(ctx, func() (*sql.Rows, error) {
-		rows, err := r.db.QueryContext(ctx, s, userID)
+		rows, err := r.conn(ctx).QueryContext(ctx, s, args...)
 		return rows, err
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	var withdrawals = []models.Withdrawal{}
 
 	defer rows.Close()
 	for rows.Next() {
 		var withdrawal = models.Withdrawal{}
-		err := rows.Scan(&withdrawal.ID, &withdrawal.UserID, &withdrawal.Order, &withdrawal.UploadedAt, &withdrawal.Amount)
+		var reversedAt sql.NullTime
+		err := rows.Scan(&withdrawal.ID, &withdrawal.UserID, &withdrawal.Order, &withdrawal.UploadedAt, &withdrawal.Amount,
+			&reversedAt, &withdrawal.ReversedBy)
 		if err != nil {
 			return nil, err
 		}
+		withdrawal.ReversedAt = reversedAt.Time
 		withdrawals = append(withdrawals, withdrawal)
 	}
 
@@ -298,3 +566,442 @@ func (r *PostgresRepository) GetWithdrawalsByUserID(ctx context.Context, userID
 	return withdrawals, err
 
 }
+
+func (r *PostgresRepository) AddLedgerEntry(ctx context.Context, entry *models.LedgerEntry) error {
+
+	// running balance is calculated from the latest entry of the user, the user row is locked by the caller,
+	// the balancing entry is written by the same statement, so that neither is ever written alone
+	s := `with entry as (
+			insert into ledger_entries (user_id, entry_type, amount, balance, order_id, withdrawal_id, adjustment_id)
+			values ($1, $2, $3,
+				coalesce((select balance from ledger_entries where user_id = $1 order by seq desc limit 1), 0) + $3,
+				$4, $5, $6)
+			RETURNING id, amount, balance, created_at
+		), balancing as (
+			insert into system_ledger_entries (account, entry_id, amount, created_at)
+			select $7, id, -amount, created_at from entry
+		)
+		select id, balance, created_at from entry`
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, entry.UserID, entry.Type, entry.Amount,
+			nullableUUID(entry.OrderID), nullableUUID(entry.WithdrawalID), nullableUUID(entry.AdjustmentID), entry.Type.Account()).
+			Scan(&entry.ID, &entry.Balance, &entry.CreatedAt)
+		if isUniqueViolation(err) {
+			return nil, common.ErrorAlreadyExists
+		}
+		return nil, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) GetLedgerEntriesByUserID(ctx context.Context, userID string) ([]models.LedgerEntry, error) {
+
+	s := `select id, user_id, entry_type, amount, balance, coalesce(order_id::text, ''), coalesce(withdrawal_id::text, ''),
+			coalesce(adjustment_id::text, ''), created_at
+		from ledger_entries where user_id = $1 order by seq`
+
+	rows, err := common.RetryWithResult(ctx, func() (*sql.Rows, error) {
+		rows, err := r.conn(ctx).QueryContext(ctx, s, userID)
+		return rows, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.LedgerEntry
+
+	defer rows.Close()
+	for rows.Next() {
+		var entry models.LedgerEntry
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.Type, &entry.Amount, &entry.Balance,
+			&entry.OrderID, &entry.WithdrawalID, &entry.AdjustmentID, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (r *PostgresRepository) GetLedgerAccountBalance(ctx context.Context, account models.LedgerAccount) (models.Money, error) {
+
+	s := `select coalesce(sum(amount), 0) from system_ledger_entries where account = $1`
+
+	var balance models.Money
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, account).Scan(&balance)
+		return nil, err
+	})
+
+	return balance, err
+}
+
+func (r *PostgresRepository) GetUserBalance(ctx context.Context, userID string) (models.Balance, error) {
+
+	s := `select
+			coalesce((select balance from ledger_entries where user_id = $1 order by seq desc limit 1), 0),
+			coalesce((select -sum(amount) from ledger_entries where user_id = $1 and withdrawal_id is not null), 0)`
+
+	var balance models.Balance
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, userID).Scan(&balance.Current, &balance.Withdrawn)
+		return nil, err
+	})
+
+	return balance, err
+}
+
+func (r *PostgresRepository) AddAdjustment(ctx context.Context, adjustment *models.Adjustment) error {
+
+	s := `insert into balance_adjustments (user_id, amount, reason, comment, operator_id)
+		values ($1, $2, $3, $4, $5) RETURNING id, created_at`
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, adjustment.UserID, adjustment.Amount, adjustment.Reason,
+			adjustment.Comment, adjustment.OperatorID).Scan(&adjustment.ID, &adjustment.CreatedAt)
+		return nil, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) GetAdjustmentsByUserID(ctx context.Context, userID string) ([]models.Adjustment, error) {
+
+	s := `select id, user_id, amount, reason, comment, operator_id, created_at
+		from balance_adjustments where user_id = $1 order by created_at desc, id desc`
+
+	rows, err := common.RetryWithResult(ctx, func() (*sql.Rows, error) {
+		rows, err := r.conn(ctx).QueryContext(ctx, s, userID)
+		return rows, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var adjustments []models.Adjustment
+
+	defer rows.Close()
+	for rows.Next() {
+		var a models.Adjustment
+		err := rows.Scan(&a.ID, &a.UserID, &a.Amount, &a.Reason, &a.Comment, &a.OperatorID, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return adjustments, nil
+}
+
+func (r *PostgresRepository) AddIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error {
+
+	s := "insert into idempotency_keys (user_id, key, request_hash) values ($1, $2, $3) RETURNING created_at"
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, key.UserID, key.Key, key.RequestHash).Scan(&key.CreatedAt)
+		if isUniqueViolation(err) {
+			return nil, common.ErrorAlreadyExists
+		}
+		return nil, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) FindIdempotencyKey(ctx context.Context, userID string, key string) (models.IdempotencyKey, error) {
+
+	s := "select user_id, key, request_hash, status_code, headers, body, created_at from idempotency_keys where user_id = $1 and key = $2"
+
+	var k models.IdempotencyKey
+
+	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
+		var headers []byte
+		r := r.conn(ctx).QueryRowContext(ctx, s, userID, key)
+		err := r.Scan(&k.UserID, &k.Key, &k.RequestHash, &k.StatusCode, &headers, &k.Body, &k.CreatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, common.ErrorNotFound
+			}
+			return nil, err
+		}
+		// headers are null while the original request is in flight
+		if headers != nil {
+			if err := json.Unmarshal(headers, &k.Headers); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	})
+
+	return k, err
+}
+
+func (r *PostgresRepository) CompleteIdempotencyKey(ctx context.Context, userID string, key string, statusCode int, headers map[string][]string, body []byte) error {
+
+	s := "update idempotency_keys set status_code = $1, headers = $2, body = $3 where user_id = $4 and key = $5"
+
+	h, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	_, err = common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, statusCode, string(h), body, userID, key)
+		return res, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) DeleteIdempotencyKey(ctx context.Context, userID string, key string) error {
+
+	s := "delete from idempotency_keys where user_id = $1 and key = $2"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, userID, key)
+		return res, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) DeleteIdempotencyKeysBefore(ctx context.Context, createdBefore time.Time) (int, error) {
+
+	s := "delete from idempotency_keys where created_at < $1"
+
+	res, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, createdBefore)
+		return res, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *PostgresRepository) AddRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+
+	s := `insert into refresh_tokens (user_id, family_id, token_hash, expires_at) values ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt).
+			Scan(&token.ID, &token.CreatedAt)
+		if isUniqueViolation(err) {
+			return nil, common.ErrorAlreadyExists
+		}
+		return nil, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) FindRefreshTokenByHash(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+
+	s := `select id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at
+		from refresh_tokens where token_hash = $1`
+
+	var token models.RefreshToken
+
+	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
+		var usedAt, revokedAt sql.NullTime
+		r := r.conn(ctx).QueryRowContext(ctx, s, tokenHash)
+		err := r.Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt,
+			&usedAt, &revokedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, common.ErrorNotFound
+			}
+			return nil, err
+		}
+		token.UsedAt = usedAt.Time
+		token.RevokedAt = revokedAt.Time
+		return r, nil
+	})
+
+	return token, err
+}
+
+func (r *PostgresRepository) MarkRefreshTokenUsed(ctx context.Context, id string, usedAt time.Time) error {
+
+	s := "update refresh_tokens set used_at = $1 where id = $2"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, usedAt, id)
+		return res, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+
+	s := "update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, revokedAt, familyID)
+		return res, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+
+	s := "update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, revokedAt, userID)
+		return res, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) AddPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+
+	s := "insert into password_reset_tokens (user_id, token_hash, expires_at) values ($1, $2, $3) RETURNING id, created_at"
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, token.UserID, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+		if isUniqueViolation(err) {
+			return nil, common.ErrorAlreadyExists
+		}
+		return nil, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) FindPasswordResetTokenByHash(ctx context.Context, tokenHash string) (models.PasswordResetToken, error) {
+
+	s := "select id, user_id, token_hash, expires_at, created_at, used_at from password_reset_tokens where token_hash = $1"
+
+	var token models.PasswordResetToken
+
+	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
+		var usedAt sql.NullTime
+		r := r.conn(ctx).QueryRowContext(ctx, s, tokenHash)
+		err := r.Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &usedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, common.ErrorNotFound
+			}
+			return nil, err
+		}
+		token.UsedAt = usedAt.Time
+		return r, nil
+	})
+
+	return token, err
+}
+
+func (r *PostgresRepository) InvalidateUserPasswordResetTokens(ctx context.Context, userID string, usedAt time.Time) error {
+
+	s := "update password_reset_tokens set used_at = $1 where user_id = $2 and used_at is null"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, usedAt, userID)
+		return res, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) FindLoginThrottle(ctx context.Context, key string) (models.LoginThrottle, error) {
+
+	s := "select key, failures, last_failure_at from login_throttles where key = $1"
+
+	var t models.LoginThrottle
+
+	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
+		r := r.conn(ctx).QueryRowContext(ctx, s, key)
+		err := r.Scan(&t.Key, &t.Failures, &t.LastFailureAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, common.ErrorNotFound
+			}
+			return nil, err
+		}
+		return r, nil
+	})
+
+	return t, err
+}
+
+func (r *PostgresRepository) RecordLoginFailure(ctx context.Context, key string, at time.Time, resetBefore time.Time) (models.LoginThrottle, error) {
+
+	// single statement, so concurrent failures on several replicas are all counted
+	s := `insert into login_throttles (key, failures, last_failure_at) values ($1, 1, $2)
+		on conflict (key) do update set
+			failures = case when login_throttles.last_failure_at <= $3 then 1 else login_throttles.failures + 1 end,
+			last_failure_at = $2
+		returning key, failures, last_failure_at`
+
+	var t models.LoginThrottle
+
+	_, err := common.RetryWithResult(ctx, func() (*sql.Row, error) {
+		r := r.conn(ctx).QueryRowContext(ctx, s, key, at, resetBefore)
+		err := r.Scan(&t.Key, &t.Failures, &t.LastFailureAt)
+		return r, err
+	})
+
+	return t, err
+}
+
+func (r *PostgresRepository) DeleteLoginThrottle(ctx context.Context, key string) error {
+
+	s := "delete from login_throttles where key = $1"
+
+	_, err := common.RetryWithResult(ctx, func() (sql.Result, error) {
+		res, err := r.conn(ctx).ExecContext(ctx, s, key)
+		return res, err
+	})
+
+	return err
+}
+
+func (r *PostgresRepository) AddLoginAttempt(ctx context.Context, attempt *models.LoginAttempt) error {
+
+	s := "insert into login_attempts (login, ip, result, created_at) values ($1, $2, $3, $4) RETURNING id"
+
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+
+	_, err := common.RetryWithResult(ctx, func() (interface{}, error) {
+		err := r.conn(ctx).QueryRowContext(ctx, s, attempt.Login, attempt.IP, attempt.Result, attempt.CreatedAt).Scan(&attempt.ID)
+		return nil, err
+	})
+
+	return err
+}
+
+// checks if the error is caused by a unique index
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
+// empty identifiers are stored as NULL
+func nullableUUID(id string) any {
+	if id == "" {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_latestMigrationVersion(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"202504112000_create_database.sql": {},
+		"202504270000_add_column.sql":      {},
+		"202504150000_add_index.sql":       {},
+		"README.md":                        {},
+	}
+
+	latest, err := latestMigrationVersion(fsys)
+	require.NoError(t, err)
+	require.Equal(t, int64(202504270000), latest)
+
+	_, err = latestMigrationVersion(fstest.MapFS{"create_database.sql": {}})
+	require.Error(t, err)
+
+	// the embedded migrations are parsed the same way
+	latest, err = latestMigrationVersion(migrations.Migrations)
+	require.NoError(t, err)
+	require.Positive(t, latest)
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedQuerier makes a client span of every query, the statement is recorded without the arguments,
+// so passwords and tokens never get into the traces
+type tracedQuerier struct {
+	querier querier
+}
+
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	// span is named after the operation, the statement itself is too long for a name
+	name := "query"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+	return tracing.Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBQueryText(query)))
+}
+
+func (q tracedQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	res, err := q.querier.ExecContext(ctx, query, args...)
+	tracing.End(span, &err)
+	return res, err
+}
+
+func (q tracedQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := q.querier.QueryContext(ctx, query, args...)
+	tracing.End(span, &err)
+	return rows, err
+}
+
+func (q tracedQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	row := q.querier.QueryRowContext(ctx, query, args...)
+	// no rows is only reported by Scan, so Err is never sql.ErrNoRows
+	err := row.Err()
+	tracing.End(span, &err)
+	return row
+}
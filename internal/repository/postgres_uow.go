@@ -3,8 +3,21 @@ package repository
 import (
 	"context"
 	"database/sql"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/tracing"
 )
 
+// context key for the transaction started by PgUnitOfWork
+type pgTxKey struct{}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type PgUnitOfWork struct {
 	db *sql.DB
 }
@@ -13,12 +26,22 @@ func NewPgUnitOfWork(db *sql.DB) UnitOfWork {
 	return &PgUnitOfWork{db: db}
 }
 
-func (u *PgUnitOfWork) Begin(ctx context.Context) (UnitOfWorkTx, error) {
-	tx, err := u.db.BeginTx(ctx, nil)
+// Begin starts a transaction and returns the context carrying it,
+// repository calls made with this context are executed within the transaction
+func (u *PgUnitOfWork) Begin(ctx context.Context) (context.Context, UnitOfWorkTx, error) {
+
+	if _, ok := ctx.Value(pgTxKey{}).(*sql.Tx); ok {
+		return ctx, nil, common.ErrorAlreadyInTranscation
+	}
+
+	// waiting for a free connection of the pool is a part of the span
+	spanCtx, span := startQuerySpan(ctx, "BEGIN")
+	tx, err := u.db.BeginTx(spanCtx, nil)
+	tracing.End(span, &err)
 	if err != nil {
-		return nil, err
+		return ctx, nil, err
 	}
-	return &PgUnitOfWorkTx{tx: tx}, nil
+	return context.WithValue(ctx, pgTxKey{}, tx), &PgUnitOfWorkTx{tx: tx}, nil
 }
 
 type PgUnitOfWorkTx struct {
@@ -31,3 +54,11 @@ func (t *PgUnitOfWorkTx) Commit() error {
 func (t *PgUnitOfWorkTx) Rollback() error {
 	return t.tx.Rollback()
 }
+
+// returns the transaction carried by the context, or the pool if there is none
+func (r *PostgresRepository) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(pgTxKey{}).(*sql.Tx); ok {
+		return tracedQuerier{tx}
+	}
+	return tracedQuerier{r.db}
+}
@@ -2,11 +2,15 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	"github.com/go-playground/assert/v2"
+	"github.com/google/uuid"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
@@ -47,9 +51,14 @@ func TestRepositoryImplementations(t *testing.T) {
 		repo, err := NewPostgresRepository(ctx, dbURI)
 		require.NoError(t, err)
 
+		require.NoError(t, repo.Ping(ctx))
+		require.ErrorIs(t, repo.CheckMigrations(ctx), common.ErrorMigrationsPending)
+
 		err = repo.RunMigrations(ctx)
 		require.NoError(t, err)
 
+		require.NoError(t, repo.CheckMigrations(ctx))
+
 		RunRepositoryTests(t, ctx, "Postgres", repo)
 
 		testcontainers.CleanupContainer(t, ctr)
@@ -111,13 +120,80 @@ func RunRepositoryTests(t *testing.T, ctx context.Context, name string, repo Rep
 		require.NoError(t, err)
 	})
 
+	t.Run(name+"UpdateUserRole", func(t *testing.T) {
+		require.Equal(t, models.RoleUser, user2.Role)
+
+		exists, err := repo.HasUsersWithRole(ctx, models.RoleAdmin)
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		err = repo.UpdateUserRole(ctx, user2.ID, models.RoleAdmin)
+		require.NoError(t, err)
+
+		exists, err = repo.HasUsersWithRole(ctx, models.RoleAdmin)
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		user, err := repo.FindUserByID(ctx, user2.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.RoleAdmin, user.Role)
+
+		err = repo.UpdateUserRole(ctx, uuid.NewString(), models.RoleAdmin)
+		require.ErrorIs(t, err, common.ErrorNotFound)
+	})
+
+	t.Run(name+"PasswordResetTokens", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+
+		token := &models.PasswordResetToken{UserID: user2.ID, TokenHash: "resethash", ExpiresAt: expiresAt}
+		err := repo.AddPasswordResetToken(ctx, token)
+		require.NoError(t, err)
+
+		found, err := repo.FindPasswordResetTokenByHash(ctx, "resethash")
+		require.NoError(t, err)
+		require.Equal(t, user2.ID, found.UserID)
+		require.True(t, expiresAt.Equal(found.ExpiresAt))
+		require.True(t, found.UsedAt.IsZero())
+
+		err = repo.InvalidateUserPasswordResetTokens(ctx, user2.ID, time.Now())
+		require.NoError(t, err)
+
+		found, err = repo.FindPasswordResetTokenByHash(ctx, "resethash")
+		require.NoError(t, err)
+		require.False(t, found.UsedAt.IsZero())
+
+		_, err = repo.FindPasswordResetTokenByHash(ctx, "unknown")
+		require.ErrorIs(t, err, common.ErrorNotFound)
+	})
+
 	t.Run(name+"FindNonExistingUser", func(t *testing.T) {
-		orders, err := repo.GetOrdersByUserID(ctx, user1.ID)
+		orders, err := repo.GetOrdersByUserID(ctx, user1.ID, models.OrderListFilter{})
 		require.NoError(t, err)
 		assert.Equal(t, len(orders), 1)
 		assert.Equal(t, orders[0].Number, "4561261212345467")
 	})
 
+	t.Run(name+"LoginCaseInsensitive", func(t *testing.T) {
+		user, err := repo.FindUserByLogin(ctx, "USER2")
+		require.NoError(t, err)
+		require.Equal(t, user2.ID, user.ID)
+
+		_, err = repo.AddUser(ctx, &models.User{Login: "User2", Password: "password"})
+		require.ErrorIs(t, err, common.ErrorLoginAlreadyExists)
+	})
+
+	t.Run(name+"UpdateUserPassword", func(t *testing.T) {
+		err := repo.UpdateUserPassword(ctx, user2.ID, "password2new")
+		require.NoError(t, err)
+
+		user, err := repo.FindUserByLogin(ctx, "user2")
+		require.NoError(t, err)
+		require.Equal(t, "password2new", user.Password)
+
+		err = repo.UpdateUserPassword(ctx, uuid.NewString(), "password")
+		require.ErrorIs(t, err, common.ErrorNotFound)
+	})
+
 	t.Run(name+"FindNonExistingUser", func(t *testing.T) {
 		// find non-existing user, should be an error
 		_, err = repo.FindUserByLogin(ctx, "userunknown")
@@ -143,87 +219,502 @@ func RunRepositoryTests(t *testing.T, ctx context.Context, name string, repo Rep
 	})
 
 	t.Run(name+"AddWithdrawalsToUser1", func(t *testing.T) {
-		w := &models.Withdrawal{UserID: user1.ID, Amount: 1}
+		w := &models.Withdrawal{UserID: user1.ID, Amount: models.NewMoney(1), Order: "2377225624"}
 		err = repo.AddWithdrawal(ctx, w)
 		require.NoError(t, err)
 
-		w = &models.Withdrawal{UserID: user1.ID, Amount: 2.50}
+		w = &models.Withdrawal{UserID: user1.ID, Amount: models.Money(250), Order: "4561261212345467"}
 		err = repo.AddWithdrawal(ctx, w)
 		require.NoError(t, err)
 	})
 
 	t.Run(name+"AddWithdrawalsToUser2", func(t *testing.T) {
-		w := &models.Withdrawal{UserID: user2.ID, Amount: 4.50}
+		w := &models.Withdrawal{UserID: user2.ID, Amount: models.Money(450), Order: "79927398713"}
+		err = repo.AddWithdrawal(ctx, w)
+		require.NoError(t, err)
+	})
+
+	t.Run(name+"AddWithdrawalForSameOrder", func(t *testing.T) {
+		w := &models.Withdrawal{UserID: user2.ID, Amount: models.Money(450), Order: "2377225624"}
+		err = repo.AddWithdrawal(ctx, w)
+		require.ErrorIs(t, err, common.ErrorAlreadyExists)
+	})
+
+	t.Run(name+"FindWithdrawalByOrder", func(t *testing.T) {
+		w, err := repo.FindWithdrawalByOrder(ctx, "2377225624")
+		require.NoError(t, err)
+		assert.Equal(t, w.UserID, user1.ID)
+		assert.Equal(t, w.Amount, models.NewMoney(1))
+
+		_, err = repo.FindWithdrawalByOrder(ctx, "12345678903")
+		require.ErrorIs(t, err, common.ErrorNotFound)
+	})
+
+	t.Run(name+"MarkWithdrawalReversed", func(t *testing.T) {
+		user, err := repo.AddUser(ctx, &models.User{Login: "user6", Password: "password6"})
+		require.NoError(t, err)
+
+		w := &models.Withdrawal{UserID: user.ID, Amount: models.NewMoney(1), Order: "12345674"}
 		err = repo.AddWithdrawal(ctx, w)
 		require.NoError(t, err)
+
+		reversedAt := time.Now().UTC().Truncate(time.Second)
+		err = repo.MarkWithdrawalReversed(ctx, w.ID, reversedAt, user1.ID)
+		require.NoError(t, err)
+
+		found, err := repo.FindWithdrawalByOrder(ctx, "12345674")
+		require.NoError(t, err)
+		require.True(t, found.ReversedAt.Equal(reversedAt))
+		assert.Equal(t, user1.ID, found.ReversedBy)
+
+		// already reversed
+		err = repo.MarkWithdrawalReversed(ctx, w.ID, reversedAt, user1.ID)
+		require.ErrorIs(t, err, common.ErrorNotFound)
 	})
 
 	t.Run(name+"GetWithdrawalsByUserID1", func(t *testing.T) {
-		res, err := repo.GetWithdrawalsByUserID(ctx, user1.ID)
+		res, err := repo.GetWithdrawalsByUserID(ctx, user1.ID, models.ListFilter{})
 		require.NoError(t, err)
 		assert.Equal(t, len(res), 2)
 	})
 
 	t.Run(name+"GetWithdrawalsByUserID2", func(t *testing.T) {
-		res, err := repo.GetWithdrawalsByUserID(ctx, user2.ID)
+		res, err := repo.GetWithdrawalsByUserID(ctx, user2.ID, models.ListFilter{})
 		require.NoError(t, err)
 		assert.Equal(t, len(res), 1)
 	})
 
-	t.Run(name+"GetUnprocessedOrdersTry1", func(t *testing.T) {
-		res, err := repo.GetUnprocessedOrders(ctx)
+	t.Run(name+"CountUnprocessedOrdersTry1", func(t *testing.T) {
+		count, err := repo.CountUnprocessedOrders(ctx, time.Now())
 		require.NoError(t, err)
-		assert.Equal(t, len(res), 3)
+		assert.Equal(t, count, 3)
 	})
 
-	t.Run(name+"GetUnprocessedOrdersAfterAccrual", func(t *testing.T) {
-		err := repo.UpdateOrderAccrualStatus(ctx, user1order1.ID, models.OrderStatusProcessed, 5.0)
+	t.Run(name+"CountUnprocessedOrdersAfterAccrual", func(t *testing.T) {
+		// orders can only be updated by the owner of the lease
+		err := repo.UpdateOrderAccrualStatus(ctx, user1order1.ID, "a", models.OrderStatusProcessed, models.NewMoney(5))
+		require.ErrorIs(t, err, common.ErrorOrderLeaseLost)
+
+		leased, err := repo.LeaseUnprocessedOrders(ctx, "a", time.Now(), time.Minute, 10)
 		require.NoError(t, err)
+		require.Len(t, leased, 3)
+
+		err = repo.UpdateOrderAccrualStatus(ctx, user1order1.ID, "b", models.OrderStatusProcessed, models.NewMoney(5))
+		require.ErrorIs(t, err, common.ErrorOrderLeaseLost)
 
-		res, err := repo.GetUnprocessedOrders(ctx)
+		err = repo.UpdateOrderAccrualStatus(ctx, user1order1.ID, "a", models.OrderStatusProcessed, models.NewMoney(5))
 		require.NoError(t, err)
-		assert.Equal(t, len(res), 2)
 
+		err = repo.UpdateOrderAccrualStatus(ctx, uuid.NewString(), "a", models.OrderStatusProcessed, models.NewMoney(5))
+		require.ErrorIs(t, err, common.ErrorNotFound)
+
+		count, err := repo.CountUnprocessedOrders(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, count, 2)
+
+		for _, o := range leased {
+			require.NoError(t, repo.ReleaseOrderLease(ctx, o.ID, "a"))
+		}
+	})
+
+	t.Run(name+"CountUnprocessedOrdersAfterScheduling", func(t *testing.T) {
+		order, err := repo.FindOrderByNumber(ctx, "374245455400126")
+		require.NoError(t, err)
+
+		err = repo.ScheduleOrderCheck(ctx, order.ID, "a", time.Now().Add(time.Hour), 1)
+		require.ErrorIs(t, err, common.ErrorOrderLeaseLost)
+
+		leased, err := repo.LeaseUnprocessedOrders(ctx, "a", time.Now(), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, leased, 2)
+
+		err = repo.ScheduleOrderCheck(ctx, order.ID, "a", time.Now().Add(time.Hour), 1)
+		require.NoError(t, err)
+
+		for _, o := range leased {
+			require.NoError(t, repo.ReleaseOrderLease(ctx, o.ID, "a"))
+		}
+
+		count, err := repo.CountUnprocessedOrders(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		count, err = repo.CountUnprocessedOrders(ctx, time.Now().Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run(name+"LeaseUnprocessedOrders", func(t *testing.T) {
+		now := time.Now().Add(2 * time.Hour)
+
+		leasedA, err := repo.LeaseUnprocessedOrders(ctx, "a", now, time.Minute, 1)
+		require.NoError(t, err)
+		require.Len(t, leasedA, 1)
+
+		leasedB, err := repo.LeaseUnprocessedOrders(ctx, "b", now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, leasedB, 1)
+		require.NotEqual(t, leasedA[0].ID, leasedB[0].ID)
+
+		// everything is leased
+		leasedC, err := repo.LeaseUnprocessedOrders(ctx, "c", now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Empty(t, leasedC)
+
+		// only the owner can release the lease
+		err = repo.ReleaseOrderLease(ctx, leasedA[0].ID, "c")
+		require.NoError(t, err)
+		leasedC, err = repo.LeaseUnprocessedOrders(ctx, "c", now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Empty(t, leasedC)
+
+		err = repo.ReleaseOrderLease(ctx, leasedA[0].ID, "a")
+		require.NoError(t, err)
+		leasedC, err = repo.LeaseUnprocessedOrders(ctx, "c", now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, leasedC, 1)
+		require.Equal(t, leasedA[0].ID, leasedC[0].ID)
+
+		// expired leases are taken over
+		leasedD, err := repo.LeaseUnprocessedOrders(ctx, "d", now.Add(2*time.Minute), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, leasedD, 2)
+
+		for _, o := range leasedD {
+			err = repo.ReleaseOrderLease(ctx, o.ID, "d")
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run(name+"AddLedgerEntries", func(t *testing.T) {
+		entry := &models.LedgerEntry{UserID: user1.ID, Type: models.LedgerEntryAccrual, Amount: models.NewMoney(5), OrderID: user1order1.ID}
+		err := repo.AddLedgerEntry(ctx, entry)
+		require.NoError(t, err)
+		require.NotEmpty(t, entry.ID)
+		assert.Equal(t, entry.Balance, models.NewMoney(5))
+
+		withdrawals, err := repo.GetWithdrawalsByUserID(ctx, user1.ID, models.ListFilter{})
+		require.NoError(t, err)
+		require.Len(t, withdrawals, 2)
+
+		for _, w := range withdrawals {
+			entry := &models.LedgerEntry{UserID: user1.ID, Type: models.LedgerEntryWithdrawal, Amount: -w.Amount, WithdrawalID: w.ID}
+			err := repo.AddLedgerEntry(ctx, entry)
+			require.NoError(t, err)
+		}
 	})
 
-	t.Run(name+"GetWithdrawalsTotalAmountByUserID2", func(t *testing.T) {
-		res, err := repo.GetWithdrawalsTotalAmountByUserID(ctx, user2.ID)
+	t.Run(name+"AddLedgerEntryTwiceForOrder", func(t *testing.T) {
+		entry := &models.LedgerEntry{UserID: user1.ID, Type: models.LedgerEntryAccrual, Amount: models.NewMoney(5), OrderID: user1order1.ID}
+		err := repo.AddLedgerEntry(ctx, entry)
+		require.ErrorIs(t, err, common.ErrorAlreadyExists)
+	})
+
+	t.Run(name+"GetLedgerEntriesByUserID1", func(t *testing.T) {
+		res, err := repo.GetLedgerEntriesByUserID(ctx, user1.ID)
 		require.NoError(t, err)
-		assert.Equal(t, res, float32(4.50))
+		require.Len(t, res, 3)
+
+		// running balance is kept in every entry
+		var balance models.Money
+		for _, e := range res {
+			balance += e.Amount
+			assert.Equal(t, e.Balance, balance)
+		}
+		assert.Equal(t, res[0].OrderID, user1order1.ID)
 	})
 
-	t.Run(name+"GetWithdrawalsTotalAmountByUserID1", func(t *testing.T) {
-		res, err := repo.GetWithdrawalsTotalAmountByUserID(ctx, user1.ID)
+	t.Run(name+"GetLedgerAccountBalance", func(t *testing.T) {
+		// every entry is balanced in the system account, the rejected one included nowhere
+		accruals, err := repo.GetLedgerAccountBalance(ctx, models.LedgerAccountAccruals)
+		require.NoError(t, err)
+		assert.Equal(t, -models.NewMoney(5), accruals)
+
+		withdrawals, err := repo.GetLedgerAccountBalance(ctx, models.LedgerAccountWithdrawals)
+		require.NoError(t, err)
+		assert.Equal(t, models.Money(350), withdrawals)
+
+		adjustments, err := repo.GetLedgerAccountBalance(ctx, models.LedgerAccountAdjustments)
+		require.NoError(t, err)
+		assert.Equal(t, models.Money(0), adjustments)
+	})
+
+	t.Run(name+"GetUserBalance1", func(t *testing.T) {
+		res, err := repo.GetUserBalance(ctx, user1.ID)
+		require.NoError(t, err)
+		assert.Equal(t, res, models.Balance{Current: models.Money(150), Withdrawn: models.Money(350)})
+	})
+
+	t.Run(name+"GetUserBalance2", func(t *testing.T) {
+		res, err := repo.GetUserBalance(ctx, user2.ID)
+		require.NoError(t, err)
+		assert.Equal(t, res, models.Balance{})
+	})
+
+	t.Run(name+"ConcurrentWithdrawalsWithUserLock", func(t *testing.T) {
+		user, err := repo.AddUser(ctx, &models.User{Login: "user3", Password: "password3"})
+		require.NoError(t, err)
+
+		err = repo.AddLedgerEntry(ctx, &models.LedgerEntry{UserID: user.ID, Type: models.LedgerEntryAdjustment, Amount: models.NewMoney(10)})
+		require.NoError(t, err)
+
+		withdraw := func(order string) (err error) {
+			ctx, tx, err := repo.UnitOfWork().Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err != nil {
+					tx.Rollback()
+				} else {
+					err = tx.Commit()
+				}
+			}()
+
+			_, err = repo.FindUserByIDForUpdate(ctx, user.ID)
+			if err != nil {
+				return err
+			}
+			b, err := repo.GetUserBalance(ctx, user.ID)
+			if err != nil {
+				return err
+			}
+			if b.Current < models.NewMoney(1) {
+				return nil
+			}
+			// widening the window between the check and the write
+			time.Sleep(time.Millisecond)
+			w := &models.Withdrawal{UserID: user.ID, Amount: models.NewMoney(1), Order: order}
+			err = repo.AddWithdrawal(ctx, w)
+			if err != nil {
+				return err
+			}
+			return repo.AddLedgerEntry(ctx, &models.LedgerEntry{UserID: user.ID, Type: models.LedgerEntryWithdrawal, Amount: -w.Amount, WithdrawalID: w.ID})
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				require.NoError(t, withdraw(fmt.Sprintf("user3-%d", i)))
+			}()
+		}
+		wg.Wait()
+
+		withdrawals, err := repo.GetWithdrawalsByUserID(ctx, user.ID, models.ListFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, len(withdrawals), 10)
+
+		b, err := repo.GetUserBalance(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, b, models.Balance{Current: 0, Withdrawn: models.NewMoney(10)})
+	})
+
+	t.Run(name+"IdempotencyKeys", func(t *testing.T) {
+		key := &models.IdempotencyKey{UserID: user1.ID, Key: "key1", RequestHash: "hash1"}
+		err := repo.AddIdempotencyKey(ctx, key)
+		require.NoError(t, err)
+
+		// keys are unique per user
+		err = repo.AddIdempotencyKey(ctx, &models.IdempotencyKey{UserID: user1.ID, Key: "key1", RequestHash: "hash2"})
+		require.ErrorIs(t, err, common.ErrorAlreadyExists)
+		err = repo.AddIdempotencyKey(ctx, &models.IdempotencyKey{UserID: user2.ID, Key: "key1", RequestHash: "hash2"})
+		require.NoError(t, err)
+
+		k, err := repo.FindIdempotencyKey(ctx, user1.ID, "key1")
+		require.NoError(t, err)
+		assert.Equal(t, k.RequestHash, "hash1")
+		assert.Equal(t, k.StatusCode, 0)
+
+		headers := map[string][]string{"Content-Type": {"application/json"}}
+		err = repo.CompleteIdempotencyKey(ctx, user1.ID, "key1", 200, headers, []byte("ok"))
+		require.NoError(t, err)
+
+		k, err = repo.FindIdempotencyKey(ctx, user1.ID, "key1")
+		require.NoError(t, err)
+		assert.Equal(t, k.StatusCode, 200)
+		assert.Equal(t, k.Headers, headers)
+		assert.Equal(t, k.Body, []byte("ok"))
+
+		err = repo.DeleteIdempotencyKey(ctx, user1.ID, "key1")
+		require.NoError(t, err)
+
+		_, err = repo.FindIdempotencyKey(ctx, user1.ID, "key1")
+		require.ErrorIs(t, err, common.ErrorNotFound)
+
+		// only the keys created before the time are purged
+		n, err := repo.DeleteIdempotencyKeysBefore(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, n, 0)
+
+		n, err = repo.DeleteIdempotencyKeysBefore(ctx, time.Now().Add(time.Hour))
 		require.NoError(t, err)
-		assert.Equal(t, res, float32(3.50))
+		assert.Equal(t, n, 1)
+
+		_, err = repo.FindIdempotencyKey(ctx, user2.ID, "key1")
+		require.ErrorIs(t, err, common.ErrorNotFound)
 	})
 
-	t.Run(name+"GetAccrualsTotalAmountByUserID2", func(t *testing.T) {
-		res, err := repo.GetAccrualsTotalAmountByUserID(ctx, user2.ID)
+	t.Run(name+"GetOrdersByUserIDPaged", func(t *testing.T) {
+		user, err := repo.AddUser(ctx, &models.User{Login: "user4", Password: "password4"})
 		require.NoError(t, err)
-		assert.Equal(t, res, float32(0))
+
+		// orders uploaded at the same time are ordered by id
+		uploadedAt := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 5; i++ {
+			_, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: fmt.Sprintf("user4-%d", i),
+				Status: models.OrderStatusNew, UploadedAt: uploadedAt.Add(time.Duration(i/2) * time.Hour)})
+			require.NoError(t, err)
+		}
+
+		for _, ascending := range []bool{false, true} {
+			all, err := repo.GetOrdersByUserID(ctx, user.ID, models.OrderListFilter{ListFilter: models.ListFilter{Ascending: ascending}})
+			require.NoError(t, err)
+			require.Len(t, all, 5)
+
+			var paged []models.Order
+			filter := models.OrderListFilter{ListFilter: models.ListFilter{Ascending: ascending, Limit: 2}}
+			for {
+				page, err := repo.GetOrdersByUserID(ctx, user.ID, filter)
+				require.NoError(t, err)
+				if len(page) == 0 {
+					break
+				}
+				paged = append(paged, page...)
+				last := page[len(page)-1]
+				filter.After = &models.Cursor{UploadedAt: last.UploadedAt, ID: last.ID}
+			}
+
+			require.Len(t, paged, 5)
+			for i := range all {
+				assert.Equal(t, paged[i].ID, all[i].ID)
+			}
+		}
+
+		// postgres sets uploaded_at itself, so the range is taken from the stored orders
+		all, err := repo.GetOrdersByUserID(ctx, user.ID, models.OrderListFilter{})
+		require.NoError(t, err)
+		res, err := repo.GetOrdersByUserID(ctx, user.ID, models.OrderListFilter{
+			ListFilter: models.ListFilter{From: all[3].UploadedAt, To: all[1].UploadedAt}})
+		require.NoError(t, err)
+		assert.Equal(t, len(res), 2)
+
+		res, err = repo.GetOrdersByUserID(ctx, user.ID, models.OrderListFilter{Statuses: []models.OrderStatus{models.OrderStatusProcessed}})
+		require.NoError(t, err)
+		assert.Equal(t, len(res), 0)
 	})
 
-	t.Run(name+"GetAccrualsTotalAmountByUserID1", func(t *testing.T) {
-		res, err := repo.GetAccrualsTotalAmountByUserID(ctx, user1.ID)
+	t.Run(name+"RefreshTokens", func(t *testing.T) {
+		token := &models.RefreshToken{UserID: user1.ID, FamilyID: uuid.NewString(), TokenHash: "hash1", ExpiresAt: time.Now().Add(time.Hour)}
+		err := repo.AddRefreshToken(ctx, token)
+		require.NoError(t, err)
+		require.NotEmpty(t, token.ID)
+
+		next := &models.RefreshToken{UserID: user1.ID, FamilyID: token.FamilyID, TokenHash: "hash2", ExpiresAt: time.Now().Add(time.Hour)}
+		err = repo.AddRefreshToken(ctx, next)
+		require.NoError(t, err)
+
+		other := &models.RefreshToken{UserID: user1.ID, FamilyID: uuid.NewString(), TokenHash: "hash3", ExpiresAt: time.Now().Add(time.Hour)}
+		err = repo.AddRefreshToken(ctx, other)
 		require.NoError(t, err)
-		assert.Equal(t, res, float32(5))
+
+		err = repo.AddRefreshToken(ctx, &models.RefreshToken{UserID: user1.ID, FamilyID: token.FamilyID, TokenHash: "hash1", ExpiresAt: time.Now()})
+		require.ErrorIs(t, err, common.ErrorAlreadyExists)
+
+		found, err := repo.FindRefreshTokenByHash(ctx, "hash1")
+		require.NoError(t, err)
+		assert.Equal(t, found.ID, token.ID)
+		assert.Equal(t, found.UsedAt.IsZero(), true)
+
+		err = repo.MarkRefreshTokenUsed(ctx, token.ID, time.Now())
+		require.NoError(t, err)
+
+		found, err = repo.FindRefreshTokenByHash(ctx, "hash1")
+		require.NoError(t, err)
+		assert.Equal(t, found.UsedAt.IsZero(), false)
+
+		err = repo.RevokeRefreshTokenFamily(ctx, token.FamilyID, time.Now())
+		require.NoError(t, err)
+
+		for hash, revoked := range map[string]bool{"hash1": true, "hash2": true, "hash3": false} {
+			found, err := repo.FindRefreshTokenByHash(ctx, hash)
+			require.NoError(t, err)
+			assert.Equal(t, !found.RevokedAt.IsZero(), revoked)
+		}
+
+		_, err = repo.FindRefreshTokenByHash(ctx, "unknown")
+		require.ErrorIs(t, err, common.ErrorNotFound)
 	})
 
-	t.Run(name+"CheckUserBalanceAfterRecalculation", func(t *testing.T) {
-		err := repo.UpdateUserAccruedTotal(ctx, user1.ID, 5)
+	t.Run(name+"LoginThrottles", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+
+		_, err := repo.FindLoginThrottle(ctx, "login:user1")
+		require.ErrorIs(t, err, common.ErrorNotFound)
+
+		for i := 1; i <= 3; i++ {
+			throttle, err := repo.RecordLoginFailure(ctx, "login:user1", now.Add(time.Duration(i)*time.Second), now.Add(-time.Minute))
+			require.NoError(t, err)
+			assert.Equal(t, throttle.Failures, i)
+		}
+
+		throttle, err := repo.FindLoginThrottle(ctx, "login:user1")
 		require.NoError(t, err)
+		assert.Equal(t, throttle.Failures, 3)
+		assert.Equal(t, throttle.LastFailureAt.Equal(now.Add(3*time.Second)), true)
 
-		err = repo.UpdateUserWithdrawnTotal(ctx, user1.ID, 3.5)
+		// failures older than the reset moment are forgotten
+		throttle, err = repo.RecordLoginFailure(ctx, "login:user1", now.Add(time.Hour), now.Add(time.Hour-time.Minute))
 		require.NoError(t, err)
+		assert.Equal(t, throttle.Failures, 1)
 
-		user, err := repo.FindUserByID(ctx, user1.ID)
+		err = repo.DeleteLoginThrottle(ctx, "login:user1")
+		require.NoError(t, err)
+		_, err = repo.FindLoginThrottle(ctx, "login:user1")
+		require.ErrorIs(t, err, common.ErrorNotFound)
+
+		attempt := &models.LoginAttempt{Login: "user1", IP: "10.0.0.1", Result: models.LoginAttemptFailure}
+		err = repo.AddLoginAttempt(ctx, attempt)
+		require.NoError(t, err)
+		require.NotEmpty(t, attempt.ID)
+	})
+
+	t.Run(name+"Adjustments", func(t *testing.T) {
+		user, err := repo.AddUser(ctx, &models.User{Login: "user5", Password: "password5"})
 		require.NoError(t, err)
-		assert.Equal(t, user.ID, user1.ID)
 
-		assert.Equal(t, user.AccruedTotal, float32(5))
-		assert.Equal(t, user.WithdrawnTotal, float32(3.5))
+		for _, amount := range []models.Money{models.NewMoney(10), models.NewMoney(-3)} {
+			adjustment := &models.Adjustment{UserID: user.ID, Amount: amount, Reason: models.AdjustmentReasonCorrection,
+				Comment: "comment", OperatorID: user1.ID}
+			err := repo.AddAdjustment(ctx, adjustment)
+			require.NoError(t, err)
+			require.NotEmpty(t, adjustment.ID)
+
+			entry := &models.LedgerEntry{UserID: user.ID, Type: models.LedgerEntryAdjustment, Amount: amount, AdjustmentID: adjustment.ID}
+			err = repo.AddLedgerEntry(ctx, entry)
+			require.NoError(t, err)
+
+			// every adjustment is applied only once
+			err = repo.AddLedgerEntry(ctx, &models.LedgerEntry{UserID: user.ID, Type: models.LedgerEntryAdjustment, Amount: amount,
+				AdjustmentID: adjustment.ID})
+			require.ErrorIs(t, err, common.ErrorAlreadyExists)
+		}
+
+		adjustments, err := repo.GetAdjustmentsByUserID(ctx, user.ID)
+		require.NoError(t, err)
+		require.Len(t, adjustments, 2)
+		assert.Equal(t, models.NewMoney(-3), adjustments[0].Amount)
+		assert.Equal(t, user1.ID, adjustments[0].OperatorID)
 
+		entries, err := repo.GetLedgerEntriesByUserID(ctx, user.ID)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, adjustments[1].ID, entries[0].AdjustmentID)
+
+		balance, err := repo.GetUserBalance(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.Balance{Current: models.NewMoney(7)}, balance)
 	})
 
 }
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/server/middleware"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+)
+
+type AdminHandler struct {
+	service        *service.AdminService
+	orderService   *service.OrderService
+	balanceService *service.BalanceService
+	logger         *slog.Logger
+}
+
+func NewAdminHandler(s *service.AdminService, o *service.OrderService, b *service.BalanceService, l *slog.Logger) *AdminHandler {
+	return &AdminHandler{service: s, orderService: o, balanceService: b, logger: l}
+}
+
+// #### **Поиск пользователя по логину**
+// Хендлер: `GET /api/admin/users?login=<login>`.
+// Хендлер доступен только пользователю с ролью `admin`. Логин ищется без учёта регистра.
+// Формат запроса:
+// ```
+// GET /api/admin/users?login=<login> HTTP/1.1
+// Content-Length: 0
+// ```
+// Возможные коды ответа:
+// - `200` — пользователь найден.
+//   Формат ответа:
+//     ```
+//     200 OK HTTP/1.1
+//     Content-Type: application/json
+//     ...
+//     {
+//     	"id": "2a4b4d0e-4b9c-4f5e-9f0a-5d3c2b1a0f9e",
+//     	"login": "<login>",
+//     	"role": "user",
+//     	"balance": {
+//     		"current": 500.5,
+//     		"withdrawn": 42
+//     	}
+//     }
+//     ```
+// - `400` — не указан логин;
+// - `401` — пользователь не авторизован;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — пользователь не найден;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) FindUser(w http.ResponseWriter, r *http.Request) {
+
+	login := r.URL.Query().Get("login")
+	if login == "" {
+		http.Error(w, "no login specified", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.service.FindUserByLogin(r.Context(), login)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, user)
+}
+
+// #### **Получение пользователя**
+// Хендлер: `GET /api/admin/users/{userID}`.
+// Хендлер доступен только пользователю с ролью `admin`. Ответ такой же, как при поиске пользователя по логину.
+// Возможные коды ответа:
+// - `200` — пользователь найден;
+// - `401` — пользователь не авторизован;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — пользователь не найден;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+
+	user, err := h.service.GetUser(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, user)
+}
+
+// #### **Получение списка заказов пользователя**
+// Хендлер: `GET /api/admin/users/{userID}/orders`.
+// Хендлер доступен только пользователю с ролью `admin`. Параметры запроса и формат ответа такие же,
+// как у `GET /api/user/orders`.
+// Возможные коды ответа:
+// - `200` — успешная обработка запроса;
+// - `204` — нет данных для ответа;
+// - `400` — неверные параметры запроса;
+// - `401` — пользователь не авторизован;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — пользователь не найден;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	user, err := h.service.GetUser(ctx, chi.URLParam(r, "userID"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	filter, err := parseOrderListFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, next, err := h.orderService.GetOrderList(ctx, user.ID, filter)
+	if err != nil {
+		logging.FromContext(ctx, h.logger).Error(err.Error())
+		http.Error(w, InternalError, http.StatusInternalServerError)
+		return
+	}
+
+	if len(orders) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	setNextCursor(w, next)
+	writeJSON(w, newOrderDTOs(orders))
+}
+
+// #### **Получение списка списаний пользователя**
+// Хендлер: `GET /api/admin/users/{userID}/withdrawals`.
+// Хендлер доступен только пользователю с ролью `admin`. Параметры запроса и формат ответа такие же,
+// как у `GET /api/user/withdrawals`.
+// Возможные коды ответа:
+// - `200` — успешная обработка запроса;
+// - `204` — нет ни одного списания;
+// - `400` — неверные параметры запроса;
+// - `401` — пользователь не авторизован;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — пользователь не найден;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) GetUserWithdrawals(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	user, err := h.service.GetUser(ctx, chi.URLParam(r, "userID"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, next, err := h.balanceService.GetWithdrawals(ctx, user.ID, filter)
+	if err != nil {
+		logging.FromContext(ctx, h.logger).Error(err.Error())
+		http.Error(w, InternalError, http.StatusInternalServerError)
+		return
+	}
+
+	if len(result) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	setNextCursor(w, next)
+	writeJSON(w, result)
+}
+
+// #### **Изменение роли пользователя**
+// Хендлер: `PUT /api/admin/users/{userID}/role`.
+// Хендлер доступен только пользователю с ролью `admin`. Уже выданные access token сохраняют прежнюю роль
+// до истечения срока действия, новая роль попадает в токен при следующем обновлении.
+// Формат запроса:
+// ```
+// PUT /api/admin/users/{userID}/role HTTP/1.1
+// Content-Type: application/json
+// ...
+// {
+// 	"role": "admin"
+// }
+// ```
+// Здесь `role` — `user` или `admin`.
+// Возможные коды ответа:
+// - `204` — роль изменена;
+// - `400` — неверный формат запроса или неизвестная роль;
+// - `401` — пользователь не авторизован;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — пользователь не найден;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+
+	var req models.UserRoleRequestDTO
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	validate := validator.New()
+	err = validate.StructCtx(ctx, req)
+	if err != nil {
+		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.SetUserRole(ctx, chi.URLParam(r, "userID"), req.Role)
+	if err != nil {
+		if errors.Is(err, common.ErrorUnknownRole) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			h.writeError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// #### **Корректировка баланса пользователя**
+// Хендлер: `POST /api/admin/users/{userID}/adjustments`.
+// Хендлер доступен только пользователю с ролью `admin`. Баллы начисляются (положительная сумма) или списываются
+// (отрицательная сумма) вручную, корректировка сохраняется с причиной и идентификатором сотрудника.
+// Баланс не может стать отрицательным. Повторный запрос с тем же заголовком `Idempotency-Key` возвращает
+// сохранённый ответ на исходный запрос.
+// Формат запроса:
+// ```
+// POST /api/admin/users/{userID}/adjustments HTTP/1.1
+// Content-Type: application/json
+// ...
+// {
+// 	"amount": -100,
+// 	"reason": "FRAUD_REVERSAL",
+// 	"comment": "<comment>"
+// }
+// ```
+// Здесь `reason` — `GOODWILL`, `FRAUD_REVERSAL` или `CORRECTION`, `comment` — необязательный комментарий.
+// Возможные коды ответа:
+// - `201` — корректировка проведена, в ответе возвращается корректировка.
+//   Формат ответа:
+//     ```
+//     201 Created HTTP/1.1
+//     Content-Type: application/json
+//     ...
+//     {
+//     	"id": "7c4c3d6e-7b8c-4d1e-8f2a-1b2c3d4e5f60",
+//     	"amount": -100,
+//     	"reason": "FRAUD_REVERSAL",
+//     	"comment": "<comment>",
+//     	"operator_id": "2a4b4d0e-4b9c-4f5e-9f0a-5d3c2b1a0f9e",
+//     	"created_at": "2020-12-10T15:15:45+03:00"
+//     }
+//     ```
+// - `400` — неверный формат запроса, нулевая сумма или неизвестная причина;
+// - `401` — пользователь не авторизован;
+// - `402` — на счету недостаточно средств для списания;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — пользователь не найден;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) AdjustBalance(w http.ResponseWriter, r *http.Request) {
+
+	var req models.AdjustmentRequestDTO
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	validate := validator.New()
+	err = validate.StructCtx(ctx, req)
+	if err != nil {
+		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// trying to get userid from context
+	a := ctx.Value(middleware.UserIDKey)
+	operatorID, ok := a.(string)
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	adjustment, err := h.service.AdjustBalance(ctx, operatorID, chi.URLParam(r, "userID"), &req)
+	if err != nil {
+		if errors.Is(err, common.ErrorUnknownAdjustmentReason) || errors.Is(err, common.ErrorZeroAdjustment) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if errors.Is(err, common.ErrorInsufficientBalance) {
+			http.Error(w, err.Error(), http.StatusPaymentRequired)
+		} else {
+			h.writeError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(adjustment); err != nil {
+		logging.FromContext(ctx, h.logger).Error("Error writing response", "err", err.Error())
+	}
+}
+
+// #### **Получение списка корректировок баланса пользователя**
+// Хендлер: `GET /api/admin/users/{userID}/adjustments`.
+// Хендлер доступен только пользователю с ролью `admin`. Корректировки отсортированы от самых новых к самым старым,
+// формат элемента такой же, как в ответе на корректировку.
+// Возможные коды ответа:
+// - `200` — успешная обработка запроса;
+// - `204` — нет ни одной корректировки;
+// - `401` — пользователь не авторизован;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — пользователь не найден;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) GetAdjustments(w http.ResponseWriter, r *http.Request) {
+
+	adjustments, err := h.service.GetAdjustments(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if len(adjustments) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, adjustments)
+}
+
+// #### **Отмена списания**
+// Хендлер: `POST /api/admin/withdrawals/{order}/reversal`.
+// Хендлер доступен только пользователю с ролью `admin`. Используется при отмене заказа, оплаченного баллами:
+// списанные баллы возвращаются на баланс пользователя, списание остаётся в истории с отметкой об отмене.
+// Повторная отмена того же списания не возвращает баллы повторно.
+// Формат запроса:
+// ```
+// POST /api/admin/withdrawals/2377225624/reversal HTTP/1.1
+// Content-Length: 0
+// ```
+// Возможные коды ответа:
+// - `200` — списание отменено, в ответе возвращается списание.
+//   Формат ответа:
+//     ```
+//     200 OK HTTP/1.1
+//     Content-Type: application/json
+//     ...
+//     {
+//     	"order": "2377225624",
+//     	"sum": 500,
+//     	"processed_at": "2020-12-09T16:09:57+03:00",
+//     	"reversed_at": "2020-12-10T15:15:45+03:00"
+//     }
+//     ```
+// - `401` — пользователь не авторизован;
+// - `403` — у пользователя нет роли `admin`;
+// - `404` — списание по номеру заказа не найдено;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AdminHandler) ReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	// trying to get userid from context
+	a := ctx.Value(middleware.UserIDKey)
+	operatorID, ok := a.(string)
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	withdrawal, err := h.balanceService.ReverseWithdrawal(ctx, operatorID, chi.URLParam(r, "order"))
+	if err != nil {
+		if errors.Is(err, common.ErrorNotFound) {
+			http.Error(w, "withdrawal not found", http.StatusNotFound)
+		} else {
+			logging.FromContext(ctx, h.logger).Error(err.Error())
+			http.Error(w, InternalError, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, withdrawal)
+}
+
+// not found users are reported as such, other errors are only logged, so that their details are not exposed
+func (h *AdminHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, common.ErrorNotFound) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	logging.FromContext(r.Context(), h.logger).Error(err.Error())
+	http.Error(w, InternalError, http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
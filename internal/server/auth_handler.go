@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/server/middleware"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
 	"github.com/go-playground/validator/v10"
 )
@@ -22,7 +26,10 @@ func NewAuthHandler(s *service.AuthService) *AuthHandler {
 
 // #### **Регистрация пользователя**
 // Хендлер: `POST /api/user/register`.
-// Регистрация производится по паре логин/пароль. Каждый логин должен быть уникальным.
+// Регистрация производится по паре логин/пароль. Каждый логин должен быть уникальным без учёта регистра.
+// Логин и пароль проверяются на соответствие политике: длина логина и допустимые символы (латинские буквы,
+// цифры и `. _ @ -`), минимальная длина пароля, число классов символов, отсутствие в списке распространённых
+// паролей, пароль не должен содержать логин.
 // После успешной регистрации должна происходить автоматическая аутентификация пользователя.
 // Формат запроса:
 // ```
@@ -34,11 +41,30 @@ func NewAuthHandler(s *service.AuthService) *AuthHandler {
 // 	"password": "<password>"
 // }
 // ```
+// В ответе access token передаётся в заголовке `Authorization`, в теле возвращается пара токенов:
+// ```
+// {
+// 	"access_token": "<access token>",
+// 	"token_type": "Bearer",
+// 	"expires_in": 300,
+// 	"refresh_token": "<refresh token>"
+// }
+// ```
 // Возможные коды ответа:
 // - `200` — пользователь успешно зарегистрирован и аутентифицирован;
-// - `400` — неверный формат запроса;
+// - `400` — неверный формат запроса, логин или пароль не соответствуют политике;
 // - `409` — логин уже занят;
 // - `500` — внутренняя ошибка сервера.
+//
+// Если логин или пароль не соответствуют политике, в теле ответа `400` перечисляются все нарушенные правила:
+// ```
+// {
+// 	"error": "validation error",
+// 	"errors": [
+// 		{"field": "password", "rule": "min_length", "message": "password should be at least 8 characters long"}
+// 	]
+// }
+// ```
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
@@ -52,16 +78,14 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	validate := validator.New()
-	err = validate.StructCtx(ctx, req)
-	if err != nil {
-		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
-		return
-	}
-
-	token, err := h.service.Register(ctx, req.Login, req.Password)
+	// empty fields are reported by the policy along with the other rules
+	tokens, err := h.service.Register(ctx, req.Login, req.Password)
 	if err != nil {
-		if errors.Is(err, common.ErrorLoginAlreadyExists) {
+		var validationErr *common.ValidationError
+		if errors.As(err, &validationErr) {
+			writeValidationError(w, validationErr)
+			return
+		} else if errors.Is(err, common.ErrorLoginAlreadyExists) {
 			http.Error(w, err.Error(), http.StatusConflict)
 			return
 		} else if errors.Is(err, common.ErrorInvalidPasswordFormat) || errors.Is(err, common.ErrorInvalidLoginFormat) {
@@ -73,8 +97,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	w.Write([]byte{})
+	writeTokens(w, tokens)
 }
 
 // #### **Аутентификация пользователя**
@@ -90,10 +113,15 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 // 	"password": "<password>"
 // }
 // ```
+// Ответ такой же, как при регистрации.
+// Неудачные попытки считаются по логину и по адресу клиента: после каждой неудачной попытки для логина
+// следующая разрешается через нарастающую паузу, после нескольких неудачных попыток подряд логин или адрес
+// блокируются на время. Все попытки записываются в журнал.
 // Возможные коды ответа:
 // - `200` — пользователь успешно аутентифицирован;
 // - `400` — неверный формат запроса;
 // - `401` — неверная пара логин/пароль;
+// - `429` — слишком много неудачных попыток, в заголовке `Retry-After` передаётся число секунд до следующей попытки;
 // - `500` — внутренняя ошибка сервера.
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -113,9 +141,12 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.service.Login(ctx, req.Login, req.Password)
+	tokens, err := h.service.Login(ctx, req.Login, req.Password, clientIP(r))
 	if err != nil {
-		if errors.Is(err, common.ErrorInvalidLoginPassword) {
+		var retryErr *common.RetryAfterError
+		if errors.As(err, &retryErr) {
+			writeRetryAfter(w, retryErr)
+		} else if errors.Is(err, common.ErrorInvalidLoginPassword) {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 		} else if errors.Is(err, common.ErrorNotFound) {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -125,7 +156,293 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	writeTokens(w, tokens)
+
+}
+
+// #### **Обновление токенов**
+// Хендлер: `POST /api/user/token/refresh`.
+// Refresh token обменивается на новую пару токенов и больше не действует. Повторное использование
+// refresh token отзывает все токены, выданные по цепочке от того же входа.
+// Формат запроса:
+// ```
+// POST /api/user/token/refresh HTTP/1.1
+// Content-Type: application/json
+// ...
+// {
+// 	"refresh_token": "<refresh token>"
+// }
+// ```
+// Ответ такой же, как при регистрации.
+// Возможные коды ответа:
+// - `200` — токены успешно обновлены;
+// - `400` — неверный формат запроса;
+// - `401` — refresh token недействителен;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshTokenRequestDTO
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	validate := validator.New()
+	err = validate.StructCtx(ctx, req)
+	if err != nil {
+		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.service.Refresh(ctx, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, common.ErrorInvalidToken) || errors.Is(err, common.ErrorRefreshTokenReused) {
+			http.Error(w, common.ErrorInvalidToken.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// #### **Выход**
+// Хендлер: `POST /api/user/logout`.
+// Отзывает refresh token и все токены, выданные по цепочке от того же входа.
+// Формат запроса:
+// ```
+// POST /api/user/logout HTTP/1.1
+// Content-Type: application/json
+// ...
+// {
+// 	"refresh_token": "<refresh token>"
+// }
+// ```
+// Возможные коды ответа:
+// - `200` — токены отозваны;
+// - `400` — неверный формат запроса;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshTokenRequestDTO
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	validate := validator.New()
+	err = validate.StructCtx(ctx, req)
+	if err != nil {
+		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.Logout(ctx, req.RefreshToken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Write([]byte{})
+}
+
+// #### **Смена пароля**
+// Хендлер: `POST /api/user/password`.
+// Хендлер доступен только аутентифицированным пользователям. Новый пароль проверяется на соответствие политике,
+// как при регистрации. Refresh token всех сессий пользователя отзываются, для текущей сессии выдаётся новая пара токенов.
+// Неверный текущий пароль считается неудачной попыткой входа.
+// Формат запроса:
+// ```
+// POST /api/user/password HTTP/1.1
+// Content-Type: application/json
+// ...
+// {
+// 	"current_password": "<current password>",
+// 	"new_password": "<new password>"
+// }
+// ```
+// Ответ такой же, как при регистрации.
+// Возможные коды ответа:
+// - `200` — пароль изменён;
+// - `400` — неверный формат запроса или новый пароль не соответствует политике;
+// - `401` — пользователь не аутентифицирован или текущий пароль неверен;
+// - `429` — слишком много неудачных попыток, в заголовке `Retry-After` передаётся число секунд до следующей попытки;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ChangePasswordRequestDTO
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	validate := validator.New()
+	err = validate.StructCtx(ctx, req)
+	if err != nil {
+		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// trying to get userid from context
+	a := ctx.Value(middleware.UserIDKey)
+	userID, ok := a.(string)
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
 
+	tokens, err := h.service.ChangePassword(ctx, userID, req.CurrentPassword, req.NewPassword, clientIP(r))
+	if err != nil {
+		var validationErr *common.ValidationError
+		var retryErr *common.RetryAfterError
+		if errors.As(err, &validationErr) {
+			writeValidationError(w, validationErr)
+		} else if errors.As(err, &retryErr) {
+			writeRetryAfter(w, retryErr)
+		} else if errors.Is(err, common.ErrorInvalidLoginPassword) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// #### **Запрос сброса пароля**
+// Хендлер: `POST /api/user/password/reset/request`.
+// Пользователю отправляется одноразовый токен сброса пароля с ограниченным сроком действия.
+// Ответ не зависит от того, существует ли логин.
+// Формат запроса:
+// ```
+// POST /api/user/password/reset/request HTTP/1.1
+// Content-Type: application/json
+// ...
+// {
+// 	"login": "<login>"
+// }
+// ```
+// Возможные коды ответа:
+// - `202` — запрос принят;
+// - `400` — неверный формат запроса;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordResetRequestDTO
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	validate := validator.New()
+	err = validate.StructCtx(ctx, req)
+	if err != nil {
+		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.RequestPasswordReset(ctx, req.Login)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// #### **Сброс пароля**
+// Хендлер: `POST /api/user/password/reset`.
+// Пароль устанавливается по токену сброса пароля, токен действует один раз. Новый пароль проверяется
+// на соответствие политике, как при регистрации. Refresh token всех сессий пользователя отзываются.
+// Формат запроса:
+// ```
+// POST /api/user/password/reset HTTP/1.1
+// Content-Type: application/json
+// ...
+// {
+// 	"token": "<reset token>",
+// 	"new_password": "<new password>"
+// }
+// ```
+// Возможные коды ответа:
+// - `204` — пароль изменён;
+// - `400` — неверный формат запроса, токен недействителен или новый пароль не соответствует политике;
+// - `500` — внутренняя ошибка сервера.
+
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordResetDTO
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	validate := validator.New()
+	err = validate.StructCtx(ctx, req)
+	if err != nil {
+		http.Error(w, common.ErrorValidation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.ResetPassword(ctx, req.Token, req.NewPassword)
+	if err != nil {
+		var validationErr *common.ValidationError
+		if errors.As(err, &validationErr) {
+			writeValidationError(w, validationErr)
+		} else if errors.Is(err, common.ErrorInvalidResetToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// address of the client, the server is expected to be behind a proxy that sets RemoteAddr properly
+// (e.g. with the RealIP middleware) if it is not exposed directly
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeRetryAfter(w http.ResponseWriter, err *common.RetryAfterError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+func writeValidationError(w http.ResponseWriter, err *common.ValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(models.ValidationErrorDTO{Error: common.ErrorValidation.Error(), Errors: err.Errors})
+}
+
+// access token goes to the Authorization header as the spec requires, both tokens go to the body
+func writeTokens(w http.ResponseWriter, tokens *models.TokensDTO) {
+	w.Header().Set("Authorization", fmt.Sprintf("%s %s", tokens.TokenType, tokens.AccessToken))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
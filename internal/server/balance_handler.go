@@ -84,11 +84,14 @@ func (h *BalanceHandler) UserBalance(w http.ResponseWriter, r *http.Request) {
 // }
 // ```
 // Здесь `order` — номер заказа, а `sum` — сумма баллов к списанию в счёт оплаты.
+// Повторный запрос с тем же заголовком `Idempotency-Key` возвращает сохранённый ответ на исходный запрос,
+// повторное списание по тому же номеру заказа на ту же сумму не производится.
 // Возможные коды ответа:
 // - `200` — успешная обработка запроса;
 // - `401` — пользователь не авторизован;
 // - `402` — на счету недостаточно средств;
-// - `422` — неверный номер заказа;
+// - `409` — по номеру заказа уже было другое списание или запрос с тем же ключом ещё обрабатывается;
+// - `422` — неверный номер заказа или ключ идемпотентности использован с другим запросом;
 // - `500` — внутренняя ошибка сервера.
 
 func (h *BalanceHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
@@ -127,6 +130,9 @@ func (h *BalanceHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 			if errors.Is(err, common.ErrorInvalidOrderNumberFormat) {
 				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 				return
+			} else if errors.Is(err, common.ErrorWithdrawalAlreadyExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
 			} else {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -147,6 +153,11 @@ func (h *BalanceHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 // GET /api/user/withdrawals HTTP/1.1
 // Content-Length: 0
 // ```
+// Необязательные параметры запроса:
+// - `limit` — размер страницы (от 1 до 1000), без него возвращаются все списания;
+// - `cursor` — значение заголовка `X-Next-Cursor` предыдущей страницы;
+// - `from`, `to` — начало (включительно) и конец периода списания в формате RFC3339;
+// - `sort` — `desc` (по умолчанию) или `asc`.
 // Возможные коды ответа:
 // - `200` — успешная обработка запроса.
 //   Формат ответа:
@@ -159,11 +170,14 @@ func (h *BalanceHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 //         {
 //             "order": "2377225624",
 //             "sum": 500,
-//             "processed_at": "2020-12-09T16:09:57+03:00"
+//             "processed_at": "2020-12-09T16:09:57+03:00",
+//             "reversed_at": "2020-12-10T15:15:45+03:00"
 //         }
 //     ]
 //     ```
+//   Поле `reversed_at` есть только у отменённых списаний, баллы по ним возвращены на баланс.
 // - `204` - нет ни одного списания.
+// - `400` — неверные параметры запроса.
 // - `401` — пользователь не авторизован.
 // - `500` — внутренняя ошибка сервера.
 
@@ -180,7 +194,13 @@ func (h *BalanceHandler) Withdrawals(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	result, err := h.service.GetWithdrawals(ctx, userID)
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, next, err := h.service.GetWithdrawals(ctx, userID, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -191,6 +211,7 @@ func (h *BalanceHandler) Withdrawals(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
+)
+
+type HealthHandler struct {
+	service *service.HealthService
+}
+
+func NewHealthHandler(s *service.HealthService) *HealthHandler {
+	return &HealthHandler{service: s}
+}
+
+func writeHealth(w http.ResponseWriter, status int, v *models.HealthDTO) {
+	// probes should always see the current state
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// #### **Проверка работоспособности процесса**
+// Хендлер: `GET /healthz`.
+// Отвечает, пока процесс жив, зависимости не проверяются.
+// Возможные коды ответа:
+// - `200` — процесс работает.
+//   Формат ответа:
+//     ```
+//     200 OK HTTP/1.1
+//     Content-Type: application/json
+//     ...
+//     {"status": "ok"}
+//     ```
+
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, &models.HealthDTO{Status: models.HealthStatusOK})
+}
+
+// #### **Проверка готовности принимать запросы**
+// Хендлер: `GET /readyz`.
+// Проверяет доступность базы данных, применение всех миграций и доступность системы расчёта баллов.
+// Результат проверки системы расчёта баллов кешируется на несколько секунд.
+// Во время остановки сервиса готовность сбрасывается до того, как сервер перестаёт принимать соединения.
+// Возможные коды ответа:
+// - `200` — сервис готов принимать запросы.
+//   Формат ответа:
+//     ```
+//     200 OK HTTP/1.1
+//     Content-Type: application/json
+//     ...
+//     {
+//     	"status": "ok",
+//     	"checks": {"database": "ok", "migrations": "ok", "accrual_system": "ok"}
+//     }
+//     ```
+// - `503` — хотя бы одна из проверок не прошла, в `checks` указана ошибка проверки.
+
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+
+	result := h.service.Ready(r.Context())
+
+	status := http.StatusOK
+	if result.Status != models.HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeHealth(w, status, result)
+}
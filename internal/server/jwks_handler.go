@@ -0,0 +1,26 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// #### **Публичные ключи подписи токенов**
+// Хендлер: `GET /.well-known/jwks.json`.
+// Возвращает публичные ключи в формате JWKS (RFC 7517), чтобы другие сервисы могли проверять токены.
+// Ключ подписи выбирается по заголовку токена `kid`. Если токены подписываются секретным ключом (HS256),
+// список ключей пуст.
+// Возможные коды ответа:
+// - `200` — успешная обработка запроса;
+// - `500` — внутренняя ошибка сервера.
+
+func (s *HTTPServer) JWKS(w http.ResponseWriter, r *http.Request) {
+
+	// keys are rotated by restarting with a new key set, so the response may be cached for a while
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.serviceProvider.Keys.JWKS()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
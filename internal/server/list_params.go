@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+)
+
+const (
+	// header with the cursor of the next page, absent on the last page
+	NextCursorHeader = "X-Next-Cursor"
+
+	maxPageSize = 1000
+)
+
+// parses the paging parameters of user history lists:
+// limit, cursor, from and to (RFC3339) and sort (asc or desc),
+// without parameters the whole history is returned newest first, as the spec requires
+func parseListFilter(query url.Values) (models.ListFilter, error) {
+
+	var filter models.ListFilter
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return filter, fmt.Errorf("limit should be between 1 and %d", maxPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := models.ParseCursor(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.After = cursor
+	}
+
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s should be in RFC3339 format", name)
+			}
+			*t = parsed
+		}
+	}
+
+	switch query.Get("sort") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, fmt.Errorf("sort should be asc or desc")
+	}
+
+	return filter, nil
+}
+
+// parses the list parameters of the order list, status may be repeated or comma-separated
+func parseOrderListFilter(query url.Values) (models.OrderListFilter, error) {
+
+	listFilter, err := parseListFilter(query)
+	if err != nil {
+		return models.OrderListFilter{}, err
+	}
+
+	filter := models.OrderListFilter{ListFilter: listFilter}
+
+	for _, v := range query["status"] {
+		for _, status := range strings.Split(v, ",") {
+			switch s := models.OrderStatus(strings.ToUpper(strings.TrimSpace(status))); s {
+			case models.OrderStatusNew, models.OrderStatusProcessing, models.OrderStatusInvalid, models.OrderStatusProcessed:
+				filter.Statuses = append(filter.Statuses, s)
+			default:
+				return filter, fmt.Errorf("unknown status %q", status)
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+func setNextCursor(w http.ResponseWriter, next *models.Cursor) {
+	if next != nil {
+		w.Header().Set(NextCursorHeader, next.String())
+	}
+}
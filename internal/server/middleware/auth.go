@@ -3,16 +3,19 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 )
 
 type contextKey string
 
 const (
 	UserIDKey contextKey = "userID"
+	RoleKey   contextKey = "role"
 )
 
 func ExtractAuthToken(header string) (string, error) {
@@ -26,7 +29,8 @@ func ExtractAuthToken(header string) (string, error) {
 	return parts[1], nil
 }
 
-func NewAuthMiddleware(secretKey string) func(http.Handler) http.Handler {
+// NewAuthMiddleware checks the bearer token signature, algorithm, issuer, audience and time claims
+func NewAuthMiddleware(keys *auth.KeySet, opts auth.TokenOptions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -42,19 +46,21 @@ func NewAuthMiddleware(secretKey string) func(http.Handler) http.Handler {
 				return
 			}
 
-			userID, err := auth.GetUserIDFromToken(token, secretKey)
+			claims, err := auth.ParseToken(token, keys, opts)
 
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
 
-			if userID == "" {
+			if claims.Subject == "" {
 				http.Error(w, common.ErrorNoUserID.Error(), http.StatusUnauthorized)
 				return
 			}
 
-			contextWithUser := context.WithValue(r.Context(), UserIDKey, userID)
+			contextWithUser := context.WithValue(r.Context(), UserIDKey, claims.Subject)
+			contextWithUser = context.WithValue(contextWithUser, RoleKey, models.Role(claims.Role))
+			contextWithUser = withUserLogger(contextWithUser, claims.Subject)
 
 			// Call the next handler
 			next.ServeHTTP(w, r.WithContext(contextWithUser))
@@ -62,3 +68,21 @@ func NewAuthMiddleware(secretKey string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// RequireRole lets through users having any of the roles, it should follow the auth middleware
+func RequireRole(roles ...models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			role, _ := r.Context().Value(RoleKey).(models.Role)
+
+			if !slices.Contains(roles, role) {
+				http.Error(w, common.ErrorForbidden.Error(), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+
+		})
+	}
+}
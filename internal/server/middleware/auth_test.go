@@ -1,7 +1,14 @@
 package middleware
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExtractAuthToken(t *testing.T) {
@@ -32,6 +39,45 @@ func TestExtractAuthToken(t *testing.T) {
 	}
 }
 
+func TestRequireRole(t *testing.T) {
+	keys := auth.NewHMACKeySet("secret")
+	opts := auth.TokenOptions{Issuer: "issuer", Audience: "audience", Validity: time.Minute}
+
+	handler := NewAuthMiddleware(keys, opts)(RequireRole(models.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "user1", r.Context().Value(UserIDKey))
+		require.Equal(t, models.RoleAdmin, r.Context().Value(RoleKey))
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	tests := []struct {
+		name       string
+		role       models.Role
+		wantStatus int
+	}{
+		{"admin", models.RoleAdmin, http.StatusOK},
+		{"user", models.RoleUser, http.StatusForbidden},
+		{"no role", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := auth.GenerateToken("user1", string(tt.role), keys, opts)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	// not authenticated
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/users", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
 // func TestAuthMiddleware(t *testing.T) {
 // 	type args struct {
 // 		next http.Handler
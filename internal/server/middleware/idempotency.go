@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// longer keys are rejected, so that clients can't make us store arbitrary data
+const maxIdempotencyKeyLength = 255
+
+type IdempotencyStore interface {
+	Start(ctx context.Context, userID string, key string, requestHash string) (*models.IdempotencyKey, error)
+	Complete(ctx context.Context, userID string, key string, statusCode int, headers map[string][]string, body []byte) error
+	Abandon(ctx context.Context, userID string, key string) error
+}
+
+// responseRecorder passes the response through, keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header // headers sent with the response
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+		r.header = r.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+		r.header = r.Header().Clone()
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handlerHeaders returns the headers set or changed by the handler, those set before it
+// (e.g. by the tracing middleware) belong to the request and are not replayed
+func handlerHeaders(before http.Header, after http.Header) http.Header {
+	headers := http.Header{}
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			headers[name] = values
+		}
+	}
+	return headers
+}
+
+// NewIdempotencyMiddleware replays the stored response to requests retried with the same Idempotency-Key header,
+// should be used after the auth middleware since keys are kept per user
+func NewIdempotencyMiddleware(store IdempotencyStore, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+				return
+			}
+
+			ctx := r.Context()
+
+			userID, ok := ctx.Value(UserIDKey).(string)
+			if !ok {
+				http.Error(w, "User not found", http.StatusInternalServerError)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			stored, err := store.Start(ctx, userID, key, requestHash(r, body))
+			if err != nil {
+				switch {
+				case errors.Is(err, common.ErrorIdempotencyKeyMismatch):
+					http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				case errors.Is(err, common.ErrorIdempotencyKeyInProgress):
+					http.Error(w, err.Error(), http.StatusConflict)
+				default:
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			// request has already been processed, replaying the response
+			if stored != nil {
+				for name, values := range stored.Headers {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			}
+
+			before := w.Header().Clone()
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.statusCode == 0 {
+				rec.statusCode = http.StatusOK
+				rec.header = w.Header().Clone()
+			}
+
+			// server errors are not stored, so that the request may be retried
+			storeCtx := context.WithoutCancel(ctx)
+			if rec.statusCode >= http.StatusInternalServerError {
+				err = store.Abandon(storeCtx, userID, key)
+			} else {
+				err = store.Complete(storeCtx, userID, key, rec.statusCode, handlerHeaders(before, rec.header), rec.body.Bytes())
+			}
+			if err != nil {
+				logger.ErrorContext(ctx, "Error saving idempotency key", "key", key, "err", err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	logger := logging.NewLogger()
+	store := service.NewIdempotencyService(repo, &config.Config{}, logger)
+
+	calls := 0
+	status := http.StatusOK
+	handler := NewIdempotencyMiddleware(store, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		w.Write([]byte("result"))
+	}))
+
+	request := func(userID string, key string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), UserIDKey, userID))
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Request-Id", key+body)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		userID     string
+		key        string
+		body       string
+		status     int
+		wantStatus int
+		wantCalls  int
+	}{
+		{"No key", "user1", "", "body", http.StatusOK, http.StatusOK, 1},
+		{"No key again", "user1", "", "body", http.StatusOK, http.StatusOK, 2},
+		{"First request", "user1", "key1", "body", http.StatusPaymentRequired, http.StatusPaymentRequired, 3},
+		{"Retry is replayed", "user1", "key1", "body", http.StatusOK, http.StatusPaymentRequired, 3},
+		{"Key reused with other body", "user1", "key1", "other", http.StatusOK, http.StatusUnprocessableEntity, 3},
+		{"Same key of other user", "user2", "key1", "body", http.StatusOK, http.StatusOK, 4},
+		{"Server error", "user1", "key2", "body", http.StatusInternalServerError, http.StatusInternalServerError, 5},
+		{"Retry after server error", "user1", "key2", "body", http.StatusOK, http.StatusOK, 6},
+		{"Key too long", "user1", strings.Repeat("k", 256), "body", http.StatusOK, http.StatusBadRequest, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			w := request(tt.userID, tt.key, tt.body)
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantCalls, calls)
+		})
+	}
+
+	t.Run("Replayed body", func(t *testing.T) {
+		w := request("user1", "key1", "body")
+		require.Equal(t, "result", w.Body.String())
+		require.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+		require.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+
+		// headers set before the handler are not replayed
+		stored, err := repo.FindIdempotencyKey(context.Background(), "user1", "key1")
+		require.NoError(t, err)
+		require.Equal(t, map[string][]string{"Content-Type": {"text/plain"}}, stored.Headers)
+	})
+
+	t.Run("In progress", func(t *testing.T) {
+		_, err := store.Start(context.Background(), "user1", "key3", requestHash(httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", nil), []byte("body")))
+		require.NoError(t, err)
+
+		w := request("user1", "key3", "body")
+		require.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestIdempotencyMiddleware_Expired(t *testing.T) {
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	logger := logging.NewLogger()
+	store := service.NewIdempotencyService(repo, &config.Config{IdempotencyKeyTTL: time.Hour}, logger)
+
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("result"))
+	})
+	handler := NewIdempotencyMiddleware(store, logger)(next)
+
+	request := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+		r.Header.Set(IdempotencyKeyHeader, "key1")
+		r = r.WithContext(context.WithValue(r.Context(), UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, request("body").Code)
+
+	// key is kept while it is not expired
+	require.NoError(t, store.PurgeExpired(context.Background()))
+	require.Equal(t, http.StatusUnprocessableEntity, request("other").Code)
+	require.Equal(t, 1, calls)
+
+	// expired key is used again as if it was new
+	expired := service.NewIdempotencyService(repo, &config.Config{IdempotencyKeyTTL: time.Nanosecond}, logger)
+	handler = NewIdempotencyMiddleware(expired, logger)(next)
+	w := request("other")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	require.Equal(t, 2, calls)
+
+	require.NoError(t, expired.PurgeExpired(context.Background()))
+	_, err = repo.FindIdempotencyKey(context.Background(), "user1", "key1")
+	require.ErrorIs(t, err, common.ErrorNotFound)
+}
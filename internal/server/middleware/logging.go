@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+
+	RequestIDKey contextKey = "requestID"
+)
+
+// longer ids sent by clients are replaced, so that they can't make us log arbitrary data
+const maxRequestIDLength = 128
+
+// accessLogEntry collects what the inner middlewares learn about the request
+type accessLogEntry struct {
+	userID string
+}
+
+type accessLogKey struct{}
+
+// request id is written to the log as is, so only safe characters are accepted
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestIDMiddleware keeps the X-Request-ID header of the client or generates a new id,
+// the id is returned in the response header and put into the request context
+func NewRequestIDMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = uuid.NewString()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), RequestIDKey, id)))
+		})
+	}
+}
+
+// NewLoggingMiddleware puts the request logger into the context and writes the access log record once
+// the request is served. Only the path is logged, headers, query and body may carry credentials
+func NewLoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			start := time.Now()
+			ctx := r.Context()
+
+			l := logger
+			if id, ok := ctx.Value(RequestIDKey).(string); ok {
+				l = l.With("request_id", id)
+			}
+			if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+				l = l.With("trace_id", sc.TraceID().String())
+			}
+
+			entry := &accessLogEntry{}
+			ctx = context.WithValue(logging.WithLogger(ctx, l), accessLogKey{}, entry)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", routePattern(r)),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+			}
+			if entry.userID != "" {
+				attrs = append(attrs, slog.String("user_id", entry.userID))
+			}
+
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			l.LogAttrs(ctx, level, "Request served", attrs...)
+		})
+	}
+}
+
+// adds the user to the request logger and the access log record
+func withUserLogger(ctx context.Context, userID string) context.Context {
+	if entry, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.userID = userID
+	}
+	if l := logging.FromContext(ctx, nil); l != nil {
+		ctx = logging.WithLogger(ctx, l.With("user_id", userID))
+	}
+	return ctx
+}
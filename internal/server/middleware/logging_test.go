@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	keys := auth.NewHMACKeySet("secret")
+	opts := auth.TokenOptions{Issuer: "issuer", Audience: "audience", Validity: time.Minute}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	r := chi.NewRouter()
+	r.Use(NewRequestIDMiddleware())
+	r.Use(NewLoggingMiddleware(logger))
+	r.With(NewAuthMiddleware(keys, opts)).Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		// handlers and services get the request logger
+		logging.FromContext(r.Context(), nil).Info("Handling")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	token, err := auth.GenerateToken("user1", "", keys, opts)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		requestID     string
+		wantRequestID string
+	}{
+		{"client request id", "abc-123", "abc-123"},
+		{"generated request id", "", ""},
+		{"unsafe request id", "abc\ndef", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders/1?secret=1", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if tt.wantRequestID != "" {
+				require.Equal(t, tt.wantRequestID, id)
+			} else {
+				require.True(t, validRequestID(id))
+				require.NotEqual(t, tt.requestID, id)
+			}
+
+			require.NotContains(t, buf.String(), token)
+			require.NotContains(t, buf.String(), "secret")
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			require.Len(t, lines, 2)
+
+			var handling, access map[string]any
+			require.NoError(t, json.Unmarshal([]byte(lines[0]), &handling))
+			require.NoError(t, json.Unmarshal([]byte(lines[1]), &access))
+
+			require.Equal(t, id, handling["request_id"])
+			require.Equal(t, "user1", handling["user_id"])
+
+			require.Equal(t, "Request served", access["msg"])
+			require.Equal(t, id, access["request_id"])
+			require.Equal(t, "user1", access["user_id"])
+			require.Equal(t, "/api/user/orders/{number}", access["route"])
+			require.Equal(t, "/api/user/orders/1", access["path"])
+			require.Equal(t, float64(http.StatusNoContent), access["status"])
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requests that matched no route share one label, so that scanners can't blow up the number of series
+const unmatchedRoute = "unmatched"
+
+type HTTPMetrics interface {
+	ObserveHTTPRequest(method string, route string, status int, d time.Duration)
+}
+
+// NewMetricsMiddleware records latency and status of the requests by the chi route pattern,
+// should be used on the root router, as the pattern is only complete once the request is served
+func NewMetricsMiddleware(metrics HTTPMetrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			metrics.ObserveHTTPRequest(r.Method, routePattern(r), status, time.Since(start))
+		})
+	}
+}
+
+// returns the pattern of the route matched by chi, it is only complete once the request is served
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+	return unmatchedRoute
+}
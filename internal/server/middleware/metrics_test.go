@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	m := metrics.New()
+
+	r := chi.NewRouter()
+	r.Use(NewMetricsMiddleware(m))
+	r.Route("/api/user", func(r chi.Router) {
+		r.Get("/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+
+	for _, path := range []string{"/api/user/orders/1", "/api/user/orders/2", "/no/such/path"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// only the counts are checked, the latency is not predictable
+	count, err := testutil.GatherAndCount(m.Registry(), "gophermart_http_request_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	// the route pattern is used rather than the path
+	require.Contains(t, body, `gophermart_http_request_duration_seconds_count{method="GET",route="/api/user/orders/{number}",status="204"} 2`)
+	require.Contains(t, body, `gophermart_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`)
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/server/middleware"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
@@ -110,6 +111,12 @@ func (h *OrderHandler) RegisterOrder(w http.ResponseWriter, r *http.Request) {
 // GET /api/user/orders HTTP/1.1
 // Content-Length: 0
 // ```
+// Необязательные параметры запроса:
+// - `limit` — размер страницы (от 1 до 1000), без него возвращаются все заказы;
+// - `cursor` — значение заголовка `X-Next-Cursor` предыдущей страницы;
+// - `status` — статусы заказов, можно указать несколько через запятую;
+// - `from`, `to` — начало (включительно) и конец периода загрузки в формате RFC3339;
+// - `sort` — `desc` (по умолчанию) или `asc`.
 // Возможные коды ответа:
 // - `200` — успешная обработка запроса.
 //   Формат ответа:
@@ -137,6 +144,7 @@ func (h *OrderHandler) RegisterOrder(w http.ResponseWriter, r *http.Request) {
 //     ]
 //     ```
 // - `204` — нет данных для ответа.
+// - `400` — неверные параметры запроса.
 // - `401` — пользователь не авторизован.
 // - `500` — внутренняя ошибка сервера.
 
@@ -152,9 +160,15 @@ func (h *OrderHandler) GetUserOrderList(w http.ResponseWriter, r *http.Request)
 
 	}
 
-	orders, err := h.service.GetOrderList(ctx, userID)
+	filter, err := parseOrderListFilter(r.URL.Query())
 	if err != nil {
-		h.logger.ErrorContext(ctx, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, next, err := h.service.GetOrderList(ctx, userID, filter)
+	if err != nil {
+		logging.FromContext(ctx, h.logger).Error(err.Error())
 		http.Error(w, InternalError, http.StatusInternalServerError)
 		return
 	}
@@ -165,6 +179,16 @@ func (h *OrderHandler) GetUserOrderList(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	setNextCursor(w, next)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newOrderDTOs(orders)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+}
+
+func newOrderDTOs(orders []models.Order) []models.OrderDTO {
 	var reply []models.OrderDTO
 	for _, r := range orders {
 		reply = append(reply, models.OrderDTO{
@@ -174,11 +198,5 @@ func (h *OrderHandler) GetUserOrderList(w http.ResponseWriter, r *http.Request)
 			Accrual:    r.Accrual,
 		})
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(reply); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
+	return reply
 }
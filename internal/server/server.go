@@ -2,14 +2,16 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	m "github.com/dmitrijs2005/gophermart-loyalty-system/internal/server/middleware"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
 type HTTPServer struct {
@@ -29,6 +31,15 @@ func (s *HTTPServer) RegisterAuthRoutes(r chi.Router) {
 
 	r.Post("/register", h.Register)
 	r.Post("/login", h.Login)
+	r.Post("/token/refresh", h.Refresh)
+	r.Post("/logout", h.Logout)
+	r.Post("/password/reset/request", h.RequestPasswordReset)
+	r.Post("/password/reset", h.ResetPassword)
+
+	r.Group(func(r chi.Router) {
+		r.Use(m.NewAuthMiddleware(s.serviceProvider.Keys, s.serviceProvider.TokenOptions))
+		r.Post("/password", h.ChangePassword)
+	})
 }
 
 func (s *HTTPServer) RegisterOrderRoutes(r chi.Router) {
@@ -37,7 +48,7 @@ func (s *HTTPServer) RegisterOrderRoutes(r chi.Router) {
 	h := NewOrderHandler(service, s.logger)
 
 	r.Group(func(r chi.Router) {
-		r.Use(m.NewAuthMiddleware(s.config.SecretKey))
+		r.Use(m.NewAuthMiddleware(s.serviceProvider.Keys, s.serviceProvider.TokenOptions))
 		r.Post("/orders", h.RegisterOrder)
 		r.Get("/orders", h.GetUserOrderList)
 	})
@@ -50,18 +61,49 @@ func (s *HTTPServer) RegisterBalanceRoutes(r chi.Router) {
 	h := NewBalanceHandler(service)
 
 	r.Group(func(r chi.Router) {
-		r.Use(m.NewAuthMiddleware(s.config.SecretKey))
+		r.Use(m.NewAuthMiddleware(s.serviceProvider.Keys, s.serviceProvider.TokenOptions))
 		r.Get("/balance", h.UserBalance)
-		r.Post("/balance/withdraw", h.Withdraw)
+		r.With(m.NewIdempotencyMiddleware(s.serviceProvider.IdempotencyService, s.logger)).
+			Post("/balance/withdraw", h.Withdraw)
 		r.Get("/withdrawals", h.Withdrawals)
 	})
 
 }
 
+func (s *HTTPServer) RegisterAdminRoutes(r chi.Router) {
+
+	sp := s.serviceProvider
+	h := NewAdminHandler(sp.AdminService, sp.OrderService, sp.BalanceService, s.logger)
+
+	r.Group(func(r chi.Router) {
+		r.Use(m.NewAuthMiddleware(sp.Keys, sp.TokenOptions))
+		r.Use(m.RequireRole(models.RoleAdmin))
+		r.Get("/users", h.FindUser)
+		r.Get("/users/{userID}", h.GetUser)
+		r.Get("/users/{userID}/orders", h.GetUserOrders)
+		r.Get("/users/{userID}/withdrawals", h.GetUserWithdrawals)
+		r.Put("/users/{userID}/role", h.SetUserRole)
+		r.With(m.NewIdempotencyMiddleware(sp.IdempotencyService, s.logger)).
+			Post("/users/{userID}/adjustments", h.AdjustBalance)
+		r.Get("/users/{userID}/adjustments", h.GetAdjustments)
+		r.Post("/withdrawals/{order}/reversal", h.ReverseWithdrawal)
+	})
+
+}
+
 func (s *HTTPServer) RegisterRoutes() http.Handler {
 
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(m.NewTracingMiddleware())
+	r.Use(m.NewRequestIDMiddleware())
+	r.Use(m.NewLoggingMiddleware(s.logger))
+	r.Use(m.NewMetricsMiddleware(s.serviceProvider.Metrics))
+
+	health := NewHealthHandler(s.serviceProvider.HealthService)
+	r.Get("/healthz", health.Healthz)
+	r.Get("/readyz", health.Readyz)
+
+	r.Get("/.well-known/jwks.json", s.JWKS)
 
 	r.Route("/api/user", func(r chi.Router) {
 		s.RegisterAuthRoutes(r)
@@ -69,31 +111,79 @@ func (s *HTTPServer) RegisterRoutes() http.Handler {
 		s.RegisterBalanceRoutes(r)
 	})
 
+	r.Route("/api/admin", s.RegisterAdminRoutes)
+
 	return r
 }
 
-func (s *HTTPServer) Run(ctx context.Context) error {
+// RegisterMetricsRoutes serves the metrics on their own listener, so that they are not exposed along with the API
+func (s *HTTPServer) RegisterMetricsRoutes() http.Handler {
+
+	r := chi.NewRouter()
+	r.Handle("/metrics", s.serviceProvider.Metrics.Handler())
+
+	return r
+}
 
-	mux := s.RegisterRoutes()
+// Run serves until ctx is cancelled, then stops accepting connections and waits for the in-flight
+// requests no longer than the shutdown timeout, the connections left are closed
+func (s *HTTPServer) Run(ctx context.Context) error {
 
-	server := &http.Server{
+	servers := []*http.Server{{
 		Addr:    s.config.RunAddress,
-		Handler: mux,
+		Handler: s.RegisterRoutes(),
+	}}
+
+	if s.config.MetricsAddress != "" {
+		servers = append(servers, &http.Server{
+			Addr:    s.config.MetricsAddress,
+			Handler: s.RegisterMetricsRoutes(),
+		})
+	}
+
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			serveErr <- server.ListenAndServe()
+		}()
 	}
 
-	go func() {
-		<-ctx.Done()
-		if err := server.Shutdown(context.Background()); err != nil {
-			s.logger.ErrorContext(ctx, "Error shutting server down", "err", err.Error())
+	select {
+	case err := <-serveErr:
+		// e.g. the address is already in use
+		s.logger.ErrorContext(ctx, "Error running server", "err", err.Error())
+		for _, server := range servers {
+			_ = server.Close()
 		}
-	}()
+		return err
+	case <-ctx.Done():
+	}
+
+	// giving the load balancer time to notice the failing readiness before the listener is closed
+	s.serviceProvider.HealthService.SetShuttingDown()
+	if s.config.ShutdownDelay > 0 {
+		s.logger.Info("Readiness reported failing before shutdown", "delay", s.config.ShutdownDelay)
+		time.Sleep(s.config.ShutdownDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.config.ShutdownTimeout)
+	defer cancel()
 
-	err := server.ListenAndServe()
+	// the metrics listener is stopped last, so that the drain can still be observed
+	var err error
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+			s.logger.Error("In-flight requests not finished in time, closing connections", "addr", server.Addr, "err", shutdownErr.Error())
+			_ = server.Close()
+			err = errors.Join(err, shutdownErr)
+		}
+	}
 	if err != nil {
-		s.logger.ErrorContext(ctx, "Error", "err", err.Error())
 		return err
 	}
 
+	s.logger.Info("Server stopped")
+
 	return nil
 
 }
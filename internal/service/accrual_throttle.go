@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// used when the accrual system replies 429 without a usable Retry-After header
+const defaultAccrualRetryAfter = 60 * time.Second
+
+// accrualThrottle is shared by all outgoing accrual system calls.
+// The calls are spaced by the limiter (if any) so that the configured rate
+// is not exceeded, and when the accrual system still replies 429 Too Many
+// Requests, every call is paused until the deadline from the Retry-After
+// header has passed.
+type accrualThrottle struct {
+	mu      sync.Mutex
+	until   time.Time
+	limiter *rate.Limiter
+}
+
+// newAccrualLimiter returns nil (no limit) for a non-positive rate
+func newAccrualLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// pause blocks outgoing calls until the given moment, an earlier deadline
+// never shortens an already active pause
+func (t *accrualThrottle) pause(until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until.After(t.until) {
+		t.until = until
+	}
+}
+
+func (t *accrualThrottle) pausedUntil() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.until
+}
+
+// wait blocks until the rate limit allows one more call and the pause (if any)
+// is over, or the context is cancelled
+func (t *accrualThrottle) wait(ctx context.Context) error {
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	for {
+		delay := time.Until(t.pausedUntil())
+		if delay <= 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(delay):
+			// the deadline could have been extended meanwhile, checking again
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// parseRetryAfter converts Retry-After header value (either delay in seconds
+// or HTTP-date) into the moment when requests may be resumed
+func parseRetryAfter(value string, now time.Time) time.Time {
+
+	value = strings.TrimSpace(value)
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return date
+	}
+
+	return now.Add(defaultAccrualRetryAfter)
+}
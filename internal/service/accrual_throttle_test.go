@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+
+	now := time.Date(2025, 4, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Time
+	}{
+		{"Seconds", "60", now.Add(60 * time.Second)},
+		{"Seconds with spaces", " 5 ", now.Add(5 * time.Second)},
+		{"Negative seconds", "-5", now},
+		{"HTTP date", "Tue, 15 Apr 2025 12:02:00 GMT", now.Add(2 * time.Minute)},
+		{"Empty", "", now.Add(defaultAccrualRetryAfter)},
+		{"Garbage", "soon", now.Add(defaultAccrualRetryAfter)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRetryAfter(tt.value, now)
+			require.True(t, got.Equal(tt.want), "parseRetryAfter() = %v, want %v", got, tt.want)
+		})
+	}
+}
+
+func TestAccrualThrottle(t *testing.T) {
+
+	var throttle accrualThrottle
+
+	until := time.Now().Add(200 * time.Millisecond)
+	throttle.pause(until)
+
+	// earlier deadline should not shorten the pause
+	throttle.pause(time.Now())
+	require.Equal(t, until, throttle.pausedUntil())
+
+	start := time.Now()
+	require.NoError(t, throttle.wait(context.Background()))
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestAccrualThrottle_RateLimit(t *testing.T) {
+
+	require.Nil(t, newAccrualLimiter(0))
+
+	throttle := accrualThrottle{limiter: newAccrualLimiter(20)}
+
+	// the first call passes at once, the next ones are spaced by 50ms
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, throttle.wait(context.Background()))
+	}
+	require.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, throttle.wait(ctx))
+}
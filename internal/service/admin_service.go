@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/google/uuid"
+)
+
+// AdminService serves the support team, callers are expected to be checked for the admin role
+type AdminService struct {
+	baseService BaseService
+	repository  repository.Repository
+	config      *config.Config
+	logger      *slog.Logger
+}
+
+func NewAdminService(r repository.Repository, c *config.Config, l *slog.Logger) *AdminService {
+	return &AdminService{repository: r, config: c, logger: l, baseService: BaseService{}}
+}
+
+func (s *AdminService) newUserDTO(ctx context.Context, user models.User) (*models.AdminUserDTO, error) {
+	balance, err := s.repository.GetUserBalance(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AdminUserDTO{
+		ID:      user.ID,
+		Login:   user.Login,
+		Role:    user.Role,
+		Balance: models.BalanceDTO{Current: balance.Current, Withdrawn: balance.Withdrawn},
+	}, nil
+}
+
+// ids come from the request path, anything but a UUID can not be a user and would fail the query
+func checkUserID(userID string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return common.ErrorNotFound
+	}
+	return nil
+}
+
+// GetUser returns the user and their balance, common.ErrorNotFound if there is no such user
+func (s *AdminService) GetUser(ctx context.Context, userID string) (*models.AdminUserDTO, error) {
+	if err := checkUserID(userID); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repository.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newUserDTO(ctx, user)
+}
+
+// FindUserByLogin returns the user and their balance, common.ErrorNotFound if there is no such user
+func (s *AdminService) FindUserByLogin(ctx context.Context, login string) (*models.AdminUserDTO, error) {
+	user, err := s.repository.FindUserByLogin(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newUserDTO(ctx, user)
+}
+
+// SetUserRole changes the role of the user. Access tokens already issued keep the old role
+// until they expire, the new one is issued on the next refresh
+func (s *AdminService) SetUserRole(ctx context.Context, userID string, role models.Role) error {
+	switch role {
+	case models.RoleUser, models.RoleAdmin:
+	default:
+		return common.ErrorUnknownRole
+	}
+
+	if err := checkUserID(userID); err != nil {
+		return err
+	}
+
+	err := s.repository.UpdateUserRole(ctx, userID, role)
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx, s.logger).Info("User role changed", "id", userID, "role", role)
+	return nil
+}
+
+// BootstrapAdmins promotes the configured logins to admins, so that the first admin
+// does not have to be created in the database by hand. Nothing is done once there is an admin,
+// so that the roles are managed by the admins from then on. Every login has to be registered,
+// otherwise anyone could register it and become an admin on the next start
+func (s *AdminService) BootstrapAdmins(ctx context.Context, logins []string) error {
+	if len(logins) == 0 {
+		return nil
+	}
+
+	exists, err := s.repository.HasUsersWithRole(ctx, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if exists {
+		logging.FromContext(ctx, s.logger).Info("Admins already exist, configured admin logins are ignored")
+		return nil
+	}
+
+	users := make([]models.User, 0, len(logins))
+	for _, login := range logins {
+		user, err := s.repository.FindUserByLogin(ctx, login)
+		if errors.Is(err, common.ErrorNotFound) {
+			return fmt.Errorf("admin login %q is not registered: %w", login, err)
+		}
+		if err != nil {
+			return err
+		}
+		users = append(users, user)
+	}
+
+	for _, user := range users {
+		if err := s.SetUserRole(ctx, user.ID, models.RoleAdmin); err != nil {
+			return err
+		}
+		logging.FromContext(ctx, s.logger).Info("User promoted to admin", "login", user.Login)
+	}
+
+	return nil
+}
+
+func newAdjustmentDTO(a models.Adjustment) *models.AdjustmentDTO {
+	return &models.AdjustmentDTO{
+		ID:         a.ID,
+		Amount:     a.Amount,
+		Reason:     a.Reason,
+		Comment:    a.Comment,
+		OperatorID: a.OperatorID,
+		CreatedAt:  a.CreatedAt,
+	}
+}
+
+// AdjustBalance credits (positive amount) or debits (negative amount) the user balance on behalf of the operator.
+// The adjustment is kept with its reason and operator, the balance can not go below zero
+func (s *AdminService) AdjustBalance(ctx context.Context, operatorID string, userID string,
+	request *models.AdjustmentRequestDTO) (result *models.AdjustmentDTO, err error) {
+
+	if operatorID == "" {
+		return nil, common.ErrorNoUserID
+	}
+
+	switch request.Reason {
+	case models.AdjustmentReasonGoodwill, models.AdjustmentReasonFraudReversal, models.AdjustmentReasonCorrection:
+	default:
+		return nil, common.ErrorUnknownAdjustmentReason
+	}
+
+	if request.Amount == 0 {
+		return nil, common.ErrorZeroAdjustment
+	}
+
+	if err := checkUserID(userID); err != nil {
+		return nil, err
+	}
+
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.baseService.EndTransaction(tx, &err)
+
+	// the user stays locked until the adjustment is committed
+	_, err = s.repository.FindUserByIDForUpdate(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Amount < 0 {
+		balance, err := s.repository.GetUserBalance(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if balance.Current+request.Amount < 0 {
+			return nil, common.ErrorInsufficientBalance
+		}
+	}
+
+	adjustment := &models.Adjustment{
+		UserID:     userID,
+		Amount:     request.Amount,
+		Reason:     request.Reason,
+		Comment:    request.Comment,
+		OperatorID: operatorID,
+	}
+
+	err = s.repository.AddAdjustment(ctx, adjustment)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &models.LedgerEntry{UserID: userID, Type: models.LedgerEntryAdjustment, Amount: request.Amount, AdjustmentID: adjustment.ID}
+	err = s.repository.AddLedgerEntry(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, s.logger).Info("Balance adjusted", "id", userID, "operator_id", operatorID,
+		"amount", request.Amount, "reason", request.Reason, "balance", entry.Balance)
+
+	return newAdjustmentDTO(*adjustment), nil
+}
+
+// GetAdjustments returns adjustments of the user newest first, common.ErrorNotFound if there is no such user
+func (s *AdminService) GetAdjustments(ctx context.Context, userID string) ([]*models.AdjustmentDTO, error) {
+	if err := checkUserID(userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repository.FindUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	adjustments, err := s.repository.GetAdjustmentsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.AdjustmentDTO
+	for _, a := range adjustments {
+		result = append(result, newAdjustmentDTO(a))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminService(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	s := NewAdminService(repo, &config.Config{}, logging.NewLogger())
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+	require.Equal(t, models.RoleUser, user.Role)
+	creditUser(t, repo, user.ID, models.NewMoney(5))
+
+	found, err := s.FindUserByLogin(ctx, "LOGIN")
+	require.NoError(t, err)
+	require.Equal(t, &models.AdminUserDTO{ID: user.ID, Login: "login", Role: models.RoleUser,
+		Balance: models.BalanceDTO{Current: models.NewMoney(5)}}, found)
+
+	_, err = s.FindUserByLogin(ctx, "unknown")
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	_, err = s.GetUser(ctx, uuid.NewString())
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	// ids which are not UUIDs never reach the repository
+	_, err = s.GetUser(ctx, "not-a-uuid")
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	err = s.SetUserRole(ctx, user.ID, "root")
+	require.ErrorIs(t, err, common.ErrorUnknownRole)
+
+	err = s.SetUserRole(ctx, uuid.NewString(), models.RoleAdmin)
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	err = s.SetUserRole(ctx, "not-a-uuid", models.RoleAdmin)
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	// unknown logins fail the bootstrap, nobody is promoted
+	require.ErrorIs(t, s.BootstrapAdmins(ctx, []string{"login", "unknown"}), common.ErrorNotFound)
+
+	found, err = s.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.RoleUser, found.Role)
+
+	require.NoError(t, s.BootstrapAdmins(ctx, []string{"login"}))
+
+	found, err = s.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.RoleAdmin, found.Role)
+
+	other, err := repo.AddUser(ctx, &models.User{Login: "other", Password: "password"})
+	require.NoError(t, err)
+	require.NoError(t, s.SetUserRole(ctx, other.ID, models.RoleAdmin))
+	require.NoError(t, s.SetUserRole(ctx, user.ID, models.RoleUser))
+
+	// once there is an admin, the configured logins are not promoted again
+	require.NoError(t, s.BootstrapAdmins(ctx, []string{"login", "unknown"}))
+
+	found, err = s.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.RoleUser, found.Role)
+}
+
+func TestAdminService_AdjustBalance(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	s := NewAdminService(repo, &config.Config{}, logging.NewLogger())
+
+	operator, err := repo.AddUser(ctx, &models.User{Login: "admin", Password: "password", Role: models.RoleAdmin})
+	require.NoError(t, err)
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+	creditUser(t, repo, user.ID, models.NewMoney(5))
+	debitUser(t, repo, user.ID, models.NewMoney(1))
+
+	tests := []struct {
+		name    string
+		userID  string
+		request models.AdjustmentRequestDTO
+		wantErr error
+	}{
+		{"Unknown reason", user.ID, models.AdjustmentRequestDTO{Amount: models.NewMoney(1), Reason: "GIFT"}, common.ErrorUnknownAdjustmentReason},
+		{"Zero amount", user.ID, models.AdjustmentRequestDTO{Reason: models.AdjustmentReasonGoodwill}, common.ErrorZeroAdjustment},
+		{"Unknown user", uuid.NewString(), models.AdjustmentRequestDTO{Amount: models.NewMoney(1), Reason: models.AdjustmentReasonGoodwill}, common.ErrorNotFound},
+		{"Invalid user id", "not-a-uuid", models.AdjustmentRequestDTO{Amount: models.NewMoney(1), Reason: models.AdjustmentReasonGoodwill}, common.ErrorNotFound},
+		{"Credit", user.ID, models.AdjustmentRequestDTO{Amount: models.NewMoney(10), Reason: models.AdjustmentReasonGoodwill, Comment: "sorry"}, nil},
+		{"Debit", user.ID, models.AdjustmentRequestDTO{Amount: models.NewMoney(-4), Reason: models.AdjustmentReasonFraudReversal}, nil},
+		{"Debit below zero", user.ID, models.AdjustmentRequestDTO{Amount: models.NewMoney(-11), Reason: models.AdjustmentReasonCorrection}, common.ErrorInsufficientBalance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AdjustBalance(ctx, operator.ID, tt.userID, &tt.request)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NotEmpty(t, got.ID)
+			require.Equal(t, operator.ID, got.OperatorID)
+			require.Equal(t, tt.request.Amount, got.Amount)
+		})
+	}
+
+	// adjustments change the current balance, not the withdrawn points
+	found, err := s.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.BalanceDTO{Current: models.NewMoney(10), Withdrawn: models.NewMoney(1)}, found.Balance)
+
+	adjustments, err := s.GetAdjustments(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, adjustments, 2)
+	require.Equal(t, models.AdjustmentReasonFraudReversal, adjustments[0].Reason)
+	require.Equal(t, "sorry", adjustments[1].Comment)
+
+	_, err = s.GetAdjustments(ctx, uuid.NewString())
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	_, err = s.GetAdjustments(ctx, "not-a-uuid")
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	_, err = s.AdjustBalance(ctx, "", user.ID, &models.AdjustmentRequestDTO{Amount: models.NewMoney(1), Reason: models.AdjustmentReasonGoodwill})
+	require.ErrorIs(t, err, common.ErrorNoUserID)
+}
@@ -2,156 +2,525 @@ package service
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/notifier"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/tracing"
+	"github.com/google/uuid"
 )
 
 type AuthService struct {
 	baseService BaseService
 	repository  repository.Repository
 	config      *config.Config
+	keys        *auth.KeySet
+	policy      *auth.CredentialsPolicy
+	notifier    notifier.Notifier
+	metrics     *metrics.Metrics
 	logger      *slog.Logger
-}
 
-func NewAuthService(r repository.Repository, c *config.Config, l *slog.Logger) *AuthService {
-	return &AuthService{repository: r, config: c, logger: l, baseService: BaseService{}}
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
-// possible password encryption
-func (s *AuthService) encryptPassword(salt []byte, password string) (string, error) {
-
-	passwordHash := password
-	if s.config.DatabaseURI != "" {
-		passwordHash = auth.HashPassword(password, salt)
+func NewAuthService(r repository.Repository, c *config.Config, k *auth.KeySet, p *auth.CredentialsPolicy,
+	n notifier.Notifier, m *metrics.Metrics, l *slog.Logger) *AuthService {
+	s := &AuthService{repository: r, config: c, keys: k, policy: p, notifier: n, metrics: m, logger: l, baseService: BaseService{}}
+	// computed at start, so that the first unknown login is not told apart either
+	if hasher, err := s.passwordHasher(); err == nil {
+		s.dummyPasswordHash(hasher)
 	}
-	return passwordHash, nil
+	return s
 }
 
-// possible password validation
-func (s *AuthService) passwordIsValid(password string) (bool, error) {
-	return password != "", nil
+// NewTokenOptions returns the options access tokens are issued and validated with
+func NewTokenOptions(c *config.Config) auth.TokenOptions {
+	return auth.TokenOptions{Issuer: c.JWTIssuer, Audience: c.JWTAudience, Validity: c.TokenValidityDuration, Leeway: c.JWTLeeway}
 }
 
-// possible custom login validation
-func (s *AuthService) loginIsValid(login string) (bool, error) {
-	return login != "", nil
+// passwords are hashed with the configured algorithm regardless of the storage
+func (s *AuthService) passwordHasher() (*auth.PasswordHasher, error) {
+	return auth.NewPasswordHasher(s.config.PasswordHashAlgorithm)
 }
 
-func newUser(login string, password string, salt string) (*models.User, error) {
+// NewCredentialsPolicy returns the login and password rules of the registration
+func NewCredentialsPolicy(c *config.Config) (*auth.CredentialsPolicy, error) {
+	policy := &auth.CredentialsPolicy{
+		LoginMinLength:     c.LoginMinLength,
+		LoginMaxLength:     c.LoginMaxLength,
+		PasswordMinLength:  c.PasswordMinLength,
+		PasswordMinClasses: c.PasswordMinClasses,
+	}
+
+	if c.PasswordDenylistFile != "" {
+		if err := policy.LoadDenylist(c.PasswordDenylistFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+func newUser(login string, password string) (*models.User, error) {
 	if login == "" {
 		return nil, errors.New("empty login")
 	}
 	if password == "" {
 		return nil, errors.New("empty password")
 	}
-	return &models.User{ID: "", Login: login, Password: password, Salt: salt}, nil
+	return &models.User{ID: "", Login: login, Password: password, Role: models.RoleUser}, nil
 }
 
-func (s *AuthService) Register(ctx context.Context, login string, password string) (string, error) {
+func (s *AuthService) Register(ctx context.Context, login string, password string) (tokens *models.TokensDTO, err error) {
+
+	ctx, span := tracing.Start(ctx, "AuthService.Register")
+	defer tracing.End(span, &err)
+
+	// all the failed rules are reported at once
+	if err := s.policy.Validate(login, password); err != nil {
+		return nil, err
+	}
 
-	loginIsValid, err := s.loginIsValid(login)
+	hasher, err := s.passwordHasher()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if !loginIsValid {
-		return "", common.ErrorInvalidLoginFormat
+
+	//ok, adding user
+	passwordHash, err := hasher.Hash(password)
+	if err != nil {
+		return nil, err
 	}
 
-	passwordIsValid, err := s.passwordIsValid(password)
+	u, err := newUser(login, passwordHash)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if !passwordIsValid {
-		return "", common.ErrorInvalidPasswordFormat
+
+	// user is counted once it is committed
+	defer func() {
+		if err == nil {
+			s.metrics.IncRegistrations()
+		}
+	}()
+
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer s.baseService.EndTransaction(tx, &err)
 
-	salt, err := auth.GenerateSalt(16)
+	user, err := s.repository.AddUser(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, user, "")
+
+}
+
+// checks the password, hashes made by another algorithm or with outdated parameters are
+// replaced by the current ones, as the plain password is only known at this moment
+func (s *AuthService) validatePassword(ctx context.Context, password string, existingUser *models.User) (bool, error) {
 
+	hasher, err := s.passwordHasher()
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	//ok, adding user
-	encryptedPassword, err := s.encryptPassword(salt, password)
+	ok, needsRehash, err := hasher.Verify(password, existingUser.Password)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if needsRehash {
+		passwordHash, err := hasher.Hash(password)
+		if err == nil {
+			err = s.repository.UpdateUserPassword(ctx, existingUser.ID, passwordHash)
+		}
+		// the old hash is still valid, so the login does not fail
+		if err != nil {
+			logging.FromContext(ctx, s.logger).Error("Password rehash failed", "user", existingUser.ID, "error", err)
+		}
+	}
+
+	return true, nil
+
+}
+
+// hash of a random password, unknown logins are verified against it
+func (s *AuthService) dummyPasswordHash(hasher *auth.PasswordHasher) string {
+	s.dummyHashOnce.Do(func() {
+		hash, err := hasher.Hash(uuid.NewString())
+		if err != nil {
+			s.logger.Error("Dummy password hash failed", "err", err)
+			return
+		}
+		s.dummyHash = hash
+	})
+	return s.dummyHash
+}
+
+// spends the same time on an unknown login as on a known one with a wrong password
+func (s *AuthService) verifyDummyPassword(password string) {
+	hasher, err := s.passwordHasher()
 	if err != nil {
-		return "", err
+		return
+	}
+	_, _, _ = hasher.Verify(password, s.dummyPasswordHash(hasher))
+}
+
+// Login authenticates the user. Failed attempts are counted per login and per client address ip,
+// the attempts are paused and then locked out after too many failures, every attempt is audited
+func (s *AuthService) Login(ctx context.Context, login string, password string, ip string) (tokens *models.TokensDTO, err error) {
+
+	ctx, span := tracing.Start(ctx, "AuthService.Login")
+	defer tracing.End(span, &err)
+
+	now := time.Now()
+	throttles := s.loginThrottles(login, ip)
+
+	// the password is not even checked while the login is locked
+	if err := s.checkLoginThrottles(ctx, throttles, now); err != nil {
+		if errors.Is(err, common.ErrorTooManyLoginAttempts) {
+			s.auditLoginAttempt(ctx, login, ip, models.LoginAttemptThrottled)
+		}
+		return nil, err
+	}
+
+	existingLogin, err := s.repository.FindUserByLogin(ctx, login)
+	if err != nil && !errors.Is(err, common.ErrorNotFound) {
+		return nil, err
+	}
+
+	// unknown logins are counted as failures too and take as long to check, so that they can not be told apart
+	passwordIsOk := false
+	if err == nil {
+		passwordIsOk, err = s.validatePassword(ctx, password, &existingLogin)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		s.verifyDummyPassword(password)
+	}
+
+	if !passwordIsOk {
+		if err := s.recordLoginFailure(ctx, throttles, now); err != nil {
+			return nil, err
+		}
+		s.auditLoginAttempt(ctx, login, ip, models.LoginAttemptFailure)
+		return nil, common.ErrorInvalidLoginPassword
+	}
+
+	// the address is not reset, otherwise a valid account would unlock guessing of other ones
+	if err := s.repository.DeleteLoginThrottle(ctx, loginThrottleKey(login)); err != nil {
+		return nil, err
+	}
+	s.auditLoginAttempt(ctx, login, ip, models.LoginAttemptSuccess)
+
+	return s.issueTokens(ctx, existingLogin, "")
+}
+
+// issues access token and refresh token, new refresh token family is started if familyID is empty
+func (s *AuthService) issueTokens(ctx context.Context, user models.User, familyID string) (*models.TokensDTO, error) {
+
+	accessToken, err := auth.GenerateToken(user.ID, string(user.Role), s.keys, NewTokenOptions(s.config))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+
+	err = s.repository.AddRefreshToken(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: auth.HashOpaqueToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.config.RefreshTokenValidityDuration),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokensDTO{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.config.TokenValidityDuration.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Refresh exchanges the refresh token for a new pair of tokens. Every refresh token can be used once,
+// using it again means it has leaked, so the whole family is revoked
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (tokens *models.TokensDTO, err error) {
+
+	ctx, span := tracing.Start(ctx, "AuthService.Refresh")
+	defer tracing.End(span, &err)
+
+	tokens, reused, err := s.rotateRefreshToken(ctx, auth.HashOpaqueToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		return nil, common.ErrorRefreshTokenReused
 	}
 
-	saltBase64 := base64.StdEncoding.EncodeToString(salt)
-	u, err := newUser(login, encryptedPassword, saltBase64)
+	return tokens, nil
+}
+
+// marks the token used and issues the next one of the family,
+// if the token has already been used the family is revoked instead
+func (s *AuthService) rotateRefreshToken(ctx context.Context, tokenHash string) (tokens *models.TokensDTO, reused bool, err error) {
+
+	token, err := s.repository.FindRefreshTokenByHash(ctx, tokenHash)
 	if err != nil {
-		return "", err
+		if errors.Is(err, common.ErrorNotFound) {
+			return nil, false, common.ErrorInvalidToken
+		}
+		return nil, false, err
 	}
 
-	tx, err := s.repository.UnitOfWork().Begin(ctx)
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
 	if err != nil {
-		return "", err
+		return nil, false, err
 	}
 	defer s.baseService.EndTransaction(tx, &err)
 
-	user, err := s.repository.AddUser(ctx, u)
+	// locking the user, so that concurrent refreshes with the same token are serialized,
+	// the user is re-read, so that role changes reach the new access token
+	user, err := s.repository.FindUserByIDForUpdate(ctx, token.UserID)
 	if err != nil {
-		return "", err
+		return nil, false, err
 	}
 
-	t, err := auth.GenerateToken(user.ID, s.config.SecretKey, &s.config.TokenValidityDuration)
+	token, err = s.repository.FindRefreshTokenByHash(ctx, tokenHash)
 	if err != nil {
-		return "", err
+		return nil, false, err
+	}
+
+	now := time.Now()
+
+	if !token.RevokedAt.IsZero() || !now.Before(token.ExpiresAt) {
+		return nil, false, common.ErrorInvalidToken
+	}
+
+	if !token.UsedAt.IsZero() {
+		logging.FromContext(ctx, s.logger).Warn("Refresh token reused, revoking the family", "user_id", token.UserID, "family_id", token.FamilyID)
+		err = s.repository.RevokeRefreshTokenFamily(ctx, token.FamilyID, now)
+		return nil, true, err
 	}
 
-	return t, nil
+	err = s.repository.MarkRefreshTokenUsed(ctx, token.ID, now)
+	if err != nil {
+		return nil, false, err
+	}
 
+	tokens, err = s.issueTokens(ctx, user, token.FamilyID)
+	return tokens, false, err
 }
 
-func (s *AuthService) validatePassword(password string, existingUser *models.User) (bool, error) {
+// Logout revokes the refresh token family, unknown tokens are ignored
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) (err error) {
 
-	salt := []byte{}
+	ctx, span := tracing.Start(ctx, "AuthService.Logout")
+	defer tracing.End(span, &err)
 
-	var err error
-	if s.config.DatabaseURI != "" {
-		salt, err = base64.StdEncoding.DecodeString(existingUser.Salt)
-		if err != nil {
-			return false, err
+	token, err := s.repository.FindRefreshTokenByHash(ctx, auth.HashOpaqueToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, common.ErrorNotFound) {
+			return nil
 		}
+		return err
 	}
 
-	encryptedPassword, err := s.encryptPassword(salt, password)
+	return s.repository.RevokeRefreshTokenFamily(ctx, token.FamilyID, time.Now())
+}
+
+// ChangePassword sets the new password if the current one is right. Refresh tokens of all sessions
+// are revoked, the new pair of tokens is returned for the current session.
+// Wrong current passwords are throttled the same way failed logins are
+func (s *AuthService) ChangePassword(ctx context.Context, userID string, currentPassword string, newPassword string,
+	ip string) (tokens *models.TokensDTO, err error) {
+
+	ctx, span := tracing.Start(ctx, "AuthService.ChangePassword")
+	defer tracing.End(span, &err)
+
+	user, err := s.repository.FindUserByID(ctx, userID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return encryptedPassword == existingUser.Password, nil
+	now := time.Now()
+	throttles := s.loginThrottles(user.Login, ip)
+
+	if err := s.checkLoginThrottles(ctx, throttles, now); err != nil {
+		return nil, err
+	}
 
+	hasher, err := s.passwordHasher()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, _, err := hasher.Verify(currentPassword, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := s.recordLoginFailure(ctx, throttles, now); err != nil {
+			return nil, err
+		}
+		return nil, common.ErrorInvalidLoginPassword
+	}
+
+	if err := s.policy.ValidatePassword(user.Login, newPassword); err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := hasher.Hash(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.baseService.EndTransaction(tx, &err)
+
+	err = s.setPassword(ctx, userID, passwordHash, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, user, "")
 }
 
-func (s *AuthService) Login(ctx context.Context, login string, password string) (string, error) {
+// RequestPasswordReset sends a password reset token to the user. Unknown logins are not reported,
+// so that the request can not be used to find out which logins exist
+func (s *AuthService) RequestPasswordReset(ctx context.Context, login string) (err error) {
 
-	existingLogin, err := s.repository.FindUserByLogin(ctx, login)
+	ctx, span := tracing.Start(ctx, "AuthService.RequestPasswordReset")
+	defer tracing.End(span, &err)
+
+	user, err := s.repository.FindUserByLogin(ctx, login)
+	if errors.Is(err, common.ErrorNotFound) {
+		logging.FromContext(ctx, s.logger).Info("Password reset requested for unknown login", "login", login)
+		return nil
+	}
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	//ok, adding user
-	passwordIsOk, err := s.validatePassword(password, &existingLogin)
+	token, err := auth.GenerateOpaqueToken()
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if !passwordIsOk {
-		return "", common.ErrorInvalidLoginPassword
+	expiresAt := time.Now().Add(s.config.PasswordResetValidity)
+
+	err = s.repository.AddPasswordResetToken(ctx, &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: auth.HashOpaqueToken(token),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.notifier.SendPasswordReset(ctx, user.Login, token, expiresAt)
+}
+
+// ResetPassword sets the new password by the reset token. The token can be used once,
+// all other reset tokens and all refresh tokens of the user are revoked
+func (s *AuthService) ResetPassword(ctx context.Context, resetToken string, newPassword string) (err error) {
+
+	ctx, span := tracing.Start(ctx, "AuthService.ResetPassword")
+	defer tracing.End(span, &err)
+
+	tokenHash := auth.HashOpaqueToken(resetToken)
+
+	token, err := s.repository.FindPasswordResetTokenByHash(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, common.ErrorNotFound) {
+			return common.ErrorInvalidResetToken
+		}
+		return err
+	}
+
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.baseService.EndTransaction(tx, &err)
+
+	// locking the user, so that the same token can not be used concurrently
+	user, err := s.repository.FindUserByIDForUpdate(ctx, token.UserID)
+	if err != nil {
+		return err
 	}
 
-	t, err := auth.GenerateToken(existingLogin.ID, s.config.SecretKey, &s.config.TokenValidityDuration)
+	token, err = s.repository.FindPasswordResetTokenByHash(ctx, tokenHash)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	now := time.Now()
+
+	if !token.UsedAt.IsZero() || !now.Before(token.ExpiresAt) {
+		return common.ErrorInvalidResetToken
+	}
+
+	if err := s.policy.ValidatePassword(user.Login, newPassword); err != nil {
+		return err
+	}
+
+	hasher, err := s.passwordHasher()
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = s.setPassword(ctx, user.ID, passwordHash, now)
+	if err != nil {
+		return err
+	}
+
+	// the user has proven the access, so the failed logins are forgotten
+	return s.repository.DeleteLoginThrottle(ctx, loginThrottleKey(user.Login))
+}
+
+// stores the new password hash and revokes everything issued with the old password
+func (s *AuthService) setPassword(ctx context.Context, userID string, passwordHash string, now time.Time) error {
+
+	if err := s.repository.UpdateUserPassword(ctx, userID, passwordHash); err != nil {
+		return err
+	}
+
+	if err := s.repository.RevokeUserRefreshTokens(ctx, userID, now); err != nil {
+		return err
 	}
 
-	return t, nil
+	return s.repository.InvalidateUserPasswordResetTokens(ctx, userID, now)
 }
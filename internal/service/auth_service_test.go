@@ -2,10 +2,12 @@ package service
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
@@ -20,7 +22,8 @@ func TestAuthService_Register(t *testing.T) {
 	repo, err := repository.NewInMemoryRepository()
 	require.NoError(t, err)
 
-	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute}
+	config := &config.Config{SecretKey: "secretkey", JWTIssuer: "issuer", JWTAudience: "audience", TokenValidityDuration: 1 * time.Minute, RefreshTokenValidityDuration: time.Hour}
+	keys := auth.NewHMACKeySet(config.SecretKey)
 	logger := logging.NewLogger()
 
 	type args struct {
@@ -42,6 +45,8 @@ func TestAuthService_Register(t *testing.T) {
 			s := &AuthService{
 				repository: repo,
 				config:     config,
+				keys:       keys,
+				policy:     &auth.CredentialsPolicy{},
 				logger:     logger,
 			}
 			got, err := s.Register(tt.args.ctx, tt.args.login, tt.args.password)
@@ -51,7 +56,7 @@ func TestAuthService_Register(t *testing.T) {
 			}
 
 			if !tt.wantErr {
-				userID, err := auth.GetUserIDFromToken(got, config.SecretKey)
+				userID, err := auth.GetUserIDFromToken(got.AccessToken, keys, NewTokenOptions(config))
 				require.NoError(t, err)
 				require.NotZero(t, userID)
 			}
@@ -67,10 +72,16 @@ func TestAuthService_Login(t *testing.T) {
 	repo, err := repository.NewInMemoryRepository()
 	require.NoError(t, err)
 
-	_, err = repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	hasher, err := auth.NewPasswordHasher(auth.PasswordHashArgon2id)
+	require.NoError(t, err)
+	passwordHash, err := hasher.Hash("password")
+	require.NoError(t, err)
+
+	_, err = repo.AddUser(ctx, &models.User{Login: "login", Password: passwordHash})
 	require.NoError(t, err)
 
-	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute}
+	config := &config.Config{SecretKey: "secretkey", JWTIssuer: "issuer", JWTAudience: "audience", TokenValidityDuration: 1 * time.Minute, RefreshTokenValidityDuration: time.Hour}
+	keys := auth.NewHMACKeySet(config.SecretKey)
 	logger := logging.NewLogger()
 
 	type args struct {
@@ -92,18 +103,301 @@ func TestAuthService_Login(t *testing.T) {
 			s := &AuthService{
 				repository: repo,
 				config:     config,
+				keys:       keys,
+				policy:     &auth.CredentialsPolicy{},
 				logger:     logger,
 			}
-			got, err := s.Login(tt.args.ctx, tt.args.login, tt.args.password)
+			got, err := s.Login(tt.args.ctx, tt.args.login, tt.args.password, "")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AuthService.Login() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !tt.wantErr {
-				userID, err := auth.GetUserIDFromToken(got, config.SecretKey)
+				userID, err := auth.GetUserIDFromToken(got.AccessToken, keys, NewTokenOptions(config))
 				require.NoError(t, err)
 				require.NotZero(t, userID)
 			}
 		})
 	}
 }
+
+func TestAuthService_LoginRehash(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	// hash of the previous versions
+	legacyHash := "$pbkdf2-sha256$i=100000$HHxqo4TGJzrcIBcv4Z1fAw$zHFUFI4QtHWwLIJ1jpYEzQ65fhSk0/Yp+yyAY/DkE2I"
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: legacyHash})
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute, RefreshTokenValidityDuration: time.Hour}
+	s := &AuthService{
+		repository: repo,
+		config:     config,
+		keys:       auth.NewHMACKeySet(config.SecretKey),
+		policy:     &auth.CredentialsPolicy{},
+		logger:     logging.NewLogger(),
+	}
+
+	_, err = s.Login(ctx, "login", "wrongpassword", "")
+	require.ErrorIs(t, err, common.ErrorInvalidLoginPassword)
+
+	stored, err := repo.FindUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, legacyHash, stored.Password, "hash is not replaced on failed login")
+
+	_, err = s.Login(ctx, "login", "MySuperSecretPassword", "")
+	require.NoError(t, err)
+
+	stored, err = repo.FindUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+
+	_, err = s.Login(ctx, "login", "MySuperSecretPassword", "")
+	require.NoError(t, err)
+}
+
+func TestAuthService_LoginUnknown(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute, RefreshTokenValidityDuration: time.Hour}
+	s := NewAuthService(repo, config, auth.NewHMACKeySet(config.SecretKey), &auth.CredentialsPolicy{}, nil, nil, logging.NewLogger())
+
+	// unknown logins are checked against a real hash, so they cost as much as a wrong password
+	hasher, err := s.passwordHasher()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(s.dummyHash, "$argon2id$"))
+	ok, _, err := hasher.Verify("password", s.dummyPasswordHash(hasher))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, err = s.Login(ctx, "unknown", "password", "")
+	require.ErrorIs(t, err, common.ErrorInvalidLoginPassword)
+}
+
+func TestAuthService_Refresh(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", JWTIssuer: "issuer", JWTAudience: "audience", TokenValidityDuration: 1 * time.Minute, RefreshTokenValidityDuration: time.Hour}
+	keys := auth.NewHMACKeySet(config.SecretKey)
+	logger := logging.NewLogger()
+
+	s := &AuthService{
+		repository: repo,
+		config:     config,
+		keys:       keys,
+		policy:     &auth.CredentialsPolicy{},
+		logger:     logger,
+	}
+
+	first, err := s.Register(ctx, "login", "password")
+	require.NoError(t, err)
+	require.NotEmpty(t, first.RefreshToken)
+
+	// rotation
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	userID, err := auth.GetUserIDFromToken(second.AccessToken, keys, NewTokenOptions(config))
+	require.NoError(t, err)
+	require.NotZero(t, userID)
+
+	// role changes reach the next access token
+	user, err := repo.FindUserByLogin(ctx, "login")
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateUserRole(ctx, user.ID, models.RoleAdmin))
+
+	third, err := s.Refresh(ctx, second.RefreshToken)
+	require.NoError(t, err)
+
+	claims, err := auth.ParseToken(third.AccessToken, keys, NewTokenOptions(config))
+	require.NoError(t, err)
+	require.Equal(t, string(models.RoleAdmin), claims.Role)
+
+	// reuse of the rotated token revokes the family
+	_, err = s.Refresh(ctx, first.RefreshToken)
+	require.ErrorIs(t, err, common.ErrorRefreshTokenReused)
+
+	_, err = s.Refresh(ctx, third.RefreshToken)
+	require.ErrorIs(t, err, common.ErrorInvalidToken)
+
+	// other logins are not affected
+	other, err := s.Login(ctx, "login", "password", "")
+	require.NoError(t, err)
+
+	_, err = s.Refresh(ctx, "unknown")
+	require.ErrorIs(t, err, common.ErrorInvalidToken)
+
+	// logout revokes the family
+	require.NoError(t, s.Logout(ctx, other.RefreshToken))
+	_, err = s.Refresh(ctx, other.RefreshToken)
+	require.ErrorIs(t, err, common.ErrorInvalidToken)
+
+	require.NoError(t, s.Logout(ctx, "unknown"))
+}
+
+func TestAuthService_RefreshExpired(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", JWTIssuer: "issuer", JWTAudience: "audience", TokenValidityDuration: 1 * time.Minute, RefreshTokenValidityDuration: time.Millisecond}
+	keys := auth.NewHMACKeySet(config.SecretKey)
+	logger := logging.NewLogger()
+
+	s := &AuthService{
+		repository: repo,
+		config:     config,
+		keys:       keys,
+		policy:     &auth.CredentialsPolicy{},
+		logger:     logger,
+	}
+
+	tokens, err := s.Register(ctx, "login", "password")
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = s.Refresh(ctx, tokens.RefreshToken)
+	require.ErrorIs(t, err, common.ErrorInvalidToken)
+}
+
+func TestAuthService_ChangePassword(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", JWTIssuer: "issuer", JWTAudience: "audience", TokenValidityDuration: 1 * time.Minute,
+		RefreshTokenValidityDuration: time.Hour}
+	keys := auth.NewHMACKeySet(config.SecretKey)
+	logger := logging.NewLogger()
+
+	s := &AuthService{
+		repository: repo,
+		config:     config,
+		keys:       keys,
+		policy:     &auth.CredentialsPolicy{PasswordMinLength: 8},
+		logger:     logger,
+	}
+
+	first, err := s.Register(ctx, "login", "password")
+	require.NoError(t, err)
+	other, err := s.Login(ctx, "login", "password", "")
+	require.NoError(t, err)
+
+	user, err := repo.FindUserByLogin(ctx, "login")
+	require.NoError(t, err)
+
+	_, err = s.ChangePassword(ctx, user.ID, "wrong", "new-password", "")
+	require.ErrorIs(t, err, common.ErrorInvalidLoginPassword)
+
+	_, err = s.ChangePassword(ctx, user.ID, "password", "short", "")
+	require.ErrorIs(t, err, common.ErrorInvalidPasswordFormat)
+
+	tokens, err := s.ChangePassword(ctx, user.ID, "password", "new-password", "")
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.RefreshToken)
+
+	// all other sessions are revoked
+	_, err = s.Refresh(ctx, first.RefreshToken)
+	require.Error(t, err)
+	_, err = s.Refresh(ctx, other.RefreshToken)
+	require.Error(t, err)
+
+	_, err = s.Refresh(ctx, tokens.RefreshToken)
+	require.NoError(t, err)
+
+	_, err = s.Login(ctx, "login", "password", "")
+	require.ErrorIs(t, err, common.ErrorInvalidLoginPassword)
+
+	_, err = s.Login(ctx, "login", "new-password", "")
+	require.NoError(t, err)
+}
+
+// captures the tokens instead of sending them
+type testNotifier struct {
+	tokens []string
+}
+
+func (n *testNotifier) SendPasswordReset(ctx context.Context, login string, token string, expiresAt time.Time) error {
+	n.tokens = append(n.tokens, token)
+	return nil
+}
+
+func TestAuthService_ResetPassword(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", JWTIssuer: "issuer", JWTAudience: "audience", TokenValidityDuration: 1 * time.Minute,
+		RefreshTokenValidityDuration: time.Hour, PasswordResetValidity: time.Hour}
+	keys := auth.NewHMACKeySet(config.SecretKey)
+	logger := logging.NewLogger()
+	notifier := &testNotifier{}
+
+	s := &AuthService{
+		repository: repo,
+		config:     config,
+		keys:       keys,
+		policy:     &auth.CredentialsPolicy{PasswordMinLength: 8},
+		notifier:   notifier,
+		logger:     logger,
+	}
+
+	session, err := s.Register(ctx, "login", "password")
+	require.NoError(t, err)
+
+	// unknown logins are not reported
+	require.NoError(t, s.RequestPasswordReset(ctx, "unknown"))
+	require.Empty(t, notifier.tokens)
+
+	require.NoError(t, s.RequestPasswordReset(ctx, "LOGIN"))
+	require.Len(t, notifier.tokens, 1)
+	token := notifier.tokens[0]
+
+	err = s.ResetPassword(ctx, "unknown", "new-password")
+	require.ErrorIs(t, err, common.ErrorInvalidResetToken)
+
+	// the rejected password does not consume the token
+	err = s.ResetPassword(ctx, token, "short")
+	require.ErrorIs(t, err, common.ErrorInvalidPasswordFormat)
+
+	require.NoError(t, s.ResetPassword(ctx, token, "new-password"))
+
+	err = s.ResetPassword(ctx, token, "other-password")
+	require.ErrorIs(t, err, common.ErrorInvalidResetToken)
+
+	_, err = s.Refresh(ctx, session.RefreshToken)
+	require.Error(t, err)
+
+	_, err = s.Login(ctx, "login", "new-password", "")
+	require.NoError(t, err)
+
+	// expired token
+	config.PasswordResetValidity = time.Millisecond
+	require.NoError(t, s.RequestPasswordReset(ctx, "login"))
+	require.Len(t, notifier.tokens, 2)
+
+	time.Sleep(5 * time.Millisecond)
+
+	err = s.ResetPassword(ctx, notifier.tokens[1], "other-password")
+	require.ErrorIs(t, err, common.ErrorInvalidResetToken)
+}
@@ -8,12 +8,21 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type BalanceService struct {
@@ -21,13 +30,54 @@ type BalanceService struct {
 	repository  repository.Repository
 	config      *config.Config
 	logger      *slog.Logger
+	throttle    accrualThrottle
+	metrics     *metrics.Metrics
+	// identifies this instance as an owner of leased orders
+	instanceID string
 }
 
-func NewBalanceService(r repository.Repository, c *config.Config, l *slog.Logger) *BalanceService {
-	return &BalanceService{repository: r, config: c, baseService: BaseService{}, logger: l.With("task", "process_pending_orders")}
+func NewBalanceService(r repository.Repository, c *config.Config, m *metrics.Metrics, l *slog.Logger) *BalanceService {
+	s := &BalanceService{repository: r, config: c, metrics: m, baseService: BaseService{}, logger: l.With("task", "process_pending_orders"),
+		instanceID: uuid.NewString()}
+	s.throttle.limiter = newAccrualLimiter(c.AccrualRateLimit)
+	return s
 }
 
-func (s *BalanceService) checkOrderStatusInAccrualSystem(ctx context.Context, number string) (*models.AccrualStatusDTO, error) {
+func (s *BalanceService) checkOrderStatusInAccrualSystem(ctx context.Context, number string) (o *models.AccrualStatusDTO, err error) {
+
+	ctx, span := tracing.Tracer().Start(ctx, "accrual.GetOrder", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("order.number", number)))
+	defer tracing.End(span, &err)
+
+	// waiting if the accrual system asked us to slow down
+	if err := s.throttle.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	// time spent waiting is not the accrual system latency
+	start := time.Now()
+	o, err = s.requestAccrualSystem(ctx, number)
+	outcome := accrualOutcome(err)
+	s.metrics.ObserveAccrualRequest(outcome, time.Since(start))
+	span.SetAttributes(attribute.String("accrual.outcome", outcome))
+
+	return o, err
+}
+
+func accrualOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.AccrualOutcomeOK
+	case errors.Is(err, common.ErrorNotFound):
+		return metrics.AccrualOutcomeNotRegistered
+	case errors.Is(err, common.ErrorTooManyRequests):
+		return metrics.AccrualOutcomeThrottled
+	default:
+		return metrics.AccrualOutcomeError
+	}
+}
+
+func (s *BalanceService) requestAccrualSystem(ctx context.Context, number string) (*models.AccrualStatusDTO, error) {
 
 	url := fmt.Sprintf("%s/api/orders/%s", s.config.AccrualSystemAddress, number)
 
@@ -37,6 +87,9 @@ func (s *BalanceService) checkOrderStatusInAccrualSystem(ctx context.Context, nu
 		return nil, err
 	}
 
+	// the accrual system continues our trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
 	// Send the request using the default HTTP client
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -45,8 +98,17 @@ func (s *BalanceService) checkOrderStatusInAccrualSystem(ctx context.Context, nu
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNoContent {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
 		return nil, common.ErrorNotFound
+	case http.StatusTooManyRequests:
+		until := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		s.throttle.pause(until)
+		logging.FromContext(ctx, s.logger).Warn("Accrual system requests paused", "until", until)
+		return nil, common.ErrorTooManyRequests
+	default:
+		return nil, fmt.Errorf("%w: %d", common.ErrorUnexpectedStatusCode, resp.StatusCode)
 	}
 
 	reply, err := io.ReadAll(resp.Body)
@@ -65,130 +127,382 @@ func (s *BalanceService) checkOrderStatusInAccrualSystem(ctx context.Context, nu
 
 }
 
-func (s *BalanceService) processOrder(ctx context.Context, order models.Order) error {
+const (
+	// delay before the first re-check of an order, doubled with every attempt
+	accrualCheckBaseDelay = 3 * time.Second
+	accrualCheckMaxDelay  = 30 * time.Minute
+
+	// orders are leased in batches, so that several instances never check the same order at once
+	accrualBatchSize     = 100
+	accrualLeaseDuration = 5 * time.Minute
+)
+
+// the lease is extended by the time the batch takes at the configured accrual rate,
+// pauses requested by the accrual system may still outlast it, the order updates
+// are then rejected since the order is leased by another instance
+func (s *BalanceService) leaseDuration() time.Duration {
+	if s.config.AccrualRateLimit <= 0 {
+		return accrualLeaseDuration
+	}
+	return accrualLeaseDuration + time.Duration(float64(accrualBatchSize)/s.config.AccrualRateLimit*float64(time.Second))
+}
+
+// calculates delay before the next accrual system check of the order
+func nextCheckDelay(attempt int) time.Duration {
+	delay := accrualCheckBaseDelay
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+		if delay >= accrualCheckMaxDelay {
+			return accrualCheckMaxDelay
+		}
+	}
+	return delay
+}
+
+// postpones the next accrual system check of the order with exponential backoff
+func (s *BalanceService) scheduleNextCheck(ctx context.Context, order models.Order) error {
+	nextCheckAt := time.Now().Add(nextCheckDelay(order.AttemptCount))
+	return s.repository.ScheduleOrderCheck(ctx, order.ID, s.instanceID, nextCheckAt, order.AttemptCount+1)
+}
+
+// order is not checked anymore if accrual system did not give final status for too long
+func (s *BalanceService) orderIsStale(order models.Order) bool {
+	return s.config.AccrualMaxOrderAge > 0 && time.Since(order.UploadedAt) > s.config.AccrualMaxOrderAge
+}
+
+func (s *BalanceService) processOrder(ctx context.Context, order models.Order) (err error) {
+
+	ctx, span := tracing.Start(ctx, "BalanceService.processOrder", attribute.String("order.number", order.Number))
+	defer tracing.End(span, &err)
 
-	logger := s.logger.With("number", order.Number)
+	logger := logging.FromContext(ctx, s.logger).With("number", order.Number)
+
+	if s.orderIsStale(order) {
+		logger.WarnContext(ctx, "Order is stale, marking as invalid", "uploaded_at", order.UploadedAt)
+		return s.repository.UpdateOrderAccrualStatus(ctx, order.ID, s.instanceID, models.OrderStatusInvalid, 0)
+	}
 
 	accrual, err := s.checkOrderStatusInAccrualSystem(ctx, order.Number)
 	if err != nil {
+		// throttled or shutting down, the order stays due and will be checked again when possible
+		if errors.Is(err, common.ErrorTooManyRequests) || ctx.Err() != nil {
+			return err
+		}
+		if scheduleErr := s.scheduleNextCheck(ctx, order); scheduleErr != nil {
+			return errors.Join(err, scheduleErr)
+		}
 		return err
 	}
 
 	logger.InfoContext(ctx, "Status received", "status", accrual.Status)
 
 	var newStatus models.OrderStatus
-	var accrualAmount float32
+	var accrualAmount models.Money
 
 	switch accrual.Status {
-	case models.AccrualStatusProcessing:
+	case models.AccrualStatusRegistered, models.AccrualStatusProcessing:
 		newStatus = models.OrderStatusProcessing
 	case models.AccrualStatusProcessed:
 		newStatus = models.OrderStatusProcessed
 		accrualAmount = accrual.Accrual
 	case models.AccrualStatusInvalid:
 		newStatus = models.OrderStatusInvalid
+	default:
+		if err := s.scheduleNextCheck(ctx, order); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", common.ErrorUnexpectedAccrualStatus, accrual.Status)
 	}
 
 	logger.InfoContext(ctx, "Udating status", "status", newStatus)
 
-	tx, err := s.repository.UnitOfWork().Begin(ctx)
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer s.baseService.EndTransaction(tx, &err)
 
-	err = s.repository.UpdateOrderAccrualStatus(ctx, order.ID, newStatus, accrualAmount)
+	// locking the user, so that concurrent balance changes are serialized
+	_, err = s.repository.FindUserByIDForUpdate(ctx, order.UserID)
+	if err != nil {
+		return err
+	}
+
+	// the order could have been leased by another instance if the lease expired meanwhile,
+	// the transaction is then rolled back so that the accrual is not credited twice
+	err = s.repository.UpdateOrderAccrualStatus(ctx, order.ID, s.instanceID, newStatus, accrualAmount)
 
 	if err != nil {
 		return err
 	}
 
-	return s.recalculateAccruals(ctx, order.UserID)
-}
+	// status is not final yet, checking again later
+	if newStatus == models.OrderStatusProcessing {
+		return s.scheduleNextCheck(ctx, order)
+	}
 
-func (s *BalanceService) recalculateAccruals(ctx context.Context, userID string) error {
+	if newStatus != models.OrderStatusProcessed || accrualAmount <= 0 {
+		return nil
+	}
 
-	totalAccrued, err := s.repository.GetAccrualsTotalAmountByUserID(ctx, userID)
+	// crediting the accrual to the user balance
+	entry := &models.LedgerEntry{UserID: order.UserID, Type: models.LedgerEntryAccrual, Amount: accrualAmount, OrderID: order.ID}
+	err = s.repository.AddLedgerEntry(ctx, entry)
 	if err != nil {
 		return err
 	}
-	s.logger.With("user_id", userID).InfoContext(ctx, "Updating accrued total", "amount", totalAccrued)
 
-	return s.repository.UpdateUserAccruedTotal(ctx, userID, totalAccrued)
+	logger.InfoContext(ctx, "Accrual credited", "amount", accrualAmount, "balance", entry.Balance)
 
+	return nil
 }
 
-func (s *BalanceService) ProcessPendingOrders(ctx context.Context) error {
+// processes single order, errors are logged and never leave the worker
+// so that one failing order does not affect the rest of the batch
+func (s *BalanceService) processPendingOrder(ctx context.Context, o models.Order) {
 
-	orders, err := s.repository.GetUnprocessedOrders(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx, s.logger).Error("Panic processing order", "number", o.Number, "panic", p)
+		}
+	}()
+
+	// releasing the lease even when shutting down, so that another instance may pick the order up
+	defer func() {
+		if err := s.repository.ReleaseOrderLease(context.WithoutCancel(ctx), o.ID, s.instanceID); err != nil {
+			logging.FromContext(ctx, s.logger).Error("Error releasing order lease", "number", o.Number, "err", err)
+		}
+	}()
+
+	err := s.processOrder(ctx, o)
 	if err != nil {
-		s.logger.ErrorContext(ctx, "Error selecting orders", "err", err.Error())
-		return err
+
+		if errors.Is(err, common.ErrorNotFound) {
+			logging.FromContext(ctx, s.logger).Info("Order not registered in accrual system yet", "number", o.Number)
+		} else if errors.Is(err, common.ErrorTooManyRequests) {
+			logging.FromContext(ctx, s.logger).Info("Accrual system is throttling requests", "number", o.Number)
+		} else if errors.Is(err, common.ErrorOrderLeaseLost) {
+			logging.FromContext(ctx, s.logger).Warn("Order lease expired, the order is left to another instance", "number", o.Number)
+		} else {
+			logging.FromContext(ctx, s.logger).Error("Error processig order", "number", o.Number, "err", err)
+		}
 	}
+}
 
-	for _, o := range orders {
+// orders already handed to the workers are finished when ctx is cancelled, so that the accrual
+// request and the transaction of an order are not cut off, but no longer than the shutdown timeout
+func (s *BalanceService) orderContext(ctx context.Context) (context.Context, context.CancelFunc) {
 
-		err := s.processOrder(ctx, o)
-		if err != nil {
+	orderCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	// the timer is started by the AfterFunc goroutine, so it is guarded
+	var mu sync.Mutex
+	var timer *time.Timer
+	done := false
+
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !done {
+			timer = time.AfterFunc(s.config.ShutdownTimeout, cancel)
+		}
+	})
+
+	return orderCtx, func() {
+		stop()
+		mu.Lock()
+		done = true
+		if timer != nil {
+			timer.Stop()
+		}
+		mu.Unlock()
+		cancel()
+	}
+}
+
+// leases the next batch of due orders and checks the ones not tried in this cycle yet,
+// returns the size of the batch and the number of orders checked
+func (s *BalanceService) processPendingBatch(ctx context.Context, tried map[string]struct{}) (int, int, error) {
+
+	leased, err := s.repository.LeaseUnprocessedOrders(ctx, s.instanceID, time.Now(), s.leaseDuration(), accrualBatchSize)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Error("Error selecting orders", "err", err.Error())
+		return 0, 0, err
+	}
 
-			if errors.Is(err, common.ErrorNotFound) {
-				s.logger.InfoContext(ctx, "Order not registered in accrual system yet", "number", o.Number)
-			} else {
-				s.logger.ErrorContext(ctx, "Error processig order", "number", o.Number, "err", err)
+	// orders which were throttled or failed without being rescheduled are still due,
+	// they are given back rather than checked again in the same cycle
+	var orders, seen []models.Order
+	for _, o := range leased {
+		if _, ok := tried[o.ID]; ok {
+			seen = append(seen, o)
+			continue
+		}
+		tried[o.ID] = struct{}{}
+		orders = append(orders, o)
+	}
+	s.releaseOrderLeases(ctx, seen)
+
+	if len(orders) == 0 {
+		return len(leased), 0, nil
+	}
+
+	workers := s.config.AccrualWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(orders) {
+		workers = len(orders)
+	}
+
+	jobs := make(chan models.Order)
+
+	orderCtx, cancel := s.orderContext(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				s.processPendingOrder(orderCtx, o)
 			}
+		}()
+	}
+
+feed:
+	for i, o := range orders {
+		// select picks at random when both are ready, no new orders are started once shutting down
+		if ctx.Err() != nil {
+			s.releaseOrderLeases(ctx, orders[i:])
+			break
+		}
+		select {
+		case jobs <- o:
+		case <-ctx.Done():
+			s.releaseOrderLeases(ctx, orders[i:])
+			break feed
 		}
 	}
 
-	return nil
+	close(jobs)
+	wg.Wait()
 
+	return len(leased), len(orders), nil
+}
+
+// gives the leased orders back without checking them, so that another instance may pick them up
+func (s *BalanceService) releaseOrderLeases(ctx context.Context, orders []models.Order) {
+	for _, o := range orders {
+		if err := s.repository.ReleaseOrderLease(context.WithoutCancel(ctx), o.ID, s.instanceID); err != nil {
+			logging.FromContext(ctx, s.logger).Error("Error releasing order lease", "number", o.Number, "err", err)
+		}
+	}
 }
 
-func (s *BalanceService) GetUserBalance(ctx context.Context, userID string) (*models.BalanceDTO, error) {
-	user, err := s.repository.FindUserByID(ctx, userID)
+// ProcessPendingOrders checks due orders until there are none left or ctx is cancelled,
+// the orders being checked when ctx is cancelled are finished within the shutdown timeout
+func (s *BalanceService) ProcessPendingOrders(ctx context.Context) (err error) {
+
+	ctx, span := tracing.Start(ctx, "BalanceService.ProcessPendingOrders")
+	defer tracing.End(span, &err)
+
+	start := time.Now()
+	defer func() {
+		s.metrics.ObservePollCycle(time.Since(start))
+	}()
+
+	// queue depth is only reported, so an error here does not stop the processing
+	pending, err := s.repository.CountUnprocessedOrders(ctx, start)
 	if err != nil {
-		s.logger.ErrorContext(ctx, "Error finding user", "id", userID, "err", err.Error())
-		return nil, err
+		logging.FromContext(ctx, s.logger).Warn("Error counting pending orders", "err", err.Error())
+	} else {
+		s.metrics.SetPendingOrders(pending)
+	}
+
+	// full batch means there could be more due orders waiting,
+	// unless all of them were already tried in this cycle
+	tried := make(map[string]struct{})
+	for {
+		n, checked, err := s.processPendingBatch(ctx, tried)
+		if err != nil {
+			return err
+		}
+		if n < accrualBatchSize || checked == 0 || ctx.Err() != nil {
+			return nil
+		}
 	}
 
-	return &models.BalanceDTO{Current: user.AccruedTotal - user.WithdrawnTotal, Withdrawn: user.WithdrawnTotal}, nil
 }
 
-func (s *BalanceService) recalculateWithdrawals(ctx context.Context, userID string) error {
+func (s *BalanceService) GetUserBalance(ctx context.Context, userID string) (result *models.BalanceDTO, err error) {
 
-	totalWithdrawn, err := s.repository.GetWithdrawalsTotalAmountByUserID(ctx, userID)
+	ctx, span := tracing.Start(ctx, "BalanceService.GetUserBalance")
+	defer tracing.End(span, &err)
+	balance, err := s.repository.GetUserBalance(ctx, userID)
 	if err != nil {
-		return err
+		logging.FromContext(ctx, s.logger).Error("Error getting balance", "id", userID, "err", err.Error())
+		return nil, err
 	}
 
-	s.logger.With("user_id", userID).InfoContext(ctx, "Updating withdrawn total", "amount", totalWithdrawn)
+	return &models.BalanceDTO{Current: balance.Current, Withdrawn: balance.Withdrawn}, nil
+}
 
-	return s.repository.UpdateUserWithdrawnTotal(ctx, userID, totalWithdrawn)
+func (s *BalanceService) Withdraw(ctx context.Context, userID string, request *models.WithdrawalRequestDTO) (err error) {
 
-}
+	ctx, span := tracing.Start(ctx, "BalanceService.Withdraw")
+	defer tracing.End(span, &err)
+
+	correct, err := common.CheckOrderNumberFormat(request.Order)
+	if err != nil || !correct {
+		logging.FromContext(ctx, s.logger).Error("Invalid order number", "number", request.Order)
+		return common.ErrorInvalidOrderNumberFormat
+	}
 
-func (s *BalanceService) Withdraw(ctx context.Context, userID string, request *models.WithdrawalRequestDTO) error {
+	// withdrawal is counted once it is committed, retries are not counted
+	made := false
+	defer func() {
+		if err == nil && made {
+			s.metrics.ObserveWithdrawal(request.Sum)
+		}
+	}()
 
-	tx, err := s.repository.UnitOfWork().Begin(ctx)
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer s.baseService.EndTransaction(tx, &err)
 
-	correct, err := common.CheckOrderNumberFormat(request.Order)
-	if err != nil || !correct {
-		s.logger.ErrorContext(ctx, "Invalid order number", "number", request.Order)
-		return common.ErrorInvalidOrderNumberFormat
+	// checking the balance, the user stays locked until the withdrawal is committed
+	_, err = s.repository.FindUserByIDForUpdate(ctx, userID)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Error("Error finding user", "id", userID, "err", err.Error())
+		return err
+	}
+
+	// retry of a withdrawal that has already been made is not debited again,
+	// the order of a reversed withdrawal is cancelled, so it can not be paid again
+	existing, err := s.repository.FindWithdrawalByOrder(ctx, request.Order)
+	if err == nil {
+		if existing.UserID == userID && existing.Amount == request.Sum && existing.ReversedAt.IsZero() {
+			logging.FromContext(ctx, s.logger).Info("Withdrawal already made", "id", userID, "number", request.Order)
+			return nil
+		}
+		logging.FromContext(ctx, s.logger).Error("Withdrawal for the order already exists", "id", userID, "number", request.Order)
+		return common.ErrorWithdrawalAlreadyExists
+	}
+	if !errors.Is(err, common.ErrorNotFound) {
+		return err
 	}
 
-	// checking the balance
-	user, err := s.repository.FindUserByID(ctx, userID)
+	balance, err := s.repository.GetUserBalance(ctx, userID)
 	if err != nil {
-		s.logger.ErrorContext(ctx, "Error finding user", "id", userID, "err", err.Error())
 		return err
 	}
 
-	if user.AccruedTotal-user.WithdrawnTotal-request.Sum < 0 {
-		s.logger.ErrorContext(ctx, "Insufficient balance", "id", userID)
+	if balance.Current-request.Sum < 0 {
+		logging.FromContext(ctx, s.logger).Error("Insufficient balance", "id", userID)
 		return common.ErrorInsufficientBalance
 	}
 
@@ -198,26 +512,120 @@ func (s *BalanceService) Withdraw(ctx context.Context, userID string, request *m
 	err = s.repository.AddWithdrawal(ctx, w)
 
 	if err != nil {
-		s.logger.ErrorContext(ctx, "Error saving withdrawal", "id", userID, "err", err.Error())
+		logging.FromContext(ctx, s.logger).Error("Error saving withdrawal", "id", userID, "err", err.Error())
+		// another user has just made a withdrawal for the same order
+		if errors.Is(err, common.ErrorAlreadyExists) {
+			return common.ErrorWithdrawalAlreadyExists
+		}
 		return err
 	}
 
-	s.logger.With("user_id", userID).Info("Saved withdrawal", "amount", request.Sum)
+	// debiting the user balance
+	entry := &models.LedgerEntry{UserID: userID, Type: models.LedgerEntryWithdrawal, Amount: -request.Sum, WithdrawalID: w.ID}
+	err = s.repository.AddLedgerEntry(ctx, entry)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Error("Error saving ledger entry", "id", userID, "err", err.Error())
+		return err
+	}
+
+	logging.FromContext(ctx, s.logger).Info("Saved withdrawal", "id", userID, "amount", request.Sum, "balance", entry.Balance)
+	made = true
+
+	return nil
+
+}
+
+// GetWithdrawals returns a page of user withdrawals and the cursor of the next page,
+// which is nil if there are no more withdrawals or the filter has no limit
+func (s *BalanceService) GetWithdrawals(ctx context.Context, userID string, filter models.ListFilter) (result []*models.WithdrawalDTO, next *models.Cursor, err error) {
+
+	ctx, span := tracing.Start(ctx, "BalanceService.GetWithdrawals")
+	defer tracing.End(span, &err)
+
+	// fetching one more withdrawal to know if there is the next page
+	pageSize := filter.Limit
+	if pageSize > 0 {
+		filter.Limit++
+	}
+
+	withdrawals, err := s.repository.GetWithdrawalsByUserID(ctx, userID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pageSize > 0 && len(withdrawals) > pageSize {
+		withdrawals = withdrawals[:pageSize]
+		last := withdrawals[pageSize-1]
+		next = &models.Cursor{UploadedAt: last.UploadedAt, ID: last.ID}
+	}
+
+	for _, w := range withdrawals {
+		result = append(result, newWithdrawalDTO(w))
+	}
 
-	return s.recalculateWithdrawals(ctx, userID)
+	return result, next, nil
+}
 
+func newWithdrawalDTO(w models.Withdrawal) *models.WithdrawalDTO {
+	dto := &models.WithdrawalDTO{Order: w.Order, Sum: w.Amount, ProcessedAt: w.UploadedAt}
+	if !w.ReversedAt.IsZero() {
+		dto.ReversedAt = &w.ReversedAt
+	}
+	return dto
 }
 
-func (s *BalanceService) GetWithdrawals(ctx context.Context, userID string) ([]*models.WithdrawalDTO, error) {
-	withdrawals, err := s.repository.GetWithdrawalsByUserID(ctx, userID)
+// ReverseWithdrawal returns the points of the withdrawal made for the order, e.g. when the order is cancelled.
+// The withdrawal is kept and marked reversed, reversing it again returns the same result
+func (s *BalanceService) ReverseWithdrawal(ctx context.Context, operatorID string, order string) (result *models.WithdrawalDTO, err error) {
+
+	ctx, span := tracing.Start(ctx, "BalanceService.ReverseWithdrawal")
+	defer tracing.End(span, &err)
+
+	withdrawal, err := s.repository.FindWithdrawalByOrder(ctx, order)
 	if err != nil {
 		return nil, err
 	}
-	var result []*models.WithdrawalDTO
 
-	for _, w := range withdrawals {
-		result = append(result, &models.WithdrawalDTO{Order: w.Order, Sum: w.Amount, ProcessedAt: w.UploadedAt})
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer s.baseService.EndTransaction(tx, &err)
+
+	// locking the user, so that the same withdrawal is not reversed concurrently
+	_, err = s.repository.FindUserByIDForUpdate(ctx, withdrawal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawal, err = s.repository.FindWithdrawalByOrder(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	if !withdrawal.ReversedAt.IsZero() {
+		logging.FromContext(ctx, s.logger).Info("Withdrawal already reversed", "id", withdrawal.UserID, "number", order)
+		return newWithdrawalDTO(withdrawal), nil
+	}
+
+	withdrawal.ReversedAt = time.Now().Truncate(time.Second)
+	withdrawal.ReversedBy = operatorID
+
+	err = s.repository.MarkWithdrawalReversed(ctx, withdrawal.ID, withdrawal.ReversedAt, operatorID)
+	if err != nil {
+		return nil, err
+	}
+
+	// crediting the points back
+	entry := &models.LedgerEntry{UserID: withdrawal.UserID, Type: models.LedgerEntryReversal, Amount: withdrawal.Amount, WithdrawalID: withdrawal.ID}
+	err = s.repository.AddLedgerEntry(ctx, entry)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Error("Error saving ledger entry", "id", withdrawal.UserID, "err", err.Error())
+		return nil, err
+	}
+
+	logging.FromContext(ctx, s.logger).Info("Reversed withdrawal", "id", withdrawal.UserID, "number", order, "operator_id", operatorID,
+		"amount", withdrawal.Amount, "balance", entry.Balance)
 
-	return result, nil
+	return newWithdrawalDTO(withdrawal), nil
 }
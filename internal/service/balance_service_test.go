@@ -2,16 +2,31 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestBalanceService(t *testing.T) {
@@ -31,13 +46,17 @@ func TestBalanceService(t *testing.T) {
 	}
 
 	// setting up data
-	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password", AccruedTotal: 5, WithdrawnTotal: 3})
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
 	require.NoError(t, err)
 	require.NotZero(t, user.ID)
+	creditUser(t, repo, user.ID, models.NewMoney(5))
+	debitUser(t, repo, user.ID, models.NewMoney(3))
 
-	user2, err := repo.AddUser(ctx, &models.User{Login: "login2", Password: "password2", AccruedTotal: 8, WithdrawnTotal: 2})
+	user2, err := repo.AddUser(ctx, &models.User{Login: "login2", Password: "password2"})
 	require.NoError(t, err)
 	require.NotZero(t, user.ID)
+	creditUser(t, repo, user2.ID, models.NewMoney(8))
+	debitUser(t, repo, user2.ID, models.NewMoney(2))
 
 	type args struct {
 		userID string
@@ -48,8 +67,8 @@ func TestBalanceService(t *testing.T) {
 		want    *models.BalanceDTO
 		wantErr bool
 	}{
-		{"User1", args{user.ID}, &models.BalanceDTO{Withdrawn: user.WithdrawnTotal, Current: user.AccruedTotal - user.WithdrawnTotal}, false},
-		{"User2", args{user2.ID}, &models.BalanceDTO{Withdrawn: user2.WithdrawnTotal, Current: user2.AccruedTotal - user2.WithdrawnTotal}, false},
+		{"User1", args{user.ID}, &models.BalanceDTO{Withdrawn: models.NewMoney(3), Current: models.NewMoney(2)}, false},
+		{"User2", args{user2.ID}, &models.BalanceDTO{Withdrawn: models.NewMoney(2), Current: models.NewMoney(6)}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -81,9 +100,11 @@ func TestBalanceService_Withdraw(t *testing.T) {
 	}
 
 	// setting up data
-	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password", AccruedTotal: 5, WithdrawnTotal: 3})
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
 	require.NoError(t, err)
 	require.NotZero(t, user.ID)
+	creditUser(t, repo, user.ID, models.NewMoney(5))
+	debitUser(t, repo, user.ID, models.NewMoney(3))
 
 	type args struct {
 		userID  string
@@ -94,9 +115,9 @@ func TestBalanceService_Withdraw(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		{"OK", args{user.ID, &models.WithdrawalRequestDTO{Order: "4561261212345467", Sum: float32(1)}}, false},
-		{"Wrong format", args{user.ID, &models.WithdrawalRequestDTO{Order: "123", Sum: float32(1)}}, true},
-		{"Insufficent balance", args{user.ID, &models.WithdrawalRequestDTO{Order: "4561261212345467", Sum: float32(10)}}, true},
+		{"OK", args{user.ID, &models.WithdrawalRequestDTO{Order: "4561261212345467", Sum: models.NewMoney(1)}}, false},
+		{"Wrong format", args{user.ID, &models.WithdrawalRequestDTO{Order: "123", Sum: models.NewMoney(1)}}, true},
+		{"Insufficent balance", args{user.ID, &models.WithdrawalRequestDTO{Order: "79927398713", Sum: models.NewMoney(10)}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -107,6 +128,105 @@ func TestBalanceService_Withdraw(t *testing.T) {
 	}
 }
 
+func TestBalanceService_WithdrawRetry(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute}
+	logger := logging.NewLogger()
+
+	s := &BalanceService{
+		repository: repo,
+		config:     config,
+		logger:     logger,
+	}
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+	creditUser(t, repo, user.ID, models.NewMoney(5))
+
+	user2, err := repo.AddUser(ctx, &models.User{Login: "login2", Password: "password2"})
+	require.NoError(t, err)
+	creditUser(t, repo, user2.ID, models.NewMoney(5))
+
+	request := &models.WithdrawalRequestDTO{Order: "4561261212345467", Sum: models.NewMoney(2)}
+
+	err = s.Withdraw(ctx, user.ID, request)
+	require.NoError(t, err)
+
+	// retry succeeds without debiting again
+	err = s.Withdraw(ctx, user.ID, request)
+	require.NoError(t, err)
+
+	balance, err := s.GetUserBalance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, &models.BalanceDTO{Current: models.NewMoney(3), Withdrawn: models.NewMoney(2)}, balance)
+
+	// different withdrawal for the same order is a conflict
+	err = s.Withdraw(ctx, user.ID, &models.WithdrawalRequestDTO{Order: request.Order, Sum: models.NewMoney(1)})
+	require.ErrorIs(t, err, common.ErrorWithdrawalAlreadyExists)
+
+	err = s.Withdraw(ctx, user2.ID, request)
+	require.ErrorIs(t, err, common.ErrorWithdrawalAlreadyExists)
+
+	balance, err = s.GetUserBalance(ctx, user2.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.NewMoney(5), balance.Current)
+}
+
+func TestBalanceService_ReverseWithdrawal(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	s := &BalanceService{
+		repository: repo,
+		config:     &config.Config{},
+		logger:     logging.NewLogger(),
+	}
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+	creditUser(t, repo, user.ID, models.NewMoney(5))
+
+	request := &models.WithdrawalRequestDTO{Order: "4561261212345467", Sum: models.NewMoney(2)}
+	require.NoError(t, s.Withdraw(ctx, user.ID, request))
+
+	_, err = s.ReverseWithdrawal(ctx, "operator", "79927398713")
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	reversed, err := s.ReverseWithdrawal(ctx, "operator", request.Order)
+	require.NoError(t, err)
+	require.NotNil(t, reversed.ReversedAt)
+
+	// reversing again returns the points only once
+	again, err := s.ReverseWithdrawal(ctx, "operator", request.Order)
+	require.NoError(t, err)
+	require.Equal(t, reversed, again)
+
+	balance, err := s.GetUserBalance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, &models.BalanceDTO{Current: models.NewMoney(5)}, balance)
+
+	// the reversed withdrawal stays in the history
+	withdrawals, _, err := s.GetWithdrawals(ctx, user.ID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	require.Equal(t, reversed.ReversedAt, withdrawals[0].ReversedAt)
+
+	// the cancelled order can not be paid again
+	err = s.Withdraw(ctx, user.ID, request)
+	require.ErrorIs(t, err, common.ErrorWithdrawalAlreadyExists)
+
+	entries, err := repo.GetLedgerEntriesByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, models.LedgerEntryReversal, entries[2].Type)
+}
+
 func TestBalanceService_GetWithdrawals(t *testing.T) {
 	ctx := context.Background()
 
@@ -127,35 +247,563 @@ func TestBalanceService_GetWithdrawals(t *testing.T) {
 	require.NoError(t, err)
 	require.NotZero(t, user.ID)
 
-	err = repo.AddWithdrawal(ctx, &models.Withdrawal{UserID: user.ID, Amount: 1, Order: "123"})
+	now := time.Now().Truncate(time.Second)
+
+	err = repo.AddWithdrawal(ctx, &models.Withdrawal{UserID: user.ID, Amount: models.NewMoney(1), Order: "123", UploadedAt: now})
 	require.NoError(t, err)
 
-	err = repo.AddWithdrawal(ctx, &models.Withdrawal{UserID: user.ID, Amount: 2, Order: "345"})
+	err = repo.AddWithdrawal(ctx, &models.Withdrawal{UserID: user.ID, Amount: models.NewMoney(2), Order: "345", UploadedAt: now.Add(-time.Minute)})
 	require.NoError(t, err)
 
-	x1 := models.WithdrawalDTO{Order: "123", Sum: 1}
-	x2 := models.WithdrawalDTO{Order: "345", Sum: 2}
+	x1 := models.WithdrawalDTO{Order: "123", Sum: models.NewMoney(1), ProcessedAt: now}
+	x2 := models.WithdrawalDTO{Order: "345", Sum: models.NewMoney(2), ProcessedAt: now.Add(-time.Minute)}
 
 	type args struct {
 		userID string
+		filter models.ListFilter
 	}
 	tests := []struct {
-		name    string
-		args    args
-		want    []*models.WithdrawalDTO
-		wantErr bool
+		name     string
+		args     args
+		want     []*models.WithdrawalDTO
+		wantNext bool
+		wantErr  bool
 	}{
-		{"OK", args{user.ID}, []*models.WithdrawalDTO{&x1, &x2}, false},
+		{"OK", args{user.ID, models.ListFilter{}}, []*models.WithdrawalDTO{&x1, &x2}, false, false},
+		{"First page", args{user.ID, models.ListFilter{Limit: 1}}, []*models.WithdrawalDTO{&x1}, true, false},
+		{"Ascending", args{user.ID, models.ListFilter{Ascending: true}}, []*models.WithdrawalDTO{&x2, &x1}, false, false},
+		{"From", args{user.ID, models.ListFilter{From: now}}, []*models.WithdrawalDTO{&x1}, false, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got, err := s.GetWithdrawals(ctx, tt.args.userID)
+			got, next, err := s.GetWithdrawals(ctx, tt.args.userID, tt.args.filter)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("BalanceService.GetWithdrawals() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			require.Equal(t, tt.wantNext, next != nil)
 			require.True(t, cmp.Equal(got, tt.want, cmp.AllowUnexported(models.WithdrawalDTO{})))
 		})
 	}
 }
+
+func TestBalanceService_checkOrderStatusInAccrualSystem(t *testing.T) {
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "No more than N requests per minute allowed", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"4561261212345467","status":"PROCESSED","accrual":500}`))
+	}))
+	defer accrual.Close()
+
+	config := &config.Config{AccrualSystemAddress: accrual.URL}
+	logger := logging.NewLogger()
+
+	m := metrics.New()
+	s := &BalanceService{
+		config:  config,
+		logger:  logger,
+		metrics: m,
+	}
+
+	_, err := s.checkOrderStatusInAccrualSystem(ctx, "4561261212345467")
+	require.ErrorIs(t, err, common.ErrorTooManyRequests)
+
+	// next call should wait until Retry-After deadline is over
+	start := time.Now()
+	got, err := s.checkOrderStatusInAccrualSystem(ctx, "4561261212345467")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	require.Equal(t, models.AccrualStatusProcessed, got.Status)
+	require.Equal(t, int32(2), calls.Load())
+
+	// time spent waiting for the throttle is not counted as the accrual system latency
+	count, err := testutil.GatherAndCount(m.Registry(), "gophermart_accrual_request_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
+func TestBalanceService_checkOrderStatusInAccrualSystemTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrual.Close()
+
+	s := &BalanceService{
+		config: &config.Config{AccrualSystemAddress: accrual.URL},
+		logger: logging.NewLogger(),
+	}
+
+	_, err := s.checkOrderStatusInAccrualSystem(context.Background(), "4561261212345467")
+	require.ErrorIs(t, err, common.ErrorNotFound)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+
+	// the accrual system gets the context of the client span
+	sc := spans[0].SpanContext()
+	require.Equal(t, fmt.Sprintf("00-%s-%s-01", sc.TraceID(), sc.SpanID()), traceparent)
+	// order not registered in the accrual system is not a failure
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+}
+
+func TestBalanceService_ProcessPendingOrders(t *testing.T) {
+	ctx := context.Background()
+
+	var inFlight, maxInFlight atomic.Int32
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"PROCESSED","accrual":10}`))
+	}))
+	defer accrual.Close()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{AccrualSystemAddress: accrual.URL, AccrualWorkers: 4}
+	logger := logging.NewLogger()
+
+	s := &BalanceService{
+		repository: repo,
+		config:     config,
+		logger:     logger,
+	}
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		_, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: fmt.Sprintf("%d", i), Status: models.OrderStatusNew})
+		require.NoError(t, err)
+	}
+
+	err = s.ProcessPendingOrders(ctx)
+	require.NoError(t, err)
+
+	pending, err := repo.CountUnprocessedOrders(ctx, time.Now())
+	require.NoError(t, err)
+	require.Zero(t, pending)
+
+	require.LessOrEqual(t, maxInFlight.Load(), int32(4))
+	require.Greater(t, maxInFlight.Load(), int32(1))
+
+	balance, err := s.GetUserBalance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.NewMoney(200), balance.Current)
+}
+
+func TestBalanceService_ProcessPendingOrdersShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"PROCESSED","accrual":10}`))
+	}))
+	defer accrual.Close()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{AccrualSystemAddress: accrual.URL, AccrualWorkers: 2, ShutdownTimeout: time.Minute}
+	s := NewBalanceService(repo, config, nil, logging.NewLogger())
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: fmt.Sprintf("%d", i), Status: models.OrderStatusNew})
+		require.NoError(t, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.ProcessPendingOrders(ctx)
+	}()
+
+	// shutting down while both workers wait for the accrual system
+	<-started
+	<-started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	require.NoError(t, <-done)
+
+	// the orders being checked are finished, the rest of the batch is given back
+	balance, err := s.GetUserBalance(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.NewMoney(20), balance.Current)
+
+	leased, err := repo.LeaseUnprocessedOrders(context.Background(), "another", time.Now(), time.Minute, 100)
+	require.NoError(t, err)
+	require.Len(t, leased, 8)
+}
+
+func TestBalanceService_orderContext(t *testing.T) {
+	s := NewBalanceService(nil, &config.Config{ShutdownTimeout: 20 * time.Millisecond}, nil, logging.NewLogger())
+
+	// order is cut off once the shutdown timeout passes
+	ctx, cancel := context.WithCancel(context.Background())
+	orderCtx, orderCancel := s.orderContext(ctx)
+	defer orderCancel()
+
+	cancel()
+	require.NoError(t, orderCtx.Err())
+	<-orderCtx.Done()
+
+	// finished order cancels its context right away
+	ctx, cancel = context.WithCancel(context.Background())
+	orderCtx, orderCancel = s.orderContext(ctx)
+	cancel()
+	orderCancel()
+	require.ErrorIs(t, orderCtx.Err(), context.Canceled)
+}
+
+func TestBalanceService_ProcessPendingOrdersThrottled(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer accrual.Close()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{AccrualSystemAddress: accrual.URL, AccrualWorkers: 4}
+	s := NewBalanceService(repo, config, nil, logging.NewLogger())
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+
+	for i := 0; i < accrualBatchSize+5; i++ {
+		_, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: fmt.Sprintf("%d", i), Status: models.OrderStatusNew})
+		require.NoError(t, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.ProcessPendingOrders(ctx)
+	}()
+
+	// throttled orders stay due, but are not leased again in the same cycle
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll cycle did not end")
+	}
+	require.LessOrEqual(t, requests.Load(), int32(accrualBatchSize+5))
+
+	pending, err := repo.CountUnprocessedOrders(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, accrualBatchSize+5, pending)
+}
+
+func Test_nextCheckDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{"First", 0, 3 * time.Second},
+		{"Second", 1, 6 * time.Second},
+		{"Fifth", 4, 48 * time.Second},
+		{"Capped", 20, accrualCheckMaxDelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextCheckDelay(tt.attempt); got != tt.want {
+				t.Errorf("nextCheckDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalanceService_processOrder(t *testing.T) {
+	ctx := context.Background()
+
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrual.Close()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{AccrualSystemAddress: accrual.URL, AccrualMaxOrderAge: 24 * time.Hour}
+	logger := logging.NewLogger()
+
+	s := &BalanceService{
+		repository: repo,
+		config:     config,
+		logger:     logger,
+		instanceID: "instance",
+	}
+
+	fresh, err := repo.AddOrder(ctx, &models.Order{Number: "4561261212345467", Status: models.OrderStatusNew,
+		UploadedAt: time.Now(), AttemptCount: 2})
+	require.NoError(t, err)
+
+	stale, err := repo.AddOrder(ctx, &models.Order{Number: "374245455400126", Status: models.OrderStatusNew,
+		UploadedAt: time.Now().Add(-48 * time.Hour)})
+	require.NoError(t, err)
+
+	leased, err := repo.LeaseUnprocessedOrders(ctx, s.instanceID, time.Now(), time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, leased, 2)
+
+	// the order leased by another instance is not touched
+	taken, err := repo.AddOrder(ctx, &models.Order{Number: "5425233430109903", Status: models.OrderStatusNew,
+		UploadedAt: time.Now().Add(-48 * time.Hour)})
+	require.NoError(t, err)
+	_, err = repo.LeaseUnprocessedOrders(ctx, "another", time.Now(), time.Minute, 10)
+	require.NoError(t, err)
+
+	t.Run("Not registered", func(t *testing.T) {
+		err := s.processOrder(ctx, fresh)
+		require.ErrorIs(t, err, common.ErrorNotFound)
+
+		o, err := repo.FindOrderByNumber(ctx, fresh.Number)
+		require.NoError(t, err)
+		require.Equal(t, 3, o.AttemptCount)
+		require.True(t, o.NextCheckAt.After(time.Now().Add(10*time.Second)))
+		require.Equal(t, models.OrderStatusNew, o.Status)
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		err := s.processOrder(ctx, stale)
+		require.NoError(t, err)
+
+		o, err := repo.FindOrderByNumber(ctx, stale.Number)
+		require.NoError(t, err)
+		require.Equal(t, models.OrderStatusInvalid, o.Status)
+	})
+
+	t.Run("Lease lost", func(t *testing.T) {
+		err := s.processOrder(ctx, taken)
+		require.ErrorIs(t, err, common.ErrorOrderLeaseLost)
+
+		o, err := repo.FindOrderByNumber(ctx, taken.Number)
+		require.NoError(t, err)
+		require.Equal(t, models.OrderStatusNew, o.Status)
+	})
+
+	t.Run("Only the order of another instance is due", func(t *testing.T) {
+		pending, err := repo.CountUnprocessedOrders(ctx, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, 1, pending)
+	})
+}
+
+func TestBalanceService_leaseDuration(t *testing.T) {
+	s := NewBalanceService(nil, &config.Config{}, nil, logging.NewLogger())
+	require.Equal(t, accrualLeaseDuration, s.leaseDuration())
+
+	// the batch of 100 orders takes 1000 seconds at 0.1 requests per second
+	s = NewBalanceService(nil, &config.Config{AccrualRateLimit: 0.1}, nil, logging.NewLogger())
+	require.Equal(t, accrualLeaseDuration+1000*time.Second, s.leaseDuration())
+}
+
+func TestBalanceService_ProcessPendingOrdersMultipleInstances(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	checks := map[string]int{}
+
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		checks[r.URL.Path]++
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"PROCESSED","accrual":10}`))
+	}))
+	defer accrual.Close()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{AccrualSystemAddress: accrual.URL, AccrualWorkers: 4}
+	logger := logging.NewLogger()
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		_, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: fmt.Sprintf("%d", i), Status: models.OrderStatusNew})
+		require.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		s := NewBalanceService(repo, config, nil, logger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, s.ProcessPendingOrders(ctx))
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, checks, 50)
+	for path, n := range checks {
+		require.Equal(t, 1, n, "order %s checked more than once", path)
+	}
+
+	// every order is credited exactly once
+	entries, err := repo.GetLedgerEntriesByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, entries, 50)
+
+	balance, err := repo.GetUserBalance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.NewMoney(500), balance.Current)
+}
+
+// builds valid (Luhn-compliant) order number from the sequence number
+func luhnOrderNumber(i int) string {
+	base := fmt.Sprintf("%d", 1_000_000+i)
+	for d := 0; d <= 9; d++ {
+		n := fmt.Sprintf("%s%d", base, d)
+		if ok, _ := common.CheckLuhn(n); ok {
+			return n
+		}
+	}
+	panic("unreachable")
+}
+
+func TestBalanceService_WithdrawNoDrift(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute}
+	logger := logging.NewLogger()
+
+	s := &BalanceService{
+		repository: repo,
+		config:     config,
+		logger:     logger,
+	}
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+	creditUser(t, repo, user.ID, models.NewMoney(30))
+
+	// 3000 withdrawals of 0.01 should spend exactly 30 points
+	cent := models.Money(1)
+	for i := 0; i < 3000; i++ {
+		err := s.Withdraw(ctx, user.ID, &models.WithdrawalRequestDTO{Order: luhnOrderNumber(i), Sum: cent})
+		require.NoError(t, err)
+	}
+
+	balance, err := s.GetUserBalance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Money(0), balance.Current)
+	require.Equal(t, models.NewMoney(30), balance.Withdrawn)
+
+	err = s.Withdraw(ctx, user.ID, &models.WithdrawalRequestDTO{Order: luhnOrderNumber(3000), Sum: cent})
+	require.ErrorIs(t, err, common.ErrorInsufficientBalance)
+}
+
+func TestBalanceService_WithdrawConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute}
+	logger := logging.NewLogger()
+
+	s := &BalanceService{
+		repository: repo,
+		config:     config,
+		logger:     logger,
+	}
+
+	user, err := repo.AddUser(ctx, &models.User{Login: "login", Password: "password"})
+	require.NoError(t, err)
+	creditUser(t, repo, user.ID, models.NewMoney(10))
+
+	var succeeded, insufficient atomic.Int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Withdraw(ctx, user.ID, &models.WithdrawalRequestDTO{Order: luhnOrderNumber(i), Sum: models.NewMoney(1)})
+			if errors.Is(err, common.ErrorInsufficientBalance) {
+				insufficient.Add(1)
+				return
+			}
+			require.NoError(t, err)
+			succeeded.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(10), succeeded.Load())
+	require.Equal(t, int32(40), insufficient.Load())
+
+	balance, err := s.GetUserBalance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Money(0), balance.Current)
+	require.Equal(t, models.NewMoney(10), balance.Withdrawn)
+}
+
+// adds points to the user balance
+func creditUser(t *testing.T, repo repository.Repository, userID string, amount models.Money) {
+	t.Helper()
+
+	err := repo.AddLedgerEntry(context.Background(), &models.LedgerEntry{UserID: userID, Type: models.LedgerEntryAdjustment, Amount: amount})
+	require.NoError(t, err)
+}
+
+// withdraws points from the user balance bypassing the balance check
+func debitUser(t *testing.T, repo repository.Repository, userID string, amount models.Money) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	w := &models.Withdrawal{UserID: userID, Amount: amount, UploadedAt: time.Now(), Order: uuid.NewString()}
+	err := repo.AddWithdrawal(ctx, w)
+	require.NoError(t, err)
+
+	err = repo.AddLedgerEntry(ctx, &models.LedgerEntry{UserID: userID, Type: models.LedgerEntryWithdrawal, Amount: -amount, WithdrawalID: w.ID})
+	require.NoError(t, err)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+)
+
+const (
+	// readiness probes are frequent, so the accrual system is not asked more often than this
+	accrualHealthCacheTTL = 10 * time.Second
+	healthCheckTimeout    = 2 * time.Second
+)
+
+// accrualHealth is the last result of the accrual system reachability check
+type accrualHealth struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+type HealthService struct {
+	repository   repository.Repository
+	config       *config.Config
+	logger       *slog.Logger
+	accrual      accrualHealth
+	shuttingDown atomic.Bool
+}
+
+func NewHealthService(r repository.Repository, c *config.Config, l *slog.Logger) *HealthService {
+	return &HealthService{repository: r, config: c, logger: l}
+}
+
+// SetShuttingDown makes the readiness fail, so that no new requests are routed to the instance
+func (s *HealthService) SetShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+// any response means the accrual system is reachable, even an error status
+func (s *HealthService) pingAccrualSystem(ctx context.Context) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.config.AccrualSystemAddress, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *HealthService) checkAccrualSystem(ctx context.Context) error {
+
+	// concurrent probes wait for the one checking instead of checking too
+	s.accrual.mu.Lock()
+	defer s.accrual.mu.Unlock()
+
+	if !s.accrual.checkedAt.IsZero() && time.Since(s.accrual.checkedAt) < accrualHealthCacheTTL {
+		return s.accrual.err
+	}
+
+	s.accrual.err = s.pingAccrualSystem(ctx)
+	s.accrual.checkedAt = time.Now()
+
+	return s.accrual.err
+}
+
+// Ready checks the dependencies, the in-memory storage has nothing to check
+// and the accrual system is not checked if its address is not configured
+func (s *HealthService) Ready(ctx context.Context) *models.HealthDTO {
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]error{}
+
+	if s.shuttingDown.Load() {
+		checks["shutdown"] = common.ErrorShuttingDown
+	}
+
+	if db, ok := s.repository.(repository.DBStorage); ok {
+		checks["database"] = db.Ping(ctx)
+		if checks["database"] == nil {
+			checks["migrations"] = db.CheckMigrations(ctx)
+		}
+	}
+
+	if s.config.AccrualSystemAddress != "" {
+		checks["accrual_system"] = s.checkAccrualSystem(ctx)
+	}
+
+	result := &models.HealthDTO{Status: models.HealthStatusOK, Checks: map[string]string{}}
+
+	for name, err := range checks {
+		if err == nil {
+			result.Checks[name] = models.HealthStatusOK
+			continue
+		}
+		result.Status = models.HealthStatusFail
+		result.Checks[name] = err.Error()
+		logging.FromContext(ctx, s.logger).Warn("Readiness check failed", "check", name, "err", err.Error())
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/stretchr/testify/require"
+)
+
+// testDBStorage makes the in-memory repository look like a database
+type testDBStorage struct {
+	*repository.InMemoryRepository
+	pingErr       error
+	migrationsErr error
+}
+
+func (r *testDBStorage) RunMigrations(ctx context.Context) error   { return nil }
+func (r *testDBStorage) Ping(ctx context.Context) error            { return r.pingErr }
+func (r *testDBStorage) CheckMigrations(ctx context.Context) error { return r.migrationsErr }
+func (r *testDBStorage) Close() error                              { return nil }
+
+func TestHealthService_Ready(t *testing.T) {
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// any response means the accrual system is reachable
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer accrual.Close()
+
+	inMemory, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+	repo := &testDBStorage{InMemoryRepository: inMemory}
+
+	s := NewHealthService(repo, &config.Config{AccrualSystemAddress: accrual.URL}, logging.NewLogger())
+
+	got := s.Ready(ctx)
+	require.Equal(t, &models.HealthDTO{Status: models.HealthStatusOK, Checks: map[string]string{
+		"database": "ok", "migrations": "ok", "accrual_system": "ok",
+	}}, got)
+
+	// migrations are not checked while the database is unreachable
+	repo.pingErr = errors.New("connection refused")
+	repo.migrationsErr = common.ErrorMigrationsPending
+	got = s.Ready(ctx)
+	require.Equal(t, models.HealthStatusFail, got.Status)
+	require.Equal(t, "connection refused", got.Checks["database"])
+	require.NotContains(t, got.Checks, "migrations")
+
+	repo.pingErr = nil
+	got = s.Ready(ctx)
+	require.Equal(t, models.HealthStatusFail, got.Status)
+	require.Equal(t, common.ErrorMigrationsPending.Error(), got.Checks["migrations"])
+
+	// accrual system result is cached
+	require.Equal(t, int32(1), calls.Load())
+
+	// cached result is reused until it expires, even though the accrual system is down now
+	accrual.Close()
+	repo.migrationsErr = nil
+	require.Equal(t, models.HealthStatusOK, s.Ready(ctx).Status)
+
+	s.accrual.checkedAt = s.accrual.checkedAt.Add(-accrualHealthCacheTTL)
+	got = s.Ready(ctx)
+	require.Equal(t, models.HealthStatusFail, got.Status)
+	require.NotEqual(t, models.HealthStatusOK, got.Checks["accrual_system"])
+}
+
+func TestHealthService_ShuttingDown(t *testing.T) {
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	// nothing to check for the in-memory storage without the accrual system
+	s := NewHealthService(repo, &config.Config{}, logging.NewLogger())
+	require.Equal(t, &models.HealthDTO{Status: models.HealthStatusOK, Checks: map[string]string{}}, s.Ready(context.Background()))
+
+	s.SetShuttingDown()
+	got := s.Ready(context.Background())
+	require.Equal(t, models.HealthStatusFail, got.Status)
+	require.Equal(t, common.ErrorShuttingDown.Error(), got.Checks["shutdown"])
+}
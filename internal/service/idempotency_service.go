@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+)
+
+// request that was started but never completed (e.g. the instance crashed) stops
+// blocking the key after this period
+const idempotencyPendingTimeout = time.Minute
+
+type IdempotencyService struct {
+	repository repository.Repository
+	config     *config.Config
+	logger     *slog.Logger
+}
+
+func NewIdempotencyService(r repository.Repository, c *config.Config, l *slog.Logger) *IdempotencyService {
+	return &IdempotencyService{repository: r, config: c, logger: l}
+}
+
+// Start reserves the key for the request. If the key has already been used for the same request
+// the stored response is returned and the request should not be repeated
+func (s *IdempotencyService) Start(ctx context.Context, userID string, key string, requestHash string) (*models.IdempotencyKey, error) {
+
+	k := &models.IdempotencyKey{UserID: userID, Key: key, RequestHash: requestHash}
+
+	err := s.repository.AddIdempotencyKey(ctx, k)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, common.ErrorAlreadyExists) {
+		return nil, err
+	}
+
+	existing, err := s.repository.FindIdempotencyKey(ctx, userID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// expired key is not purged yet, it may be used again as if it was new
+	if !s.expired(existing) {
+		if existing.RequestHash != requestHash {
+			return nil, common.ErrorIdempotencyKeyMismatch
+		}
+
+		if existing.StatusCode != 0 {
+			return &existing, nil
+		}
+
+		if time.Since(existing.CreatedAt) < idempotencyPendingTimeout {
+			return nil, common.ErrorIdempotencyKeyInProgress
+		}
+
+		// original request was abandoned, taking the key over
+		logging.FromContext(ctx, s.logger).Warn("Taking over abandoned idempotency key", "user_id", userID, "key", key)
+	}
+
+	if err := s.repository.DeleteIdempotencyKey(ctx, userID, key); err != nil {
+		return nil, err
+	}
+
+	err = s.repository.AddIdempotencyKey(ctx, k)
+	if errors.Is(err, common.ErrorAlreadyExists) {
+		return nil, common.ErrorIdempotencyKeyInProgress
+	}
+
+	return nil, err
+}
+
+func (s *IdempotencyService) expired(k models.IdempotencyKey) bool {
+	return s.config.IdempotencyKeyTTL > 0 && time.Since(k.CreatedAt) >= s.config.IdempotencyKeyTTL
+}
+
+// Complete stores the response so that retries get the same result
+func (s *IdempotencyService) Complete(ctx context.Context, userID string, key string, statusCode int,
+	headers map[string][]string, body []byte) error {
+	return s.repository.CompleteIdempotencyKey(ctx, userID, key, statusCode, headers, body)
+}
+
+// Abandon releases the key so that the request may be retried
+func (s *IdempotencyService) Abandon(ctx context.Context, userID string, key string) error {
+	return s.repository.DeleteIdempotencyKey(ctx, userID, key)
+}
+
+// PurgeExpired deletes the keys older than the configured TTL, keys are kept forever if it is not set
+func (s *IdempotencyService) PurgeExpired(ctx context.Context) error {
+	if s.config.IdempotencyKeyTTL <= 0 {
+		return nil
+	}
+
+	n, err := s.repository.DeleteIdempotencyKeysBefore(ctx, time.Now().Add(-s.config.IdempotencyKeyTTL))
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx, s.logger).Info("Expired idempotency keys purged", "count", n)
+	return nil
+}
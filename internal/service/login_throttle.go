@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+)
+
+// loginThrottle limits failed logins of a single key: a login or a client address.
+// The counters are kept in the repository, so that all replicas share them
+type loginThrottle struct {
+	key         string
+	maxFailures int           // the key is locked after so many failures in a row, 0 disables the throttle
+	lockout     time.Duration // lock duration, failures older than that are forgotten
+	progressive bool          // every failure doubles the pause before the next attempt is allowed
+}
+
+// retryAt returns the moment the next attempt is allowed at
+func (t loginThrottle) retryAt(state models.LoginThrottle) time.Time {
+	if state.Failures >= t.maxFailures {
+		return state.LastFailureAt.Add(t.lockout)
+	}
+	if !t.progressive || state.Failures < 1 {
+		return state.LastFailureAt
+	}
+
+	delay := time.Duration(math.Pow(2, float64(state.Failures-1))) * time.Second
+	return state.LastFailureAt.Add(min(delay, t.lockout))
+}
+
+// logins are case-insensitive, so are their counters
+func loginThrottleKey(login string) string {
+	return "login:" + strings.ToLower(login)
+}
+
+func (s *AuthService) loginThrottles(login string, ip string) []loginThrottle {
+	throttles := []loginThrottle{{
+		key:         loginThrottleKey(login),
+		maxFailures: s.config.LoginMaxFailures,
+		lockout:     s.config.LoginLockoutDuration,
+		progressive: true,
+	}}
+
+	// many users may share the address, so there are no delays, only the lockout
+	if ip != "" {
+		throttles = append(throttles, loginThrottle{
+			key:         "ip:" + ip,
+			maxFailures: s.config.LoginMaxFailuresPerIP,
+			lockout:     s.config.LoginLockoutDuration,
+		})
+	}
+
+	enabled := throttles[:0]
+	for _, t := range throttles {
+		if t.maxFailures > 0 {
+			enabled = append(enabled, t)
+		}
+	}
+	return enabled
+}
+
+// checkLoginThrottles returns common.RetryAfterError if any of the keys is paused or locked
+func (s *AuthService) checkLoginThrottles(ctx context.Context, throttles []loginThrottle, now time.Time) error {
+
+	var retryAt time.Time
+
+	for _, t := range throttles {
+		state, err := s.repository.FindLoginThrottle(ctx, t.key)
+		if errors.Is(err, common.ErrorNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if at := t.retryAt(state); at.After(retryAt) {
+			retryAt = at
+		}
+	}
+
+	if retryAt.After(now) {
+		return &common.RetryAfterError{Err: common.ErrorTooManyLoginAttempts, RetryAfter: retryAt.Sub(now)}
+	}
+
+	return nil
+}
+
+func (s *AuthService) recordLoginFailure(ctx context.Context, throttles []loginThrottle, now time.Time) error {
+	for _, t := range throttles {
+		if _, err := s.repository.RecordLoginFailure(ctx, t.key, now, now.Add(-t.lockout)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the audit record is not essential for the login, so the failure is only logged
+func (s *AuthService) auditLoginAttempt(ctx context.Context, login string, ip string, result models.LoginAttemptResult) {
+	err := s.repository.AddLoginAttempt(ctx, &models.LoginAttempt{Login: login, IP: ip, Result: result})
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Error("Login attempt audit failed", "login", login, "ip", ip, "result", result, "error", err)
+	}
+}
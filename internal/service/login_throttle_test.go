@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loginThrottle_retryAt(t *testing.T) {
+	now := time.Now()
+	throttle := loginThrottle{maxFailures: 5, lockout: time.Minute, progressive: true}
+
+	tests := []struct {
+		name        string
+		failures    int
+		progressive bool
+		want        time.Time
+	}{
+		{"First failure", 1, true, now.Add(time.Second)},
+		{"Third failure", 3, true, now.Add(4 * time.Second)},
+		{"Locked", 5, true, now.Add(time.Minute)},
+		{"No delays", 3, false, now},
+		{"Locked without delays", 5, false, now.Add(time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle.progressive = tt.progressive
+			got := throttle.retryAt(models.LoginThrottle{Failures: tt.failures, LastFailureAt: now})
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAuthService_LoginThrottle(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo, err := repository.NewInMemoryRepository()
+	require.NoError(t, err)
+
+	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute, RefreshTokenValidityDuration: time.Hour,
+		LoginMaxFailures: 3, LoginMaxFailuresPerIP: 4, LoginLockoutDuration: time.Minute}
+	s := &AuthService{
+		repository: repo,
+		config:     config,
+		keys:       auth.NewHMACKeySet(config.SecretKey),
+		policy:     &auth.CredentialsPolicy{},
+		logger:     logging.NewLogger(),
+	}
+
+	_, err = s.Register(ctx, "login", "password")
+	require.NoError(t, err)
+
+	requireRetryAfter := func(t *testing.T, err error, min time.Duration, max time.Duration) {
+		t.Helper()
+		var retryErr *common.RetryAfterError
+		require.ErrorAs(t, err, &retryErr)
+		require.ErrorIs(t, err, common.ErrorTooManyLoginAttempts)
+		require.Greater(t, retryErr.RetryAfter, min)
+		require.LessOrEqual(t, retryErr.RetryAfter, max)
+	}
+
+	t.Run("Progressive delay", func(t *testing.T) {
+		_, err := s.Login(ctx, "login", "wrongpassword", "10.0.0.1")
+		require.ErrorIs(t, err, common.ErrorInvalidLoginPassword)
+
+		// even the right password is refused during the pause, the login is case-insensitive
+		_, err = s.Login(ctx, "LOGIN", "password", "10.0.0.2")
+		requireRetryAfter(t, err, 0, time.Second)
+	})
+
+	t.Run("Lockout", func(t *testing.T) {
+		// two more failures made a while ago
+		past := time.Now().Add(-10 * time.Second)
+		for range 2 {
+			_, err := repo.RecordLoginFailure(ctx, loginThrottleKey("login"), past, past.Add(-time.Minute))
+			require.NoError(t, err)
+		}
+
+		_, err := s.Login(ctx, "login", "password", "10.0.0.2")
+		requireRetryAfter(t, err, 45*time.Second, 50*time.Second)
+	})
+
+	t.Run("Success resets login", func(t *testing.T) {
+		err := repo.DeleteLoginThrottle(ctx, loginThrottleKey("login"))
+		require.NoError(t, err)
+
+		// the pause after a single failure is over
+		past := time.Now().Add(-5 * time.Second)
+		_, err = repo.RecordLoginFailure(ctx, loginThrottleKey("login"), past, past.Add(-time.Minute))
+		require.NoError(t, err)
+
+		_, err = s.Login(ctx, "login", "password", "10.0.0.3")
+		require.NoError(t, err)
+
+		_, err = repo.FindLoginThrottle(ctx, loginThrottleKey("login"))
+		require.ErrorIs(t, err, common.ErrorNotFound)
+	})
+
+	t.Run("Address lockout", func(t *testing.T) {
+		// unknown logins are failures as well
+		for _, login := range []string{"unknown1", "unknown2", "unknown3", "unknown4"} {
+			_, err := s.Login(ctx, login, "password", "10.0.0.4")
+			require.ErrorIs(t, err, common.ErrorInvalidLoginPassword)
+		}
+
+		_, err := s.Login(ctx, "login", "password", "10.0.0.4")
+		requireRetryAfter(t, err, 55*time.Second, time.Minute)
+
+		_, err = s.Login(ctx, "login", "password", "10.0.0.5")
+		require.NoError(t, err)
+	})
+}
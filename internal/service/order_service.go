@@ -8,8 +8,13 @@ import (
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type OrderStatus int
@@ -22,15 +27,32 @@ const (
 	OrderStatusAccepted
 )
 
+// result label of the order in the metrics
+func (s OrderStatus) String() string {
+	switch s {
+	case OrderStatusSubmittedByAnotherUser:
+		return "conflict"
+	case OrderStatusInvalidNumberFormat:
+		return "invalid_number"
+	case OrderStatusSubmittedByThisUser:
+		return "duplicate"
+	case OrderStatusAccepted:
+		return "accepted"
+	default:
+		return "error"
+	}
+}
+
 type OrderService struct {
 	baseService BaseService
 	repository  repository.Repository
 	config      *config.Config
 	logger      *slog.Logger
+	metrics     *metrics.Metrics
 }
 
-func NewOrderService(r repository.Repository, c *config.Config, l *slog.Logger) *OrderService {
-	return &OrderService{repository: r, config: c, logger: l, baseService: BaseService{}}
+func NewOrderService(r repository.Repository, c *config.Config, m *metrics.Metrics, l *slog.Logger) *OrderService {
+	return &OrderService{repository: r, config: c, metrics: m, logger: l, baseService: BaseService{}}
 }
 
 func newOrder(userID string, number string) (*models.Order, error) {
@@ -65,7 +87,24 @@ func newOrder(userID string, number string) (*models.Order, error) {
 
 func (s *OrderService) RegisterOrderNumber(ctx context.Context, userID string, number string) OrderStatus {
 
-	tx, err := s.repository.UnitOfWork().Begin(ctx)
+	ctx, span := tracing.Start(ctx, "OrderService.RegisterOrderNumber")
+	defer span.End()
+
+	// counted once the transaction is over
+	status := s.registerOrderNumber(ctx, userID, number)
+	s.metrics.IncOrders(status.String())
+
+	span.SetAttributes(attribute.String("order.result", status.String()))
+	if status == OrderStatusInternalError {
+		span.SetStatus(codes.Error, status.String())
+	}
+
+	return status
+}
+
+func (s *OrderService) registerOrderNumber(ctx context.Context, userID string, number string) OrderStatus {
+
+	ctx, tx, err := s.repository.UnitOfWork().Begin(ctx)
 	if err != nil {
 		return OrderStatusInternalError
 	}
@@ -107,14 +146,32 @@ func (s *OrderService) RegisterOrderNumber(ctx context.Context, userID string, n
 
 }
 
-func (s *OrderService) GetOrderList(ctx context.Context, userID string) ([]models.Order, error) {
+// GetOrderList returns a page of user orders and the cursor of the next page,
+// which is nil if there are no more orders or the filter has no limit
+func (s *OrderService) GetOrderList(ctx context.Context, userID string, filter models.OrderListFilter) (orders []models.Order, next *models.Cursor, err error) {
+
+	ctx, span := tracing.Start(ctx, "OrderService.GetOrderList")
+	defer tracing.End(span, &err)
+
+	// fetching one more order to know if there is the next page
+	pageSize := filter.Limit
+	if pageSize > 0 {
+		filter.Limit++
+	}
 
-	orders, err := s.repository.GetOrdersByUserID(ctx, userID)
+	orders, err = s.repository.GetOrdersByUserID(ctx, userID, filter)
 	if err != nil {
-		s.logger.ErrorContext(ctx, err.Error())
-		return nil, err
+		logging.FromContext(ctx, s.logger).Error(err.Error())
+		return nil, nil, err
+	}
+
+	if pageSize == 0 || len(orders) <= pageSize {
+		return orders, nil, nil
 	}
 
-	return orders, nil
+	orders = orders[:pageSize]
+	last := orders[pageSize-1]
+
+	return orders, &models.Cursor{UploadedAt: last.UploadedAt, ID: last.ID}, nil
 
 }
@@ -3,14 +3,17 @@ package service
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/logging"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/models"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
 	"github.com/go-playground/assert/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -29,11 +32,8 @@ func TestOrderService_RegisterOrderNumber(t *testing.T) {
 	config := &config.Config{SecretKey: "secretkey", TokenValidityDuration: 1 * time.Minute}
 	logger := logging.NewLogger()
 
-	s := &OrderService{
-		repository: repo,
-		config:     config,
-		logger:     logger,
-	}
+	m := metrics.New()
+	s := NewOrderService(repo, config, m, logger)
 
 	type args struct {
 		userID string
@@ -57,6 +57,16 @@ func TestOrderService_RegisterOrderNumber(t *testing.T) {
 			}
 		})
 	}
+
+	expected := `
+# HELP gophermart_orders_total Uploaded order numbers by result.
+# TYPE gophermart_orders_total counter
+gophermart_orders_total{result="accepted"} 1
+gophermart_orders_total{result="conflict"} 1
+gophermart_orders_total{result="duplicate"} 1
+gophermart_orders_total{result="invalid_number"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(m.Registry(), strings.NewReader(expected), "gophermart_orders_total"))
 }
 
 func TestOrderService_GetOrderList(t *testing.T) {
@@ -74,10 +84,15 @@ func TestOrderService_GetOrderList(t *testing.T) {
 	user2, err := repo.AddUser(ctx, &models.User{Login: "login2", Password: "password2"})
 	require.NoError(t, err)
 
-	order1, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: "123"})
+	now := time.Now().Truncate(time.Second)
+
+	order1, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: "123", UploadedAt: now, Status: models.OrderStatusNew})
+	require.NoError(t, err)
+
+	order2, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: "234", UploadedAt: now.Add(-time.Minute), Status: models.OrderStatusProcessed})
 	require.NoError(t, err)
 
-	order2, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: "234"})
+	order3, err := repo.AddOrder(ctx, &models.Order{UserID: user.ID, Number: "345", UploadedAt: now.Add(-2 * time.Minute), Status: models.OrderStatusNew})
 	require.NoError(t, err)
 
 	s := &OrderService{
@@ -89,23 +104,39 @@ func TestOrderService_GetOrderList(t *testing.T) {
 	type args struct {
 		ctx    context.Context
 		userID string
+		filter models.OrderListFilter
 	}
 	tests := []struct {
-		name    string
-		args    args
-		want    []models.Order
-		wantErr bool
+		name     string
+		args     args
+		want     []models.Order
+		wantNext bool
+		wantErr  bool
 	}{
-		{"User1", args{ctx, user.ID}, []models.Order{order1, order2}, false},
-		{"User2", args{ctx, user2.ID}, []models.Order{}, false},
+		{"User1", args{ctx, user.ID, models.OrderListFilter{}}, []models.Order{order1, order2, order3}, false, false},
+		{"User2", args{ctx, user2.ID, models.OrderListFilter{}}, []models.Order{}, false, false},
+		{"First page", args{ctx, user.ID, models.OrderListFilter{ListFilter: models.ListFilter{Limit: 2}}},
+			[]models.Order{order1, order2}, true, false},
+		{"Last page", args{ctx, user.ID, models.OrderListFilter{ListFilter: models.ListFilter{Limit: 2,
+			After: &models.Cursor{UploadedAt: order2.UploadedAt, ID: order2.ID}}}},
+			[]models.Order{order3}, false, false},
+		{"Exact page", args{ctx, user.ID, models.OrderListFilter{ListFilter: models.ListFilter{Limit: 3}}},
+			[]models.Order{order1, order2, order3}, false, false},
+		{"Ascending", args{ctx, user.ID, models.OrderListFilter{ListFilter: models.ListFilter{Ascending: true}}},
+			[]models.Order{order3, order2, order1}, false, false},
+		{"Status", args{ctx, user.ID, models.OrderListFilter{Statuses: []models.OrderStatus{models.OrderStatusNew}}},
+			[]models.Order{order1, order3}, false, false},
+		{"Date range", args{ctx, user.ID, models.OrderListFilter{ListFilter: models.ListFilter{From: order2.UploadedAt, To: order1.UploadedAt}}},
+			[]models.Order{order2}, false, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := s.GetOrderList(tt.args.ctx, tt.args.userID)
+			got, next, err := s.GetOrderList(tt.args.ctx, tt.args.userID, tt.args.filter)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("OrderService.GetOrderList() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			assert.Equal(t, next != nil, tt.wantNext)
 			assert.Equal(t, len(got), len(tt.want))
 			if len(tt.want) > 0 {
 				if !reflect.DeepEqual(got, tt.want) {
@@ -3,21 +3,35 @@ package service
 import (
 	"log/slog"
 
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/auth"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/metrics"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/notifier"
 	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/repository"
 )
 
 type ServiceProvider struct {
-	AuthService    *AuthService
-	OrderService   *OrderService
-	BalanceService *BalanceService
+	Keys               *auth.KeySet
+	TokenOptions       auth.TokenOptions
+	AuthService        *AuthService
+	OrderService       *OrderService
+	BalanceService     *BalanceService
+	IdempotencyService *IdempotencyService
+	AdminService       *AdminService
+	HealthService      *HealthService
+	Metrics            *metrics.Metrics
 }
 
-func NewServiceProvider(repository repository.Repository, config *config.Config, logger *slog.Logger) *ServiceProvider {
+func NewServiceProvider(repository repository.Repository, config *config.Config, keys *auth.KeySet,
+	policy *auth.CredentialsPolicy, notifier notifier.Notifier, metrics *metrics.Metrics, logger *slog.Logger) *ServiceProvider {
 
-	authService := NewAuthService(repository, config, logger)
-	orderService := NewOrderService(repository, config, logger)
-	balanceService := NewBalanceService(repository, config, logger)
+	authService := NewAuthService(repository, config, keys, policy, notifier, metrics, logger)
+	orderService := NewOrderService(repository, config, metrics, logger)
+	balanceService := NewBalanceService(repository, config, metrics, logger)
+	idempotencyService := NewIdempotencyService(repository, config, logger)
+	adminService := NewAdminService(repository, config, logger)
+	healthService := NewHealthService(repository, config, logger)
 
-	return &ServiceProvider{AuthService: authService, OrderService: orderService, BalanceService: balanceService}
+	return &ServiceProvider{Keys: keys, TokenOptions: NewTokenOptions(config), AuthService: authService, OrderService: orderService, BalanceService: balanceService,
+		IdempotencyService: idempotencyService, AdminService: adminService, HealthService: healthService, Metrics: metrics}
 }
@@ -19,14 +19,17 @@ func NewAccrualCheckerTask(c *config.Config, s *service.BalanceService, l *slog.
 	return &AccrualCheckerTask{config: c, service: s, logger: l}
 }
 
+// Start polls until ctx is cancelled, it returns once the orders being checked are finished
 func (t *AccrualCheckerTask) Start(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
+			t.logger.Info("Accrual checker stopped")
 			return
 		case <-time.After(3 * time.Second):
 			err := t.service.ProcessPendingOrders(ctx)
-			if err != nil {
+			// errors caused by the shutdown are expected
+			if err != nil && ctx.Err() == nil {
 				t.logger.ErrorContext(ctx, "Error processing task", "err", err.Error())
 			}
 		}
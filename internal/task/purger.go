@@ -0,0 +1,37 @@
+package task
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/service"
+)
+
+// expired keys are only a storage concern, they are treated as absent until purged
+const idempotencyPurgeInterval = time.Hour
+
+type IdempotencyPurgeTask struct {
+	service *service.IdempotencyService
+	logger  *slog.Logger
+}
+
+func NewIdempotencyPurgeTask(s *service.IdempotencyService, l *slog.Logger) *IdempotencyPurgeTask {
+	return &IdempotencyPurgeTask{service: s, logger: l}
+}
+
+// Start purges the expired idempotency keys until ctx is cancelled
+func (t *IdempotencyPurgeTask) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			t.logger.Info("Idempotency key purger stopped")
+			return
+		case <-time.After(idempotencyPurgeInterval):
+			err := t.service.PurgeExpired(ctx)
+			if err != nil && ctx.Err() == nil {
+				t.logger.ErrorContext(ctx, "Error purging idempotency keys", "err", err.Error())
+			}
+		}
+	}
+}
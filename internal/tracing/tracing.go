@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/common"
+	"github.com/dmitrijs2005/gophermart-loyalty-system/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName         = "gophermart"
+	instrumentationName = "github.com/dmitrijs2005/gophermart-loyalty-system"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+func newExporter(ctx context.Context, c *config.Config) (sdktrace.SpanExporter, error) {
+	switch c.TraceExporter {
+	case ExporterStdout:
+		return stdouttrace.New()
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if c.TraceEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(c.TraceEndpoint))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("%w: %s", common.ErrorUnknownTraceExporter, c.TraceExporter)
+	}
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator,
+// the returned function flushes the spans which are not exported yet
+func Setup(ctx context.Context, c *config.Config) (func(context.Context) error, error) {
+
+	// incoming trace context is passed on even if we don't export our own spans
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if c.TraceExporter == "" || c.TraceExporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the application from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts an internal span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if there is one, and ends the span,
+// should be deferred with the pointer to the named error result
+func End(span trace.Span, errPtr *error) {
+	if errPtr != nil && *errPtr != nil {
+		RecordError(span, *errPtr)
+	}
+	span.End()
+}
+
+// RecordError marks the span failed, missing records are expected outcomes and are not errors
+func RecordError(span trace.Span, err error) {
+	if errors.Is(err, common.ErrorNotFound) {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders ADD COLUMN next_check_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE orders ADD COLUMN attempt_count INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX idx_orders_next_check_at ON orders (next_check_at) WHERE status IN ('NEW', 'PROCESSING');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_orders_next_check_at;
+ALTER TABLE orders DROP COLUMN next_check_at;
+ALTER TABLE orders DROP COLUMN attempt_count;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders ADD COLUMN leased_by TEXT;
+ALTER TABLE orders ADD COLUMN leased_until TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE orders DROP COLUMN leased_by;
+ALTER TABLE orders DROP COLUMN leased_until;
+-- +goose StatementEnd
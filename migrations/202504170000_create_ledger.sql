@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE ledger_entries (
+    id uuid DEFAULT gen_random_uuid(),
+    seq BIGSERIAL NOT NULL,  -- order of entries, running balance is taken from the latest one
+    user_id uuid NOT NULL,
+    entry_type TEXT NOT NULL,
+    amount NUMERIC(15, 2) NOT NULL,  -- credit is positive, debit is negative
+    balance NUMERIC(15, 2) NOT NULL,  -- running balance after the entry
+    order_id uuid,
+    withdrawal_id uuid,
+    created_at TIMESTAMPTZ DEFAULT now(),
+
+    PRIMARY KEY (id)  -- PK
+);
+
+CREATE INDEX idx_ledger_entries_user_id_seq ON ledger_entries (user_id, seq);
+
+-- every order is credited and every withdrawal is debited only once
+CREATE UNIQUE INDEX unique_ledger_accrual_order ON ledger_entries (order_id) WHERE entry_type = 'ACCRUAL';
+CREATE UNIQUE INDEX unique_ledger_withdrawal ON ledger_entries (withdrawal_id, entry_type) WHERE withdrawal_id IS NOT NULL;
+
+-- moving existing history to the ledger
+INSERT INTO ledger_entries (user_id, entry_type, amount, balance, order_id, withdrawal_id, created_at)
+SELECT user_id, entry_type, amount,
+    sum(amount) OVER (PARTITION BY user_id ORDER BY created_at, entry_type, source_id ROWS UNBOUNDED PRECEDING),
+    order_id, withdrawal_id, created_at
+FROM (
+    SELECT user_id, 'ACCRUAL' AS entry_type, accrual AS amount, id AS order_id, NULL::uuid AS withdrawal_id,
+        uploaded_at AS created_at, id AS source_id
+    FROM orders WHERE status = 'PROCESSED' AND accrual > 0
+    UNION ALL
+    SELECT user_id, 'WITHDRAWAL', -amount, NULL, id, uploaded_at, id
+    FROM withdrawals
+) history
+ORDER BY user_id, created_at, entry_type, source_id;
+
+ALTER TABLE users DROP COLUMN accrued_total;
+ALTER TABLE users DROP COLUMN withdrawn_total;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN accrued_total NUMERIC(15, 2) DEFAULT 0;
+ALTER TABLE users ADD COLUMN withdrawn_total NUMERIC(15, 2) DEFAULT 0;
+
+UPDATE users SET
+    accrued_total = coalesce((SELECT sum(amount) FROM ledger_entries l WHERE l.user_id = users.id AND l.withdrawal_id IS NULL), 0),
+    withdrawn_total = coalesce((SELECT -sum(amount) FROM ledger_entries l WHERE l.user_id = users.id AND l.withdrawal_id IS NOT NULL), 0);
+
+DROP TABLE ledger_entries;
+-- +goose StatementEnd
@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+-- an order could have been paid more than once before the index existed, only the earliest
+-- withdrawal stays in force, the rest are kept for the audit trail, marked as reversed duplicates
+-- of it and refunded by REVERSAL ledger entries; the statements are safe to run again after Down
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversed_at TIMESTAMPTZ;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversed_by uuid;  -- admin who reversed the withdrawal, NULL for duplicates
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS duplicate_of uuid REFERENCES withdrawals (id);
+
+UPDATE withdrawals w SET duplicate_of = d.first_id, reversed_at = coalesce(w.reversed_at, now())
+FROM (
+    SELECT id, first_value(id) OVER (PARTITION BY "order" ORDER BY uploaded_at, id) AS first_id
+    FROM withdrawals
+) d
+WHERE w.id = d.id AND d.first_id <> d.id;
+
+INSERT INTO ledger_entries (user_id, entry_type, amount, balance, withdrawal_id)
+SELECT w.user_id, 'REVERSAL', w.amount,
+    coalesce((SELECT balance FROM ledger_entries l WHERE l.user_id = w.user_id ORDER BY seq DESC LIMIT 1), 0)
+        + sum(w.amount) OVER (PARTITION BY w.user_id ORDER BY w.id ROWS UNBOUNDED PRECEDING),
+    w.id
+FROM withdrawals w
+WHERE w.duplicate_of IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM ledger_entries l WHERE l.withdrawal_id = w.id AND l.entry_type = 'REVERSAL')
+ORDER BY w.user_id, w.id;
+
+CREATE UNIQUE INDEX unique_withdrawal_order ON withdrawals ("order") WHERE duplicate_of IS NULL;
+
+CREATE TABLE idempotency_keys (
+    user_id uuid NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,  -- 0 while the request is in flight
+    body BYTEA,
+    created_at TIMESTAMPTZ DEFAULT now(),
+
+    PRIMARY KEY (user_id, key)  -- PK
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE idempotency_keys;
+DROP INDEX unique_withdrawal_order;
+-- the refunds of the duplicates can not be taken back, the points could have been spent by now
+-- and balances would go negative, so the duplicates stay marked and refunded, the columns are kept
+-- +goose StatementEnd
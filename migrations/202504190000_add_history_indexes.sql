@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- user history is paged by (uploaded_at, id), so the indexes also cover the lookups by user_id
+CREATE INDEX idx_orders_user_id_uploaded_at ON orders (user_id, uploaded_at, id);
+CREATE INDEX idx_withdrawals_user_id_uploaded_at ON withdrawals (user_id, uploaded_at, id);
+
+DROP INDEX idx_orders_user_id;
+DROP INDEX idx_withdrawals_user_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE INDEX idx_orders_user_id ON orders (user_id);
+CREATE INDEX idx_withdrawals_user_id ON withdrawals (user_id);
+
+DROP INDEX idx_orders_user_id_uploaded_at;
+DROP INDEX idx_withdrawals_user_id_uploaded_at;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- PBKDF2 hashes are moved into the PHC string format, the salt is kept in the hash itself
+UPDATE users
+   SET password = '$pbkdf2-sha256$i=100000$' || rtrim(salt, '=') || '$' || rtrim(password, '=')
+ WHERE password NOT LIKE '$%';
+
+ALTER TABLE users DROP COLUMN salt;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- argon2id and bcrypt hashes can not be converted back, such users have to reset their passwords
+ALTER TABLE users ADD COLUMN salt TEXT NOT NULL DEFAULT '';
+
+UPDATE users
+   SET salt = rpad(split_part(password, '$', 4), (length(split_part(password, '$', 4)) + 3) / 4 * 4, '='),
+       password = rpad(split_part(password, '$', 5), (length(split_part(password, '$', 5)) + 3) / 4 * 4, '=')
+ WHERE password LIKE '$pbkdf2-sha256$i=100000$%';
+
+ALTER TABLE users ALTER COLUMN salt DROP DEFAULT;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- logins differing only in case are the same login, existing duplicates have to be resolved before the migration
+DROP INDEX unique_user_login;
+CREATE UNIQUE INDEX unique_user_login ON users (lower(login));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX unique_user_login;
+CREATE UNIQUE INDEX unique_user_login ON users (login);
+-- +goose StatementEnd
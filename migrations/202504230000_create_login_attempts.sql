@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE login_throttles (
+    key TEXT NOT NULL,  -- login:<login> or ip:<address>
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (key)  -- PK
+);
+
+CREATE TABLE login_attempts (
+    id uuid DEFAULT gen_random_uuid(),
+    login TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    result TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+
+    PRIMARY KEY (id)  -- PK
+);
+
+CREATE INDEX idx_login_attempts_login_created_at ON login_attempts (login, created_at);
+CREATE INDEX idx_login_attempts_ip_created_at ON login_attempts (ip, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE login_attempts;
+DROP TABLE login_throttles;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE balance_adjustments (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    amount NUMERIC(15, 2) NOT NULL,  -- credit is positive, debit is negative
+    reason TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    operator_id uuid NOT NULL,  -- admin who made the adjustment
+    created_at TIMESTAMPTZ DEFAULT now(),
+
+    PRIMARY KEY (id)  -- PK
+);
+
+CREATE INDEX idx_balance_adjustments_user_id_created_at ON balance_adjustments (user_id, created_at);
+
+ALTER TABLE ledger_entries ADD COLUMN adjustment_id uuid;
+
+-- every adjustment is applied only once
+CREATE UNIQUE INDEX unique_ledger_adjustment ON ledger_entries (adjustment_id) WHERE adjustment_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX unique_ledger_adjustment;
+ALTER TABLE ledger_entries DROP COLUMN adjustment_id;
+DROP TABLE balance_adjustments;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- reversed withdrawals are kept, the points are returned by the REVERSAL ledger entry
+-- the columns already exist if duplicate withdrawals were reversed by 202504180000
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversed_at TIMESTAMPTZ;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversed_by uuid;  -- admin who reversed the withdrawal
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE withdrawals DROP COLUMN reversed_by;
+ALTER TABLE withdrawals DROP COLUMN reversed_at;
+-- +goose StatementEnd
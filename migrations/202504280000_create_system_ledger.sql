@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every user ledger entry is balanced by the opposite entry in a system account,
+-- so that all the entries of the ledger sum up to zero
+CREATE TABLE system_ledger_entries (
+    id uuid DEFAULT gen_random_uuid(),
+    seq BIGSERIAL NOT NULL,
+    account TEXT NOT NULL,  -- ACCRUALS, WITHDRAWALS or ADJUSTMENTS
+    entry_id uuid NOT NULL REFERENCES ledger_entries (id),
+    amount NUMERIC(15, 2) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+
+    PRIMARY KEY (id)  -- PK
+);
+
+CREATE UNIQUE INDEX unique_system_ledger_entry_id ON system_ledger_entries (entry_id);
+CREATE INDEX idx_system_ledger_entries_account ON system_ledger_entries (account);
+
+-- balancing the existing entries
+INSERT INTO system_ledger_entries (account, entry_id, amount, created_at)
+SELECT CASE entry_type
+        WHEN 'ACCRUAL' THEN 'ACCRUALS'
+        WHEN 'WITHDRAWAL' THEN 'WITHDRAWALS'
+        WHEN 'REVERSAL' THEN 'WITHDRAWALS'
+        ELSE 'ADJUSTMENTS'
+    END,
+    id, -amount, created_at
+FROM ledger_entries
+ORDER BY seq;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE system_ledger_entries;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- replayed responses keep the headers set by the handler, e.g. Content-Type
+ALTER TABLE idempotency_keys ADD COLUMN headers JSONB;
+
+-- expired keys are purged by the creation time
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_idempotency_keys_created_at;
+
+ALTER TABLE idempotency_keys DROP COLUMN headers;
+-- +goose StatementEnd